@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+
+	"github.com/crossplane/function-sdk-go/resource"
+)
+
+// An AuditRecord records a shell command the function ran. It never includes
+// the values of environment variables, files or outputs, which may be secret.
+type AuditRecord struct {
+	Time      time.Time      `json:"time"`
+	Composite AuditComposite `json:"composite"`
+	Tag       string         `json:"tag"`
+	// CommandSHA256 is the SHA-256 hash of the shell command line.
+	CommandSHA256   string   `json:"commandSHA256"`
+	EnvKeys         []string `json:"envKeys"`
+	Files           []string `json:"files,omitempty"`
+	Attempts        int      `json:"attempts"`
+	DurationSeconds float64  `json:"durationSeconds"`
+	ExitCode        int      `json:"exitCode"`
+	TimedOut        bool     `json:"timedOut,omitempty"`
+	StdoutBytes     int      `json:"stdoutBytes"`
+	StderrBytes     int      `json:"stderrBytes"`
+}
+
+// AuditComposite identifies the composite resource a shell command ran for.
+type AuditComposite struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	UID        string `json:"uid"`
+}
+
+// An Auditor writes an AuditRecord for every shell command the function runs,
+// as JSON lines. A nil *Auditor writes nothing.
+type Auditor struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewAuditor returns an Auditor that writes to w.
+func NewAuditor(w io.Writer) *Auditor {
+	return &Auditor{w: w}
+}
+
+// Write an AuditRecord as a line of JSON.
+func (a *Auditor) Write(r AuditRecord) error {
+	if a == nil {
+		return nil
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return errors.Wrap(err, "cannot serialize audit record")
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.w.Write(append(b, '\n'))
+	return errors.Wrap(err, "cannot write audit record")
+}
+
+// newAuditRecord returns an AuditRecord of a shell command that ran for the
+// observed composite resource oxr.
+func newAuditRecord(oxr *resource.Composite, tag, shellCmd string, env map[string]string, files []string, attempts int, res commandResult, d time.Duration, timedOut bool) AuditRecord {
+	keys := make([]string, 0, len(env))
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.Sum256([]byte(shellCmd))
+	return AuditRecord{
+		Time: time.Now().UTC(),
+		Composite: AuditComposite{
+			APIVersion: oxr.Resource.GetAPIVersion(),
+			Kind:       oxr.Resource.GetKind(),
+			Name:       oxr.Resource.GetName(),
+			UID:        string(oxr.Resource.GetUID()),
+		},
+		Tag:             tag,
+		CommandSHA256:   hex.EncodeToString(h[:]),
+		EnvKeys:         keys,
+		Files:           files,
+		Attempts:        attempts,
+		DurationSeconds: d.Seconds(),
+		ExitCode:        res.exitCode(),
+		TimedOut:        timedOut,
+		StdoutBytes:     len(res.stdout),
+		StderrBytes:     len(res.stderr),
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/crossplane/crossplane-runtime/v2/pkg/logging"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+)
+
+func TestAudit(t *testing.T) {
+	oxr := resource.MustStructJSON(`{
+		"apiVersion": "example.org/v1",
+		"kind": "XBucket",
+		"metadata": {"name": "bucket", "uid": "2b0b7f4e-6f1a-4f0e-9d7b-0c6b1a3f0d8e"}
+	}`)
+
+	cases := map[string]struct {
+		reason string
+		input  string
+		want   AuditRecord
+	}{
+		"Success": {
+			reason: "A shell command should be audited with the keys, but not the values, of its environment variables.",
+			input: `{
+				"apiVersion": "template.fn.crossplane.io/v1alpha1",
+				"kind": "Parameters",
+				"shellEnvVars": [{"key": "TOKEN", "value": "s3cr3t"}, {"key": "REGION", "value": "us-east-1"}],
+				"files": [{"path": "input.json", "value": "{}"}],
+				"shellCommand": "echo hello"
+			}`,
+			want: AuditRecord{
+				Composite: AuditComposite{
+					APIVersion: "example.org/v1",
+					Kind:       "XBucket",
+					Name:       "bucket",
+					UID:        "2b0b7f4e-6f1a-4f0e-9d7b-0c6b1a3f0d8e",
+				},
+				Tag:           "hello",
+				CommandSHA256: "584a331fd6b02dcb1ecbe2eba731f609a2e1e3dac0bb73ae998dfad14c309a77",
+				EnvKeys:       []string{"REGION", "TOKEN"},
+				Files:         []string{"input.json"},
+				Attempts:      1,
+				ExitCode:      0,
+				StdoutBytes:   5,
+			},
+		},
+		"TimedOut": {
+			reason: "A shell command that exceeded its timeout should be audited as timed out.",
+			input: `{
+				"apiVersion": "template.fn.crossplane.io/v1alpha1",
+				"kind": "Parameters",
+				"shellCommand": "sleep 10",
+				"timeout": "10ms"
+			}`,
+			want: AuditRecord{
+				Composite: AuditComposite{
+					APIVersion: "example.org/v1",
+					Kind:       "XBucket",
+					Name:       "bucket",
+					UID:        "2b0b7f4e-6f1a-4f0e-9d7b-0c6b1a3f0d8e",
+				},
+				Tag:           "hello",
+				CommandSHA256: "6e636d09792d59c0e1ebba501b7179981193cc6c572c96f3e5c0ca939b27d3e4",
+				EnvKeys:       []string{},
+				Attempts:      1,
+				ExitCode:      -1,
+				TimedOut:      true,
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			buf := &bytes.Buffer{}
+			f := &Function{log: logging.NewNopLogger(), audit: NewAuditor(buf)}
+			req := &fnv1.RunFunctionRequest{
+				Meta:     &fnv1.RequestMeta{Tag: "hello"},
+				Input:    resource.MustStructJSON(tc.input),
+				Observed: &fnv1.State{Composite: &fnv1.Resource{Resource: oxr}},
+			}
+			if _, err := f.RunFunction(context.Background(), req); err != nil {
+				t.Fatal(err)
+			}
+
+			got := AuditRecord{}
+			if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+				t.Fatalf("%s\njson.Unmarshal(%q): %v", tc.reason, buf.String(), err)
+			}
+			if got.Time.IsZero() || got.DurationSeconds <= 0 {
+				t.Errorf("%s\nRunFunction(...): want time and duration, got %v and %v", tc.reason, got.Time, got.DurationSeconds)
+			}
+			if diff := cmp.Diff(tc.want, got, cmpopts.IgnoreFields(AuditRecord{}, "Time", "DurationSeconds")); diff != "" {
+				t.Errorf("%s\nRunFunction(...): -want audit record, +got audit record:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"reflect"
+
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/ext"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+)
+
+// celCostLimit limits the cost of evaluating a CEL expression, so that an
+// expression can't loop over large inputs for long.
+const celCostLimit = 1_000_000
+
+// Variables of CEL expressions.
+const (
+	celVarXR        = "xr"
+	celVarContext   = "context"
+	celVarResources = "resources"
+)
+
+// celRequestVars are the variables of every CEL expression.
+var celRequestVars = []string{celVarXR, celVarContext, celVarResources}
+
+// compileCEL compiles a CEL expression with the supplied dynamically typed
+// variables. Expressions can use the string functions of the CEL extensions,
+// like split and trim.
+func compileCEL(expr string, vars ...string) (cel.Program, error) {
+	opts := []cel.EnvOption{ext.Strings()}
+	for _, v := range vars {
+		opts = append(opts, cel.Variable(v, cel.DynType))
+	}
+	env, err := cel.NewEnv(opts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create CEL environment")
+	}
+	ast, iss := env.Compile(expr)
+	if iss.Err() != nil {
+		return nil, iss.Err()
+	}
+	prg, err := env.Program(ast, cel.CostLimit(celCostLimit))
+	return prg, errors.Wrap(err, "cannot create CEL program")
+}
+
+// celVars returns the variables of the request: the observed composite
+// resource, the pipeline context, and the observed composed resources by
+// name.
+func celVars(req *fnv1.RunFunctionRequest) map[string]any {
+	resources := map[string]any{}
+	for name, r := range req.GetObserved().GetResources() {
+		resources[name] = r.GetResource().AsMap()
+	}
+	return map[string]any{
+		celVarXR:        req.GetObserved().GetComposite().GetResource().AsMap(),
+		celVarContext:   req.GetContext().AsMap(),
+		celVarResources: resources,
+	}
+}
+
+// evalCEL evaluates a CEL expression, and returns its value as a JSON value,
+// like a map[string]any or a float64.
+func evalCEL(expr string, vars map[string]any, names ...string) (any, error) {
+	prg, err := compileCEL(expr, names...)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot compile CEL expression")
+	}
+	out, _, err := prg.Eval(vars)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot evaluate CEL expression")
+	}
+	v, err := out.ConvertToNative(reflect.TypeFor[*structpb.Value]())
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot convert %s value of CEL expression to JSON", out.Type().TypeName())
+	}
+	return v.(*structpb.Value).AsInterface(), nil //nolint:forcetypeassert // ConvertToNative returns the supplied type.
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+)
+
+// waitDelay bounds how long we wait for the output of a shell command to be
+// drained after it was killed because its context was done.
+const waitDelay = 5 * time.Second
+
+// commandResult is the outcome of running a shell command once.
+type commandResult struct {
+	stdout string
+	stderr string
+	err    error
+	// stdoutExceeded is true if stdout was larger than the maximum output
+	// size of the command, and was cut.
+	stdoutExceeded bool
+}
+
+// exitCode returns the exit code of the shell command, or -1 if it did not
+// exit normally.
+func (r commandResult) exitCode() int {
+	if r.err == nil {
+		return 0
+	}
+	exiterr := &exec.ExitError{}
+	if errors.As(r.err, &exiterr) {
+		return exiterr.ExitCode()
+	}
+	return -1
+}
+
+// A command is a shell command line and how to run it.
+type command struct {
+	script string
+	// dir is the working directory of the command. It defaults to the
+	// working directory of the function, or /tmp in a sandbox.
+	dir string
+	// sandbox runs the command in a sandbox if not nil.
+	sandbox *sandboxOptions
+	// maxOutputSize is the maximum number of bytes of stdout that are
+	// kept. Zero means no maximum.
+	maxOutputSize int64
+	// env are environment variables of the command in addition to those
+	// of the function, like NAME=value. Values are passed as is, rather
+	// than through the shell, so they're never expanded.
+	env []string
+	// isolateEnv runs the command with only the PATH and HOME of the
+	// function's environment, in addition to env.
+	isolateEnv bool
+}
+
+// run the shell command with /bin/sh. The whole process group of the shell
+// is killed when ctx is done.
+func (c command) run(ctx context.Context) commandResult {
+	argv := []string{"/bin/sh", "-c", c.script}
+	attr := &syscall.SysProcAttr{Setpgid: true}
+	if c.sandbox != nil {
+		var err error
+		if argv, attr, err = sandboxArgs(argv, *c.sandbox); err != nil {
+			return commandResult{err: err}
+		}
+	}
+
+	var stderr bytes.Buffer
+	stdout := cappedBuffer{max: c.maxOutputSize}
+	cmd := exec.CommandContext(ctx, "/bin/sh")
+	if c.sandbox != nil {
+		cmd = exec.CommandContext(ctx, "/proc/self/exe")
+	}
+	cmd.Args = argv
+	cmd.Dir = c.dir
+	switch {
+	case c.isolateEnv:
+		cmd.Env = isolatedEnv(c.env)
+	case len(c.env) > 0:
+		cmd.Env = append(os.Environ(), c.env...)
+	}
+	if c.sandbox != nil && c.sandbox.isolateProcess && c.dir == "" {
+		// Tell the sandbox to use /tmp. See setupProcessIsolation.
+		cmd.Dir = "/"
+	}
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.SysProcAttr = attr
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = waitDelay
+
+	err := cmd.Run()
+	return commandResult{
+		stdout: strings.TrimSpace(stdout.String()),
+		stderr: strings.TrimSpace(stderr.String()),
+		err:    err,
+
+		stdoutExceeded: stdout.exceeded,
+	}
+}
+
+// isolatedEnv returns the PATH and HOME of the function's environment,
+// followed by env.
+func isolatedEnv(env []string) []string {
+	isolated := make([]string, 0, len(env)+2)
+	for _, name := range []string{"PATH", "HOME"} {
+		if v, ok := os.LookupEnv(name); ok {
+			isolated = append(isolated, name+"="+v)
+		}
+	}
+	return append(isolated, env...)
+}
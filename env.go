@@ -1,85 +1,341 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/csv"
 	"encoding/json"
 	"fmt"
+	"maps"
 	"os"
 	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 
-	"github.com/crossplane-contrib/function-shell/input/v1alpha1"
+	"github.com/crossplane-contrib/function-shell/input/v1beta1"
 	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
 	"github.com/crossplane/crossplane-runtime/v2/pkg/fieldpath"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"sigs.k8s.io/yaml"
 
 	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
 	"github.com/crossplane/function-sdk-go/request"
 	"github.com/crossplane/function-sdk-go/resource"
 )
 
-func addShellEnvVarsFromRef(envVarsRef v1alpha1.ShellEnvVarsRef, shellEnvVars map[string]string) (map[string]string, error) {
-	var envVarsData map[string]string
+var (
+	// contextRefRegex matches field paths that refer to the pipeline
+	// context, like context[apiextensions.crossplane.io/environment].region.
+	contextRefRegex = regexp.MustCompile(`^context\[(.+?)](?:\.(.+))?$`)
 
-	envVars := os.Getenv(envVarsRef.Name)
-	if err := json.Unmarshal([]byte(envVars), &envVarsData); err != nil {
+	// resourcesRefRegex matches field paths that refer to an observed
+	// composed resource, like resources[bucket].status.atProvider.arn.
+	resourcesRefRegex = regexp.MustCompile(`^resources\[(.+?)](?:\.(.+))?$`)
+)
+
+func addShellEnvVarsFromRef(envVarsRef v1beta1.EnvVarRef, shellEnvVars map[string]string) (map[string]string, error) {
+	f := envVarsRef.Format
+	if f == "" {
+		f = v1beta1.EnvFormatJSON
+	}
+	env, err := parseEnv([]byte(os.Getenv(envVarsRef.Name)), f)
+	if err != nil {
 		return shellEnvVars, err
 	}
-	for _, key := range envVarsRef.Keys {
-		shellEnvVars[key] = envVarsData[key]
+	return addEnvKeys(env, envVarsRef.Keys, envVarsRef.OptionalKeys, shellEnvVars)
+}
+
+func fromFieldRef(ctx context.Context, req *fnv1.RunFunctionRequest, fieldRef v1beta1.FieldRef) (string, error) {
+	_, span := tracer().Start(ctx, "ResolveFieldRef", trace.WithAttributes(attribute.String("path", fieldRef.Path)))
+	defer span.End()
+
+	v, err := resolveFieldRef(req, fieldRef)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "cannot resolve fieldRef")
 	}
-	return shellEnvVars, nil
+	return v, err
 }
 
-func fromFieldRef(req *fnv1.RunFunctionRequest, fieldRef v1alpha1.FieldRef) (string, error) {
+func resolveFieldRef(req *fnv1.RunFunctionRequest, fieldRef v1beta1.FieldRef) (string, error) {
 	if fieldRef.Path == "" {
 		return "", errors.New("path must be set")
 	}
-	// Check for context key presence and capture context key and path
-	contextRegex := regexp.MustCompile(`^context\[(.+?)].(.+)$`)
-	if match := contextRegex.FindStringSubmatch(fieldRef.Path); match != nil {
-		if v, ok := request.GetContextKey(req, match[1]); ok {
-			context := &unstructured.Unstructured{}
-			if err := resource.AsObject(v.GetStructValue(), context); err != nil {
-				return "", errors.Wrapf(err, "cannot convert context to %s", v)
+	src, err := getFieldSource(req, fieldRef.Path)
+	if err != nil {
+		return "", err
+	}
+	// A context key that doesn't exist returns the default value, whatever
+	// the policy.
+	if src.object == nil && src.what == fieldSourceContext {
+		return fieldRef.DefaultValue, nil
+	}
+	value, err := src.value()
+	if err != nil {
+		switch fieldRef.Policy {
+		case v1beta1.FieldRefPolicyOptional:
+			return fieldRef.DefaultValue, nil
+		case v1beta1.FieldRefPolicyRequired:
+			fallthrough
+		default:
+			return "", err
+		}
+	}
+	return formatValue(value, fieldRef.Format)
+}
+
+// What a fieldSource is, for errors.
+const (
+	fieldSourceContext   = "context"
+	fieldSourceComposite = "observed composite"
+	fieldSourceComposed  = "observed composed"
+)
+
+// A fieldSource is the object a field path refers to.
+type fieldSource struct {
+	// object is nil if the context key or composed resource doesn't exist.
+	object *fieldpath.Paved
+	// path of the field within the object. Empty for the whole object.
+	path string
+	what string
+	name string
+}
+
+// getFieldSource returns the object the supplied path refers to: a key of the
+// pipeline context like context[key].path, an observed composed resource like
+// resources[name].path, or otherwise the observed composite resource.
+func getFieldSource(req *fnv1.RunFunctionRequest, path string) (*fieldSource, error) {
+	if match := contextRefRegex.FindStringSubmatch(path); match != nil {
+		src := &fieldSource{path: match[2], what: fieldSourceContext, name: match[1]}
+		v, ok := request.GetContextKey(req, match[1])
+		if !ok {
+			return src, nil
+		}
+		context := &unstructured.Unstructured{}
+		if err := resource.AsObject(v.GetStructValue(), context); err != nil {
+			return nil, errors.Wrapf(err, "cannot convert context to %s", v)
+		}
+		src.object = fieldpath.Pave(context.Object)
+		return src, nil
+	}
+	if match := resourcesRefRegex.FindStringSubmatch(path); match != nil {
+		src := &fieldSource{path: match[2], what: fieldSourceComposed, name: match[1]}
+		ocds, err := request.GetObservedComposedResources(req)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot get observed composed resources from %T", req)
+		}
+		if ocd, ok := ocds[resource.Name(match[1])]; ok {
+			src.object = fieldpath.Pave(ocd.Resource.Object)
+		}
+		return src, nil
+	}
+	oxr, err := request.GetObservedCompositeResource(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot get observed composite resource from %T", req)
+	}
+	return &fieldSource{object: fieldpath.Pave(oxr.Resource.Object), path: path, what: fieldSourceComposite}, nil
+}
+
+// value returns the value of the field, or the whole object if the path is
+// empty.
+func (s *fieldSource) value() (any, error) {
+	if s.object == nil {
+		if s.what == fieldSourceContext {
+			return nil, errors.Errorf("context key %s does not exist", s.name)
+		}
+		return nil, errors.Errorf("observed composed resource %s does not exist", s.name)
+	}
+	if s.path == "" {
+		return s.object.UnstructuredContent(), nil
+	}
+	v, err := getFieldValue(s.object, s.path)
+	return v, errors.Wrapf(err, "cannot get %s value", s.what)
+}
+
+// fromEnvFromFieldRef returns an environment variable for every field of the
+// object the supplied reference refers to.
+func fromEnvFromFieldRef(ctx context.Context, req *fnv1.RunFunctionRequest, ref v1beta1.EnvFromFieldRef) (map[string]string, error) {
+	_, span := tracer().Start(ctx, "ImportFieldRef", trace.WithAttributes(attribute.String("path", ref.Path)))
+	defer span.End()
+
+	env, err := importFieldRef(req, ref)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "cannot import fieldRef")
+	}
+	return env, err
+}
+
+func importFieldRef(req *fnv1.RunFunctionRequest, ref v1beta1.EnvFromFieldRef) (map[string]string, error) {
+	if ref.Path == "" {
+		return nil, errors.New("path must be set")
+	}
+	src, err := getFieldSource(req, ref.Path)
+	if err != nil {
+		return nil, err
+	}
+	value, err := src.value()
+	if err != nil {
+		if ref.Policy == v1beta1.FieldRefPolicyOptional {
+			return nil, nil
+		}
+		return nil, err
+	}
+	obj, ok := value.(map[string]any)
+	if !ok {
+		return nil, errors.Errorf("value is a %T, not an object", value)
+	}
+
+	env := map[string]string{}
+	keys := map[string]string{}
+	if err := flattenEnv(obj, "", ref, env, keys); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
+
+// flattenEnv adds an environment variable for every field of the supplied
+// object to env, recursing into nested objects. keys records the key each
+// name was made from, to detect keys that map to the same name.
+func flattenEnv(obj map[string]any, parent string, ref v1beta1.EnvFromFieldRef, env, keys map[string]string) error {
+	for _, k := range slices.Sorted(maps.Keys(obj)) {
+		v := obj[k]
+		key := k
+		if parent != "" {
+			key = parent + "." + k
+		}
+		if nested, ok := v.(map[string]any); ok {
+			if err := flattenEnv(nested, key, ref, env, keys); err != nil {
+				return err
 			}
-			value, err := fieldpath.Pave(context.Object).GetValue(match[2])
-			if err != nil {
-				switch fieldRef.Policy {
-				case v1alpha1.FieldRefPolicyOptional:
-					return fieldRef.DefaultValue, nil
-				case v1alpha1.FieldRefPolicyRequired:
-					fallthrough
-				default:
-					return "", errors.Wrap(err, "cannot get context value")
-				}
+			continue
+		}
+		name := ref.Prefix + envName(key, ref.NameCase)
+		if !envKeyRegex.MatchString(name) {
+			return errors.Errorf("key %s maps to %q, which is not a valid environment variable name", key, name)
+		}
+		if other, ok := keys[name]; ok {
+			a, b := other, key
+			if b < a {
+				a, b = b, a
 			}
-			return fmt.Sprintf("%v", value), nil
+			return errors.Errorf("keys %s and %s both map to %s", a, b, name)
 		}
-	} else {
-		oxr, err := request.GetObservedCompositeResource(req)
+		s, err := formatValue(v, ref.Format)
 		if err != nil {
-			return "", errors.Wrapf(err, "cannot get observed composite resource from %T", req)
+			return errors.Wrapf(err, "cannot format key %s", key)
 		}
-		value, err := oxr.Resource.GetValue(fieldRef.Path)
-		if err != nil {
-			switch fieldRef.Policy {
-			case v1alpha1.FieldRefPolicyOptional:
-				return fieldRef.DefaultValue, nil
-			case v1alpha1.FieldRefPolicyRequired:
-				fallthrough
-			default:
-				return "", errors.Wrap(err, "cannot get observed composite value")
-			}
+		keys[name] = key
+		env[name] = s
+	}
+	return nil
+}
+
+// envName returns the environment variable name of a dot separated key, in
+// the supplied case. Characters that aren't valid in names are replaced with
+// underscores.
+func envName(key string, c v1beta1.EnvNameCase) string {
+	var b strings.Builder
+	var prev rune
+	for _, r := range key {
+		upper, lower, digit := 'A' <= r && r <= 'Z', 'a' <= r && r <= 'z', '0' <= r && r <= '9'
+		if !upper && !lower && !digit {
+			r = '_'
+		}
+		// Split camelCase keys like instanceType into words.
+		if (c == "" || c == v1beta1.EnvNameCaseUpperSnake) && upper && (('a' <= prev && prev <= 'z') || ('0' <= prev && prev <= '9')) {
+			b.WriteRune('_')
 		}
-		return fmt.Sprintf("%v", value), nil
+		b.WriteRune(r)
+		prev = r
 	}
-	return fieldRef.DefaultValue, nil
+	if c == v1beta1.EnvNameCasePreserve {
+		return b.String()
+	}
+	return strings.ToUpper(b.String())
 }
 
-// a valueRef behaves like a fieldRef with a Required Policy.
-func fromValueRef(req *fnv1.RunFunctionRequest, path string) (string, error) {
-	return fromFieldRef(
-		req, v1alpha1.FieldRef{
-			Path:   path,
-			Policy: v1alpha1.FieldRefPolicyRequired,
-		})
+// formatValue formats the value of a field in the supplied format.
+func formatValue(v any, f v1beta1.FieldRefFormat) (string, error) {
+	switch f {
+	case "", v1beta1.FieldRefFormatRaw:
+		return rawValue(v)
+	case v1beta1.FieldRefFormatJSON:
+		b, err := json.Marshal(v)
+		return string(b), errors.Wrap(err, "cannot format value as JSON")
+	case v1beta1.FieldRefFormatYAML:
+		b, err := yaml.Marshal(v)
+		return strings.TrimSuffix(string(b), "\n"), errors.Wrap(err, "cannot format value as YAML")
+	case v1beta1.FieldRefFormatBase64:
+		s, err := rawValue(v)
+		return base64.StdEncoding.EncodeToString([]byte(s)), err
+	case v1beta1.FieldRefFormatCSV:
+		return csvValue(v)
+	default:
+		return "", errors.Errorf("unknown format %s", f)
+	}
+}
+
+// rawValue formats strings as is, numbers in decimal notation, and objects and
+// lists as JSON.
+func rawValue(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case map[string]any, []any:
+		b, err := json.Marshal(v)
+		return string(b), errors.Wrap(err, "cannot format value as JSON")
+	default:
+		return fmt.Sprintf("%v", v), nil
+	}
+}
+
+// csvValue formats a list of values as one CSV record, a list of lists as one
+// record per list, and any other value as a record of one field.
+func csvValue(v any) (string, error) {
+	var records [][]string
+	l, ok := v.([]any)
+	if !ok {
+		l = []any{v}
+	}
+	nested := len(l) > 0
+	for _, e := range l {
+		if _, ok := e.([]any); !ok {
+			nested = false
+		}
+	}
+	if !nested {
+		l = []any{l}
+	}
+	for _, e := range l {
+		var record []string
+		for _, f := range e.([]any) {
+			switch f.(type) {
+			case map[string]any, []any:
+				return "", errors.New("cannot format an object or a nested list as CSV")
+			}
+			s, err := rawValue(f)
+			if err != nil {
+				return "", err
+			}
+			record = append(record, s)
+		}
+		records = append(records, record)
+	}
+
+	buf := &bytes.Buffer{}
+	w := csv.NewWriter(buf)
+	if err := w.WriteAll(records); err != nil {
+		return "", errors.Wrap(err, "cannot format value as CSV")
+	}
+	return strings.TrimSuffix(buf.String(), "\n"), nil
 }
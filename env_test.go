@@ -1,9 +1,10 @@
 package main
 
 import (
+	"context"
 	"testing"
 
-	"github.com/crossplane-contrib/function-shell/input/v1alpha1"
+	"github.com/crossplane-contrib/function-shell/input/v1beta1"
 	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -13,6 +14,67 @@ import (
 	"github.com/crossplane/function-sdk-go/resource"
 )
 
+func TestAddShellEnvVarsFromRef(t *testing.T) {
+	t.Setenv("FUNCTION_SHELL_TEST_JSON", `{"API_KEY": "key", "PORT": 8080, "DEBUG": true}`)
+	t.Setenv("FUNCTION_SHELL_TEST_YAML", "API_KEY: key\nTAGS: [a, b]\n")
+	t.Setenv("FUNCTION_SHELL_TEST_DOTENV", "API_KEY=key\nURL='https://example.org'\n")
+
+	type want struct {
+		env map[string]string
+		err bool
+	}
+
+	cases := map[string]struct {
+		reason string
+		ref    v1beta1.EnvVarRef
+		want   want
+	}{
+		"JSON": {
+			reason: "Values that aren't strings should be written like the Raw format of a fieldRef.",
+			ref:    v1beta1.EnvVarRef{Name: "FUNCTION_SHELL_TEST_JSON", Keys: []string{"API_KEY", "PORT", "DEBUG"}},
+			want:   want{env: map[string]string{"API_KEY": "key", "PORT": "8080", "DEBUG": "true"}},
+		},
+		"YAML": {
+			reason: "YAML values should be parsed, writing lists as JSON.",
+			ref:    v1beta1.EnvVarRef{Name: "FUNCTION_SHELL_TEST_YAML", Format: v1beta1.EnvFormatYAML, Keys: []string{"TAGS"}},
+			want:   want{env: map[string]string{"TAGS": `["a","b"]`}},
+		},
+		"DotenvAllKeys": {
+			reason: "The key * should import every key.",
+			ref:    v1beta1.EnvVarRef{Name: "FUNCTION_SHELL_TEST_DOTENV", Format: v1beta1.EnvFormatDotenv, Keys: []string{"*"}},
+			want:   want{env: map[string]string{"API_KEY": "key", "URL": "https://example.org"}},
+		},
+		"MissingKey": {
+			reason: "A key that doesn't exist should be an error.",
+			ref:    v1beta1.EnvVarRef{Name: "FUNCTION_SHELL_TEST_JSON", Keys: []string{"TOKEN"}},
+			want:   want{env: map[string]string{}, err: true},
+		},
+		"MissingOptionalKey": {
+			reason: "An optional key that doesn't exist should be skipped.",
+			ref:    v1beta1.EnvVarRef{Name: "FUNCTION_SHELL_TEST_JSON", Keys: []string{"API_KEY"}, OptionalKeys: []string{"TOKEN"}},
+			want:   want{env: map[string]string{"API_KEY": "key"}},
+		},
+		"InvalidJSON": {
+			reason: "A value that isn't a JSON object should be an error.",
+			ref:    v1beta1.EnvVarRef{Name: "FUNCTION_SHELL_TEST_DOTENV", Keys: []string{"API_KEY"}},
+			want:   want{env: map[string]string{}, err: true},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			env, err := addShellEnvVarsFromRef(tc.ref, map[string]string{})
+
+			if diff := cmp.Diff(tc.want.env, env); diff != "" {
+				t.Errorf("%s\naddShellEnvVarsFromRef(...): -want, +got:\n%s", tc.reason, diff)
+			}
+			if (err != nil) != tc.want.err {
+				t.Errorf("%s\naddShellEnvVarsFromRef(...): want error %t, got %v", tc.reason, tc.want.err, err)
+			}
+		})
+	}
+}
+
 func TestFromValueRef(t *testing.T) {
 	type args struct {
 		req  *fnv1.RunFunctionRequest
@@ -73,7 +135,9 @@ func TestFromValueRef(t *testing.T) {
 
 	for name, tc := range cases {
 		t.Run(name, func(t *testing.T) {
-			result, err := fromValueRef(tc.args.req, tc.args.path)
+			// v1alpha1 valueRefs are converted to fieldRefs with a Required
+			// policy.
+			result, err := fromFieldRef(context.Background(), tc.args.req, v1beta1.FieldRef{Path: tc.args.path, Policy: v1beta1.FieldRefPolicyRequired})
 
 			if diff := cmp.Diff(tc.want.result, result, protocmp.Transform()); diff != "" {
 				t.Errorf("%s\nf.RunFunction(...): -want rsp, +got rsp:\n%s", tc.reason, diff)
@@ -89,7 +153,7 @@ func TestFromValueRef(t *testing.T) {
 func TestFromFieldRef(t *testing.T) {
 	type args struct {
 		req      *fnv1.RunFunctionRequest
-		fieldRef v1alpha1.FieldRef
+		fieldRef v1beta1.FieldRef
 	}
 
 	type want struct {
@@ -118,7 +182,7 @@ func TestFromFieldRef(t *testing.T) {
 						},
 					},
 				},
-				fieldRef: v1alpha1.FieldRef{
+				fieldRef: v1beta1.FieldRef{
 					Path: "spec.foo",
 				},
 			},
@@ -127,6 +191,32 @@ func TestFromFieldRef(t *testing.T) {
 				err:    nil,
 			},
 		},
+		"FromCompositeObjectJSON": {
+			reason: "If the format is JSON, an object should be returned as JSON.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "",
+								"kind": "",
+								"spec": {
+									"tags": {"team": "a", "size": 10000000}
+								}
+							}`),
+						},
+					},
+				},
+				fieldRef: v1beta1.FieldRef{
+					Path:   "spec.tags",
+					Format: v1beta1.FieldRefFormatJSON,
+				},
+			},
+			want: want{
+				result: `{"size":10000000,"team":"a"}`,
+				err:    nil,
+			},
+		},
 		"FromCompositeMissingError": {
 			reason: "If composite path is invalid and Policy is required, return an error",
 			args: args{
@@ -143,9 +233,9 @@ func TestFromFieldRef(t *testing.T) {
 						},
 					},
 				},
-				fieldRef: v1alpha1.FieldRef{
+				fieldRef: v1beta1.FieldRef{
 					Path:   "spec.foo",
-					Policy: v1alpha1.FieldRefPolicyRequired,
+					Policy: v1beta1.FieldRefPolicyRequired,
 				},
 			},
 			want: want{
@@ -169,7 +259,7 @@ func TestFromFieldRef(t *testing.T) {
 						},
 					},
 				},
-				fieldRef: v1alpha1.FieldRef{
+				fieldRef: v1beta1.FieldRef{
 					Path: "spec.foo",
 				},
 			},
@@ -194,10 +284,10 @@ func TestFromFieldRef(t *testing.T) {
 						},
 					},
 				},
-				fieldRef: v1alpha1.FieldRef{
+				fieldRef: v1beta1.FieldRef{
 					DefaultValue: "default",
 					Path:         "spec.foo",
-					Policy:       v1alpha1.FieldRefPolicyOptional,
+					Policy:       v1beta1.FieldRefPolicyOptional,
 				},
 			},
 			want: want{
@@ -221,9 +311,9 @@ func TestFromFieldRef(t *testing.T) {
 						},
 					},
 				},
-				fieldRef: v1alpha1.FieldRef{
+				fieldRef: v1beta1.FieldRef{
 					Path:   "spec.foo",
-					Policy: v1alpha1.FieldRefPolicyOptional,
+					Policy: v1beta1.FieldRefPolicyOptional,
 				},
 			},
 			want: want{
@@ -241,7 +331,7 @@ func TestFromFieldRef(t *testing.T) {
 							}
 					}`),
 				},
-				fieldRef: v1alpha1.FieldRef{
+				fieldRef: v1beta1.FieldRef{
 					Path: "context[apiextensions.crossplane.io/foo].bar",
 				},
 			},
@@ -260,9 +350,9 @@ func TestFromFieldRef(t *testing.T) {
 							}
 					}`),
 				},
-				fieldRef: v1alpha1.FieldRef{
+				fieldRef: v1beta1.FieldRef{
 					Path:   "context[apiextensions.crossplane.io/foo].bad",
-					Policy: v1alpha1.FieldRefPolicyRequired,
+					Policy: v1beta1.FieldRefPolicyRequired,
 				},
 			},
 			want: want{
@@ -280,7 +370,7 @@ func TestFromFieldRef(t *testing.T) {
 							}
 					}`),
 				},
-				fieldRef: v1alpha1.FieldRef{
+				fieldRef: v1beta1.FieldRef{
 					Path: "context[apiextensions.crossplane.io/foo].bad",
 				},
 			},
@@ -299,9 +389,9 @@ func TestFromFieldRef(t *testing.T) {
 							}
 					}`),
 				},
-				fieldRef: v1alpha1.FieldRef{
+				fieldRef: v1beta1.FieldRef{
 					Path:   "context[apiextensions.crossplane.io/foo].bad",
-					Policy: v1alpha1.FieldRefPolicyOptional,
+					Policy: v1beta1.FieldRefPolicyOptional,
 				},
 			},
 			want: want{
@@ -319,10 +409,128 @@ func TestFromFieldRef(t *testing.T) {
 							}
 					}`),
 				},
-				fieldRef: v1alpha1.FieldRef{
+				fieldRef: v1beta1.FieldRef{
 					DefaultValue: "default",
 					Path:         "context[apiextensions.crossplane.io/foo].bad",
-					Policy:       v1alpha1.FieldRefPolicyOptional,
+					Policy:       v1beta1.FieldRefPolicyOptional,
+				},
+			},
+			want: want{
+				result: "default",
+				err:    nil,
+			},
+		},
+		"FromCompositeFilter": {
+			reason: "If the path has a filter, return the value of the field of the element it selects",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "",
+								"kind": "",
+								"spec": {
+									"ports": [
+										{"name": "metrics", "port": 9090},
+										{"name": "http", "port": 8080}
+									]
+								}
+							}`),
+						},
+					},
+				},
+				fieldRef: v1beta1.FieldRef{
+					Path: `spec.ports[?(@.name=="http")].port`,
+				},
+			},
+			want: want{
+				result: "8080",
+				err:    nil,
+			},
+		},
+		"FromCompositeWildcard": {
+			reason: "If the path has a wildcard, return the values of every field it selects as a list",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "",
+								"kind": "",
+								"spec": {
+									"ports": [
+										{"name": "metrics", "port": 9090},
+										{"name": "http", "port": 8080}
+									]
+								}
+							}`),
+						},
+					},
+				},
+				fieldRef: v1beta1.FieldRef{
+					Path:   "spec.ports[*].name",
+					Format: v1beta1.FieldRefFormatCSV,
+				},
+			},
+			want: want{
+				result: "metrics,http",
+				err:    nil,
+			},
+		},
+		"FromComposedResource": {
+			reason: "If the path refers to an observed composed resource, return the value of its field",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Observed: &fnv1.State{
+						Resources: map[string]*fnv1.Resource{
+							"bucket": {
+								Resource: resource.MustStructJSON(`{
+									"apiVersion": "",
+									"kind": "",
+									"status": {
+										"atProvider": {
+											"arn": "arn:aws:s3:::bucket"
+										}
+									}
+								}`),
+							},
+						},
+					},
+				},
+				fieldRef: v1beta1.FieldRef{
+					Path: "resources[bucket].status.atProvider.arn",
+				},
+			},
+			want: want{
+				result: "arn:aws:s3:::bucket",
+				err:    nil,
+			},
+		},
+		"FromComposedResourceMissingError": {
+			reason: "If the observed composed resource doesn't exist and Policy is not set, return an error",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Observed: &fnv1.State{},
+				},
+				fieldRef: v1beta1.FieldRef{
+					Path: "resources[bucket].status.atProvider.arn",
+				},
+			},
+			want: want{
+				result: "",
+				err:    errors.New("observed composed resource bucket does not exist"),
+			},
+		},
+		"FromComposedResourceMissingOptionalPolicyDefaultValue": {
+			reason: "If the observed composed resource doesn't exist and Policy is Optional, return DefaultValue",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Observed: &fnv1.State{},
+				},
+				fieldRef: v1beta1.FieldRef{
+					DefaultValue: "default",
+					Path:         "resources[bucket].status.atProvider.arn",
+					Policy:       v1beta1.FieldRefPolicyOptional,
 				},
 			},
 			want: want{
@@ -334,7 +542,7 @@ func TestFromFieldRef(t *testing.T) {
 
 	for name, tc := range cases {
 		t.Run(name, func(t *testing.T) {
-			result, err := fromFieldRef(tc.args.req, tc.args.fieldRef)
+			result, err := fromFieldRef(context.Background(), tc.args.req, tc.args.fieldRef)
 
 			if diff := cmp.Diff(tc.want.result, result, protocmp.Transform()); diff != "" {
 				t.Errorf("%s\nf.RunFunction(...): -want rsp, +got rsp:\n%s", tc.reason, diff)
@@ -351,3 +559,253 @@ func TestFromFieldRef(t *testing.T) {
 		})
 	}
 }
+
+func TestFromEnvFromFieldRef(t *testing.T) {
+	type args struct {
+		req *fnv1.RunFunctionRequest
+		ref v1beta1.EnvFromFieldRef
+	}
+
+	type want struct {
+		env map[string]string
+		err error
+	}
+
+	oxr := &fnv1.State{
+		Composite: &fnv1.Resource{
+			Resource: resource.MustStructJSON(`{
+				"apiVersion": "",
+				"kind": "",
+				"spec": {
+					"parameters": {
+						"region": "us-east-1",
+						"instanceType": "t3.micro",
+						"replicas": 3,
+						"tags": ["a", "b"],
+						"network": {
+							"vpc-id": "vpc-1"
+						}
+					},
+					"collides": {
+						"instanceType": "a",
+						"instance_type": "b"
+					}
+				}
+			}`),
+		},
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   want
+	}{
+		"FromComposite": {
+			reason: "Every field of a composite resource subtree should be an environment variable, with nested objects flattened.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{Observed: oxr},
+				ref: v1beta1.EnvFromFieldRef{Path: "spec.parameters", Prefix: "XR_"},
+			},
+			want: want{
+				env: map[string]string{
+					"XR_REGION":         "us-east-1",
+					"XR_INSTANCE_TYPE":  "t3.micro",
+					"XR_REPLICAS":       "3",
+					"XR_TAGS":           `["a","b"]`,
+					"XR_NETWORK_VPC_ID": "vpc-1",
+				},
+			},
+		},
+		"UpperCaseJSONFormat": {
+			reason: "Upper case names should not split words, and values should use the format.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{Observed: oxr},
+				ref: v1beta1.EnvFromFieldRef{Path: "spec.parameters.network", NameCase: v1beta1.EnvNameCaseUpper, Format: v1beta1.FieldRefFormatJSON},
+			},
+			want: want{
+				env: map[string]string{"VPC_ID": `"vpc-1"`},
+			},
+		},
+		"FromContextKey": {
+			reason: "A whole context key should be importable.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Context: resource.MustStructJSON(`{
+						"apiextensions.crossplane.io/environment": {
+							"clusterName": "prod"
+						}
+					}`),
+				},
+				ref: v1beta1.EnvFromFieldRef{Path: "context[apiextensions.crossplane.io/environment]", Prefix: "ENV_", NameCase: v1beta1.EnvNameCasePreserve},
+			},
+			want: want{
+				env: map[string]string{"ENV_clusterName": "prod"},
+			},
+		},
+		"Collision": {
+			reason: "Keys that map to the same name should be an error naming both.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{Observed: oxr},
+				ref: v1beta1.EnvFromFieldRef{Path: "spec.collides"},
+			},
+			want: want{
+				err: errors.New("keys instanceType and instance_type both map to INSTANCE_TYPE"),
+			},
+		},
+		"NotAnObject": {
+			reason: "A path that isn't an object should be an error.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{Observed: oxr},
+				ref: v1beta1.EnvFromFieldRef{Path: "spec.parameters.region"},
+			},
+			want: want{
+				err: errors.New("value is a string, not an object"),
+			},
+		},
+		"MissingRequired": {
+			reason: "A missing object should be an error if it's required.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{Observed: oxr},
+				ref: v1beta1.EnvFromFieldRef{Path: "spec.missing"},
+			},
+			want: want{
+				err: errors.New("cannot get observed composite value: spec.missing: no such field"),
+			},
+		},
+		"MissingOptional": {
+			reason: "A missing object should import nothing if it's optional.",
+			args: args{
+				req: &fnv1.RunFunctionRequest{Observed: &fnv1.State{}},
+				ref: v1beta1.EnvFromFieldRef{Path: "resources[bucket].status", Policy: v1beta1.FieldRefPolicyOptional},
+			},
+			want: want{},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			env, err := fromEnvFromFieldRef(context.Background(), tc.args.req, tc.args.ref)
+
+			if diff := cmp.Diff(tc.want.env, env, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("%s\nfromEnvFromFieldRef(...): -want, +got:\n%s", tc.reason, diff)
+			}
+			if tc.want.err != nil && err != nil {
+				if diff := cmp.Diff(tc.want.err.Error(), err.Error()); diff != "" {
+					t.Errorf("%s\nfromEnvFromFieldRef(...): -want err message, +got err message:\n%s", tc.reason, diff)
+				}
+			} else if diff := cmp.Diff(tc.want.err, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("%s\nfromEnvFromFieldRef(...): -want err, +got err:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestEnvName(t *testing.T) {
+	cases := map[string]struct {
+		key  string
+		c    v1beta1.EnvNameCase
+		want string
+	}{
+		"UpperSnakeCamelCase":   {key: "parameters.instanceType", want: "PARAMETERS_INSTANCE_TYPE"},
+		"UpperSnakeDigits":      {key: "ipv4Address", c: v1beta1.EnvNameCaseUpperSnake, want: "IPV4_ADDRESS"},
+		"UpperSnakeAcronym":     {key: "awsARN", want: "AWS_ARN"},
+		"UpperSnakeInvalidRune": {key: "vpc-id", want: "VPC_ID"},
+		"Upper":                 {key: "parameters.instanceType", c: v1beta1.EnvNameCaseUpper, want: "PARAMETERS_INSTANCETYPE"},
+		"Preserve":              {key: "parameters.instanceType", c: v1beta1.EnvNameCasePreserve, want: "parameters_instanceType"},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			if got := envName(tc.key, tc.c); got != tc.want {
+				t.Errorf("envName(%q, %q): want %q, got %q", tc.key, tc.c, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	type args struct {
+		value  any
+		format v1beta1.FieldRefFormat
+	}
+
+	type want struct {
+		result string
+		err    bool
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   want
+	}{
+		"RawString": {
+			reason: "Raw strings should be written as is.",
+			args:   args{value: "bar"},
+			want:   want{result: "bar"},
+		},
+		"RawLargeNumber": {
+			reason: "Raw numbers should be written in decimal notation, not scientific notation.",
+			args:   args{value: float64(12345678901), format: v1beta1.FieldRefFormatRaw},
+			want:   want{result: "12345678901"},
+		},
+		"RawObject": {
+			reason: "Raw objects should be written as JSON.",
+			args:   args{value: map[string]any{"a": "b"}},
+			want:   want{result: `{"a":"b"}`},
+		},
+		"RawList": {
+			reason: "Raw lists should be written as JSON.",
+			args:   args{value: []any{"x", "y"}},
+			want:   want{result: `["x","y"]`},
+		},
+		"JSONString": {
+			reason: "JSON strings should be quoted.",
+			args:   args{value: "bar", format: v1beta1.FieldRefFormatJSON},
+			want:   want{result: `"bar"`},
+		},
+		"YAMLObject": {
+			reason: "YAML objects should be written without a trailing newline.",
+			args:   args{value: map[string]any{"a": "b", "c": []any{int64(1)}}, format: v1beta1.FieldRefFormatYAML},
+			want:   want{result: "a: b\nc:\n- 1"},
+		},
+		"Base64String": {
+			reason: "Base64 should encode the raw value.",
+			args:   args{value: "bar", format: v1beta1.FieldRefFormatBase64},
+			want:   want{result: "YmFy"},
+		},
+		"Base64Object": {
+			reason: "Base64 should encode objects as JSON.",
+			args:   args{value: map[string]any{"a": "b"}, format: v1beta1.FieldRefFormatBase64},
+			want:   want{result: "eyJhIjoiYiJ9"},
+		},
+		"CSVList": {
+			reason: "A list should be written as one CSV record, quoting values as needed.",
+			args:   args{value: []any{"x", "y,z", float64(3)}, format: v1beta1.FieldRefFormatCSV},
+			want:   want{result: `x,"y,z",3`},
+		},
+		"CSVListOfLists": {
+			reason: "A list of lists should be written as one CSV record per list.",
+			args:   args{value: []any{[]any{"a", "b"}, []any{"c", "d"}}, format: v1beta1.FieldRefFormatCSV},
+			want:   want{result: "a,b\nc,d"},
+		},
+		"CSVObject": {
+			reason: "Objects can't be written as CSV.",
+			args:   args{value: []any{map[string]any{"a": "b"}}, format: v1beta1.FieldRefFormatCSV},
+			want:   want{err: true},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			result, err := formatValue(tc.args.value, tc.args.format)
+
+			if diff := cmp.Diff(tc.want.result, result); diff != "" {
+				t.Errorf("%s\nformatValue(...): -want, +got:\n%s", tc.reason, diff)
+			}
+			if (err != nil) != tc.want.err {
+				t.Errorf("%s\nformatValue(...): want error %t, got %v", tc.reason, tc.want.err, err)
+			}
+		})
+	}
+}
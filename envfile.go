@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/crossplane-contrib/function-shell/input/v1beta1"
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	"sigs.k8s.io/yaml"
+)
+
+// addShellEnvVarsFromFile adds the keys of the file or directory the supplied
+// reference refers to to shellEnvVars.
+func addShellEnvVarsFromFile(ref v1beta1.EnvFileRef, shellEnvVars map[string]string) (map[string]string, error) {
+	fi, err := os.Stat(ref.Path)
+	if err != nil {
+		return shellEnvVars, errors.Wrap(err, "cannot read file")
+	}
+
+	var env map[string]string
+	if fi.IsDir() {
+		env, err = readEnvDir(ref.Path)
+	} else {
+		env, err = readEnvFile(ref.Path, ref.Format)
+	}
+	if err != nil {
+		return shellEnvVars, err
+	}
+
+	keys := ref.Keys
+	if len(keys) == 0 && len(ref.OptionalKeys) == 0 {
+		keys = []string{envAllKeys}
+	}
+	return addEnvKeys(env, keys, ref.OptionalKeys, shellEnvVars)
+}
+
+// envAllKeys is the key that imports every key of a set of environment
+// variables.
+const envAllKeys = "*"
+
+// addEnvKeys adds the supplied keys of env to shellEnvVars. A key that doesn't
+// exist is an error, but an optional key that doesn't exist is skipped.
+func addEnvKeys(env map[string]string, keys, optionalKeys []string, shellEnvVars map[string]string) (map[string]string, error) {
+	for _, k := range keys {
+		if k == envAllKeys {
+			for _, k := range slices.Sorted(maps.Keys(env)) {
+				if !envKeyRegex.MatchString(k) {
+					return shellEnvVars, errors.Errorf("key %s is not a valid environment variable name, set keys to import only valid ones", k)
+				}
+			}
+			maps.Copy(shellEnvVars, env)
+			continue
+		}
+		v, ok := env[k]
+		if !ok {
+			return shellEnvVars, errors.Errorf("key %s does not exist", k)
+		}
+		shellEnvVars[k] = v
+	}
+	for _, k := range optionalKeys {
+		if v, ok := env[k]; ok {
+			shellEnvVars[k] = v
+		}
+	}
+	return shellEnvVars, nil
+}
+
+// readEnvDir reads every file of a directory as a key named after the file.
+// Hidden files are skipped, like the ..data link of a Kubernetes secret
+// volume, and links to files are followed.
+func readEnvDir(dir string) (map[string]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read directory")
+	}
+	env := map[string]string{}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot read file %s", e.Name())
+		}
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		b, err := os.ReadFile(path) //nolint:gosec // Reading files of the directory the input refers to is intended.
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot read file %s", e.Name())
+		}
+		env[e.Name()] = string(b)
+	}
+	return env, nil
+}
+
+// readEnvFile reads the keys of a JSON, YAML or dotenv file.
+func readEnvFile(path string, f v1beta1.EnvFormat) (map[string]string, error) {
+	b, err := os.ReadFile(path) //nolint:gosec // Reading the file the input refers to is intended.
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read file")
+	}
+	if f == "" {
+		switch filepath.Ext(path) {
+		case ".json":
+			f = v1beta1.EnvFormatJSON
+		case ".yaml", ".yml":
+			f = v1beta1.EnvFormatYAML
+		default:
+			f = v1beta1.EnvFormatDotenv
+		}
+	}
+	return parseEnv(b, f)
+}
+
+// parseEnv parses a set of environment variables in the supplied format. Values
+// of JSON and YAML objects that aren't strings are written like the Raw format
+// of a FieldRef.
+func parseEnv(b []byte, f v1beta1.EnvFormat) (map[string]string, error) {
+	var obj map[string]any
+	switch f {
+	case v1beta1.EnvFormatJSON:
+		if err := json.Unmarshal(b, &obj); err != nil {
+			return nil, errors.Wrap(err, "cannot parse JSON")
+		}
+	case v1beta1.EnvFormatYAML:
+		if err := yaml.Unmarshal(b, &obj); err != nil {
+			return nil, errors.Wrap(err, "cannot parse YAML")
+		}
+	case v1beta1.EnvFormatDotenv:
+		return parseDotenv(b)
+	default:
+		return nil, errors.Errorf("unknown format %s", f)
+	}
+
+	env := make(map[string]string, len(obj))
+	for k, v := range obj {
+		s, err := rawValue(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot format key %s", k)
+		}
+		env[k] = s
+	}
+	return env, nil
+}
+
+// parseDotenv parses lines of KEY=value. Blank lines, lines starting with #
+// and an export prefix are ignored. Values may be quoted: double quoted values
+// support the escapes \n, \", and \\, and single quoted values are literal.
+// Unquoted values end at a " #" comment.
+func parseDotenv(b []byte) (map[string]string, error) {
+	env := map[string]string{}
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, errors.Errorf("line %d: missing =", n)
+		}
+		k, v = strings.TrimSpace(k), strings.TrimSpace(v)
+		value, err := dotenvValue(v)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", n)
+		}
+		env[k] = value
+	}
+	return env, errors.Wrap(s.Err(), "cannot read dotenv")
+}
+
+func dotenvValue(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, `'`):
+		end := strings.Index(v[1:], `'`)
+		if end < 0 {
+			return "", errors.New("unterminated single quoted value")
+		}
+		return v[1 : end+1], nil
+	case strings.HasPrefix(v, `"`):
+		var b strings.Builder
+		for i := 1; i < len(v); i++ {
+			switch c := v[i]; {
+			case c == '"':
+				return b.String(), nil
+			case c == '\\' && i+1 < len(v):
+				i++
+				switch v[i] {
+				case 'n':
+					b.WriteByte('\n')
+				default:
+					b.WriteByte(v[i])
+				}
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return "", errors.New("unterminated double quoted value")
+	default:
+		if i := strings.Index(v, " #"); i >= 0 {
+			v = v[:i]
+		}
+		return strings.TrimSpace(v), nil
+	}
+}
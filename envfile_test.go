@@ -0,0 +1,167 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/crossplane-contrib/function-shell/input/v1beta1"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAddShellEnvVarsFromFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(filepath.Join(dir, "secret.json"), `{"API_KEY": "key", "PORT": 8080, "TAGS": ["a"]}`)
+	write(filepath.Join(dir, "secret.yaml"), "API_KEY: key\nDEBUG: true\n")
+	write(filepath.Join(dir, "secret.env"), "# comment\nexport API_KEY=key\nURL=\"https://example.org\" \n")
+	write(filepath.Join(dir, "invalid.json"), `{"tls.crt": "cert", "API_KEY": "key"}`)
+
+	// Kubernetes secret volumes link each key to a file in a hidden,
+	// timestamped directory.
+	volume := filepath.Join(dir, "volume")
+	write(filepath.Join(volume, "..2024_01_01", "API_KEY"), "key")
+	write(filepath.Join(volume, "..2024_01_01", "APP_KEY"), "app")
+	if err := os.Symlink("..2024_01_01", filepath.Join(volume, "..data")); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"API_KEY", "APP_KEY"} {
+		if err := os.Symlink(filepath.Join("..data", k), filepath.Join(volume, k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	type want struct {
+		env map[string]string
+		err bool
+	}
+
+	cases := map[string]struct {
+		reason string
+		ref    v1beta1.EnvFileRef
+		want   want
+	}{
+		"JSON": {
+			reason: "Every key of a JSON file should be imported, writing values that aren't strings as JSON.",
+			ref:    v1beta1.EnvFileRef{Path: filepath.Join(dir, "secret.json")},
+			want:   want{env: map[string]string{"API_KEY": "key", "PORT": "8080", "TAGS": `["a"]`}},
+		},
+		"YAML": {
+			reason: "Files ending in .yaml should be read as YAML.",
+			ref:    v1beta1.EnvFileRef{Path: filepath.Join(dir, "secret.yaml"), Keys: []string{"DEBUG"}},
+			want:   want{env: map[string]string{"DEBUG": "true"}},
+		},
+		"Dotenv": {
+			reason: "Other files should be read as dotenv.",
+			ref:    v1beta1.EnvFileRef{Path: filepath.Join(dir, "secret.env")},
+			want:   want{env: map[string]string{"API_KEY": "key", "URL": "https://example.org"}},
+		},
+		"Directory": {
+			reason: "Every file of a secret volume should be a key, skipping hidden files.",
+			ref:    v1beta1.EnvFileRef{Path: volume},
+			want:   want{env: map[string]string{"API_KEY": "key", "APP_KEY": "app"}},
+		},
+		"MissingKey": {
+			reason: "A key that doesn't exist should be an error.",
+			ref:    v1beta1.EnvFileRef{Path: volume, Keys: []string{"TOKEN"}},
+			want:   want{env: map[string]string{}, err: true},
+		},
+		"MissingOptionalKey": {
+			reason: "An optional key that doesn't exist should be skipped.",
+			ref:    v1beta1.EnvFileRef{Path: volume, OptionalKeys: []string{"APP_KEY", "TOKEN"}},
+			want:   want{env: map[string]string{"APP_KEY": "app"}},
+		},
+		"InvalidName": {
+			reason: "Importing every key should fail if a key isn't a valid name.",
+			ref:    v1beta1.EnvFileRef{Path: filepath.Join(dir, "invalid.json")},
+			want:   want{env: map[string]string{}, err: true},
+		},
+		"SelectValidNames": {
+			reason: "Keys should select only the valid names.",
+			ref:    v1beta1.EnvFileRef{Path: filepath.Join(dir, "invalid.json"), Keys: []string{"API_KEY"}},
+			want:   want{env: map[string]string{"API_KEY": "key"}},
+		},
+		"MissingFile": {
+			reason: "A file that doesn't exist should be an error.",
+			ref:    v1beta1.EnvFileRef{Path: filepath.Join(dir, "missing.json")},
+			want:   want{env: map[string]string{}, err: true},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			env, err := addShellEnvVarsFromFile(tc.ref, map[string]string{})
+
+			if diff := cmp.Diff(tc.want.env, env); diff != "" {
+				t.Errorf("%s\naddShellEnvVarsFromFile(...): -want, +got:\n%s", tc.reason, diff)
+			}
+			if (err != nil) != tc.want.err {
+				t.Errorf("%s\naddShellEnvVarsFromFile(...): want error %t, got %v", tc.reason, tc.want.err, err)
+			}
+		})
+	}
+}
+
+func TestParseDotenv(t *testing.T) {
+	type want struct {
+		env map[string]string
+		err bool
+	}
+
+	cases := map[string]struct {
+		reason string
+		data   string
+		want   want
+	}{
+		"Values": {
+			reason: "Unquoted, single quoted and double quoted values should be parsed.",
+			data: `
+# A comment.
+A=a # A comment.
+export B = b
+C='$literal # not a comment'
+D="line\nbreak \"quoted\""
+E=
+`,
+			want: want{env: map[string]string{
+				"A": "a",
+				"B": "b",
+				"C": "$literal # not a comment",
+				"D": "line\nbreak \"quoted\"",
+				"E": "",
+			}},
+		},
+		"MissingEquals": {
+			reason: "A line without = should be an error.",
+			data:   "A",
+			want:   want{err: true},
+		},
+		"Unterminated": {
+			reason: "An unterminated quoted value should be an error.",
+			data:   `A="a`,
+			want:   want{err: true},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			env, err := parseDotenv([]byte(tc.data))
+
+			if diff := cmp.Diff(tc.want.env, env); diff != "" {
+				t.Errorf("%s\nparseDotenv(...): -want, +got:\n%s", tc.reason, diff)
+			}
+			if (err != nil) != tc.want.err {
+				t.Errorf("%s\nparseDotenv(...): want error %t, got %v", tc.reason, tc.want.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	"github.com/crossplane/crossplane-runtime/v2/pkg/fieldpath"
+)
+
+// A fieldExpr is a field path with selectors that fieldpath doesn't support:
+// wildcards like spec.ports[*].port, and filters like
+// spec.ports[?(@.name=="http")].port.
+type fieldExpr struct {
+	// paths are the field paths before, between and after the selectors.
+	paths     []string
+	selectors []fieldSelector
+}
+
+// A fieldSelector selects elements of a list, or values of an object. A nil
+// filter selects every one.
+type fieldSelector struct {
+	filter *fieldFilter
+}
+
+// A fieldFilter selects the elements for which a condition on a field of the
+// element, like @.name=="http", is true.
+type fieldFilter struct {
+	// path of the field of the element. Empty for the element itself.
+	path string
+	// op is ==, !=, or =~. An empty op selects elements the field exists in.
+	op    string
+	value any
+	re    *regexp.Regexp
+}
+
+// isFieldExpr returns true if the path has wildcards or filters.
+func isFieldExpr(path string) bool {
+	return strings.Contains(path, "[*]") || strings.Contains(path, "[?(")
+}
+
+// parseFieldExpr parses a field path with wildcards or filters.
+func parseFieldExpr(path string) (*fieldExpr, error) {
+	e := &fieldExpr{}
+	start := 0
+	for i := 0; i < len(path); {
+		switch {
+		case strings.HasPrefix(path[i:], "[*]"):
+			e.paths = append(e.paths, path[start:i])
+			e.selectors = append(e.selectors, fieldSelector{})
+			i += len("[*]")
+			start = i
+		case strings.HasPrefix(path[i:], "[?("):
+			end := filterEnd(path, i+len("[?("))
+			if end < 0 {
+				return nil, errors.Errorf("unterminated filter at position %d", i)
+			}
+			f, err := parseFieldFilter(path[i+len("[?(") : end])
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid filter at position %d", i)
+			}
+			e.paths = append(e.paths, path[start:i])
+			e.selectors = append(e.selectors, fieldSelector{filter: f})
+			i = end + len(")]")
+			start = i
+		default:
+			i++
+		}
+	}
+	e.paths = append(e.paths, path[start:])
+
+	for i, p := range e.paths {
+		if p == "" {
+			continue
+		}
+		if i > 0 && p[0] != '.' && p[0] != '[' {
+			return nil, errors.Errorf("expected . or [ after selector, got %q", p)
+		}
+		if _, err := fieldpath.Parse(strings.TrimPrefix(p, ".")); err != nil {
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// filterEnd returns the index of the )] that ends the filter starting at i,
+// skipping quoted strings, or -1 if there isn't one.
+func filterEnd(path string, i int) int {
+	var quote byte
+	for ; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+		case c == '"' || c == '\'':
+			quote = c
+		case strings.HasPrefix(path[i:], ")]"):
+			return i
+		}
+	}
+	return -1
+}
+
+// parseFieldFilter parses a filter like @.name=="http", @.port!=80,
+// @.name=~"^http", or @.name.
+func parseFieldFilter(s string) (*fieldFilter, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "@") {
+		return nil, errors.New("filter must start with @")
+	}
+	f := &fieldFilter{}
+	path, literal, at := s[1:], "", -1
+	for _, op := range []string{"==", "!=", "=~"} {
+		if i := strings.Index(s, op); i >= 0 && (at < 0 || i < at) {
+			at, f.op = i, op
+		}
+	}
+	if at >= 0 {
+		path, literal = s[1:at], strings.TrimSpace(s[at+len(f.op):])
+	}
+	f.path = strings.TrimPrefix(strings.TrimSpace(path), ".")
+	if f.path != "" {
+		if _, err := fieldpath.Parse(f.path); err != nil {
+			return nil, err
+		}
+	}
+	if f.op == "" {
+		return f, nil
+	}
+
+	switch {
+	case len(literal) >= 2 && literal[0] == '\'' && literal[len(literal)-1] == '\'':
+		f.value = literal[1 : len(literal)-1]
+	default:
+		if err := json.Unmarshal([]byte(literal), &f.value); err != nil {
+			return nil, errors.Errorf("cannot parse value %s: must be a string, number, true, false, or null", literal)
+		}
+	}
+	if f.op == "=~" {
+		pattern, ok := f.value.(string)
+		if !ok {
+			return nil, errors.New("=~ must be followed by a string")
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot compile regular expression")
+		}
+		f.re = re
+	}
+	return f, nil
+}
+
+// matches returns true if the filter selects the supplied element.
+func (f *fieldFilter) matches(elem any) bool {
+	v := elem
+	if f.path != "" {
+		o, ok := elem.(map[string]any)
+		if !ok {
+			return false
+		}
+		var err error
+		if v, err = fieldpath.Pave(o).GetValue(f.path); err != nil {
+			return false
+		}
+	}
+	switch f.op {
+	case "==":
+		return equalValues(v, f.value)
+	case "!=":
+		return !equalValues(v, f.value)
+	case "=~":
+		s, ok := v.(string)
+		return ok && f.re.MatchString(s)
+	default:
+		return true
+	}
+}
+
+// equalValues returns true if two scalar values are equal, comparing numbers
+// of any type by value.
+func equalValues(a, b any) bool {
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		return ok && fa == fb
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func toFloat(v any) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	default:
+		return 0, false
+	}
+}
+
+// wildcard returns true if the expression has a wildcard.
+func (e *fieldExpr) wildcard() bool {
+	return slices.ContainsFunc(e.selectors, func(s fieldSelector) bool { return s.filter == nil })
+}
+
+// expand returns the field paths the expression selects in the supplied
+// object, in order. Only the fields the selectors apply to must exist; the
+// last field path is appended as is.
+func (e *fieldExpr) expand(p *fieldpath.Paved) ([]string, error) {
+	paths := []string{e.paths[0]}
+	for i, s := range e.selectors {
+		var next []string
+		for _, prefix := range paths {
+			var v any = p.UnstructuredContent()
+			if prefix != "" {
+				var err error
+				v, err = p.GetValue(prefix)
+				if fieldpath.IsNotFound(err) {
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+			switch v := v.(type) {
+			case []any:
+				for j, elem := range v {
+					if s.filter == nil || s.filter.matches(elem) {
+						next = append(next, fmt.Sprintf("%s[%d]", prefix, j))
+					}
+				}
+			case map[string]any:
+				for _, k := range slices.Sorted(maps.Keys(v)) {
+					if s.filter == nil || s.filter.matches(v[k]) {
+						next = append(next, fmt.Sprintf("%s[%s]", prefix, k))
+					}
+				}
+			}
+		}
+		paths = next
+		for j := range paths {
+			paths[j] += e.paths[i+1]
+		}
+	}
+	return paths, nil
+}
+
+// getFieldValue returns the value of the field at the supplied path. A path
+// with a wildcard returns a list of the values of every field it selects. A
+// path with only filters returns the value of the first field it selects.
+func getFieldValue(p *fieldpath.Paved, path string) (any, error) {
+	if !isFieldExpr(path) {
+		return p.GetValue(path)
+	}
+	e, err := parseFieldExpr(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot parse path %q", path)
+	}
+	paths, err := e.expand(p)
+	if err != nil {
+		return nil, err
+	}
+
+	values := []any{}
+	for _, fp := range paths {
+		v, err := p.GetValue(fp)
+		if fieldpath.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !e.wildcard() {
+			return v, nil
+		}
+		values = append(values, v)
+	}
+	if !e.wildcard() {
+		return nil, errors.Errorf("%s: no such field", path)
+	}
+	return values, nil
+}
+
+// setFieldValue sets the field at the supplied path. A path with a wildcard
+// sets every field it selects. A path with only filters sets the first field
+// it selects. A path that selects no fields is an error.
+func setFieldValue(p *fieldpath.Paved, path string, v any) error {
+	if !isFieldExpr(path) {
+		return p.SetValue(path, v)
+	}
+	e, err := parseFieldExpr(path)
+	if err != nil {
+		return errors.Wrapf(err, "cannot parse path %q", path)
+	}
+	paths, err := e.expand(p)
+	if err != nil {
+		return err
+	}
+	if len(paths) == 0 {
+		return errors.Errorf("%s: no such field", path)
+	}
+	if !e.wildcard() {
+		paths = paths[:1]
+	}
+	for _, fp := range paths {
+		if err := p.SetValue(fp, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/crossplane/crossplane-runtime/v2/pkg/fieldpath"
+	"github.com/google/go-cmp/cmp"
+)
+
+func testObject() map[string]any {
+	return map[string]any{
+		"spec": map[string]any{
+			"ports": []any{
+				map[string]any{"name": "http", "port": float64(80), "protocol": "TCP"},
+				map[string]any{"name": "https", "port": float64(443), "protocol": "TCP"},
+				map[string]any{"name": "dns", "port": int64(53), "protocol": "UDP"},
+			},
+			"zones": []any{"a", "b"},
+			"labels": map[string]any{
+				"team":        "a",
+				"environment": "prod",
+			},
+		},
+	}
+}
+
+func TestGetFieldValue(t *testing.T) {
+	type want struct {
+		value any
+		err   bool
+	}
+
+	cases := map[string]struct {
+		reason string
+		path   string
+		want   want
+	}{
+		"Plain": {
+			reason: "Paths without selectors should work like fieldpath.",
+			path:   "spec.ports[0].name",
+			want:   want{value: "http"},
+		},
+		"FilterEquals": {
+			reason: "A filter should select the element for which the condition is true.",
+			path:   `spec.ports[?(@.name=="http")].port`,
+			want:   want{value: float64(80)},
+		},
+		"FilterSingleQuotes": {
+			reason: "A filter should support single quoted strings.",
+			path:   `spec.ports[?(@.name == 'https')].port`,
+			want:   want{value: float64(443)},
+		},
+		"FilterNumber": {
+			reason: "A filter should compare numbers of any type by value.",
+			path:   `spec.ports[?(@.port==53)].name`,
+			want:   want{value: "dns"},
+		},
+		"FilterFirstMatch": {
+			reason: "A path with only filters should select the first match.",
+			path:   `spec.ports[?(@.protocol!="UDP")].name`,
+			want:   want{value: "http"},
+		},
+		"FilterRegex": {
+			reason: "A filter should support regular expressions.",
+			path:   `spec.ports[?(@.name=~"^h.*s$")].port`,
+			want:   want{value: float64(443)},
+		},
+		"FilterExists": {
+			reason: "A filter without an operator should select elements the field exists in.",
+			path:   `spec.ports[?(@.protocol)].name`,
+			want:   want{value: "http"},
+		},
+		"FilterNoMatch": {
+			reason: "A filter that matches nothing should be an error.",
+			path:   `spec.ports[?(@.name=="ftp")].port`,
+			want:   want{err: true},
+		},
+		"Wildcard": {
+			reason: "A wildcard should collect the values of every element into a list.",
+			path:   "spec.ports[*].name",
+			want:   want{value: []any{"http", "https", "dns"}},
+		},
+		"WildcardObject": {
+			reason: "A wildcard should collect the values of an object, sorted by key.",
+			path:   "spec.labels[*]",
+			want:   want{value: []any{"prod", "a"}},
+		},
+		"FilterElement": {
+			reason: "A filter on @ should compare the element itself.",
+			path:   `spec.zones[?(@=="b")]`,
+			want:   want{value: "b"},
+		},
+		"WildcardMissing": {
+			reason: "A wildcard of a missing field should be an empty list.",
+			path:   "spec.missing[*].name",
+			want:   want{value: []any{}},
+		},
+		"InvalidFilter": {
+			reason: "A filter that doesn't start with @ should be an error.",
+			path:   `spec.ports[?(name=="http")].port`,
+			want:   want{err: true},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got, err := getFieldValue(fieldpath.Pave(testObject()), tc.path)
+
+			if diff := cmp.Diff(tc.want.value, got); diff != "" {
+				t.Errorf("%s\ngetFieldValue(...): -want, +got:\n%s", tc.reason, diff)
+			}
+			if (err != nil) != tc.want.err {
+				t.Errorf("%s\ngetFieldValue(...): want error %t, got %v", tc.reason, tc.want.err, err)
+			}
+		})
+	}
+}
+
+func TestSetFieldValue(t *testing.T) {
+	type want struct {
+		object map[string]any
+		err    bool
+	}
+
+	cases := map[string]struct {
+		reason string
+		path   string
+		want   want
+	}{
+		"Filter": {
+			reason: "A filter should set the field of the first match.",
+			path:   `spec.ports[?(@.name=="http")].url`,
+			want: want{object: func() map[string]any {
+				o := testObject()
+				o["spec"].(map[string]any)["ports"].([]any)[0].(map[string]any)["url"] = "x"
+				return o
+			}()},
+		},
+		"Wildcard": {
+			reason: "A wildcard should set the field of every element.",
+			path:   `spec.ports[*].url`,
+			want: want{object: func() map[string]any {
+				o := testObject()
+				for _, p := range o["spec"].(map[string]any)["ports"].([]any) {
+					p.(map[string]any)["url"] = "x"
+				}
+				return o
+			}()},
+		},
+		"NoMatch": {
+			reason: "A path that selects no fields should be an error.",
+			path:   `spec.ports[?(@.name=="ftp")].url`,
+			want:   want{object: testObject(), err: true},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			o := testObject()
+			err := setFieldValue(fieldpath.Pave(o), tc.path, "x")
+
+			if diff := cmp.Diff(tc.want.object, o); diff != "" {
+				t.Errorf("%s\nsetFieldValue(...): -want, +got:\n%s", tc.reason, diff)
+			}
+			if (err != nil) != tc.want.err {
+				t.Errorf("%s\nsetFieldValue(...): want error %t, got %v", tc.reason, tc.want.err, err)
+			}
+		})
+	}
+}
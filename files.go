@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/crossplane-contrib/function-shell/input/v1beta1"
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/request"
+)
+
+// defaultFileMode is the mode of files that don't specify one.
+const defaultFileMode os.FileMode = 0o600
+
+// newWorkDir creates a new, empty working directory for a shell command. The
+// caller must remove it.
+func newWorkDir() (string, error) {
+	dir, err := os.MkdirTemp("", "function-shell-")
+	return dir, errors.Wrap(err, "cannot create working directory")
+}
+
+// writeFiles writes files to the working directory dir.
+func writeFiles(ctx context.Context, req *fnv1.RunFunctionRequest, dir string, files []v1beta1.File) error {
+	for _, f := range files {
+		if err := writeFile(ctx, req, dir, f); err != nil {
+			return errors.Wrapf(err, "cannot write file %s", f.Path)
+		}
+	}
+	return nil
+}
+
+func writeFile(ctx context.Context, req *fnv1.RunFunctionRequest, dir string, f v1beta1.File) error {
+	if !filepath.IsLocal(f.Path) {
+		return errors.New("path must be relative to, and within, the working directory")
+	}
+
+	mode := defaultFileMode
+	if f.Mode != "" {
+		m, err := strconv.ParseUint(f.Mode, 8, 32)
+		if err != nil || m > 0o777 {
+			return errors.Errorf("mode %q must be an octal file mode like 0600", f.Mode)
+		}
+		mode = os.FileMode(m)
+	}
+
+	content, err := fileContent(ctx, req, f)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, f.Path)
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return errors.Wrap(err, "cannot create parent directory")
+	}
+	if err := os.WriteFile(path, content, mode); err != nil {
+		return err
+	}
+	// WriteFile applies the umask to the mode.
+	return os.Chmod(path, mode)
+}
+
+func fileContent(ctx context.Context, req *fnv1.RunFunctionRequest, f v1beta1.File) ([]byte, error) {
+	switch t := f.GetType(); t {
+	case v1beta1.FileTypeValue:
+		return []byte(f.Value), nil
+	case v1beta1.FileTypeFieldRef:
+		if f.FieldRef == nil {
+			return nil, errors.New("fieldRef must be set")
+		}
+		v, err := fromFieldRef(ctx, req, *f.FieldRef)
+		return []byte(v), errors.Wrap(err, "cannot process contents of fieldRef")
+	case v1beta1.FileTypeContextKey:
+		v, ok := request.GetContextKey(req, f.ContextKey)
+		if !ok {
+			return nil, errors.Errorf("context key %s not found", f.ContextKey)
+		}
+		if _, ok := v.GetKind().(*structpb.Value_StringValue); ok {
+			return []byte(v.GetStringValue()), nil
+		}
+		b, err := json.Marshal(v.AsInterface())
+		return b, errors.Wrapf(err, "cannot serialize context key %s", f.ContextKey)
+	case v1beta1.FileTypeCredential:
+		if f.CredentialRef == nil {
+			return nil, errors.New("credentialRef must be set")
+		}
+		c, err := request.GetCredentials(req, f.CredentialRef.Name)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot get credentials")
+		}
+		v, ok := c.Data[f.CredentialRef.Key]
+		if !ok {
+			return nil, errors.Errorf("credential %s has no key %s", f.CredentialRef.Name, f.CredentialRef.Key)
+		}
+		return v, nil
+	default:
+		return nil, errors.Errorf("unknown type %s", t)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/crossplane-contrib/function-shell/input/v1beta1"
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+)
+
+func TestWriteFiles(t *testing.T) {
+	req := &fnv1.RunFunctionRequest{
+		Observed: &fnv1.State{
+			Composite: &fnv1.Resource{
+				Resource: resource.MustStructJSON(`{
+					"apiVersion": "",
+					"kind": "",
+					"spec": {
+						"kubeconfig": "apiVersion: v1"
+					}
+				}`),
+			},
+		},
+		Context: resource.MustStructJSON(`{
+			"apiextensions.crossplane.io/environment": {
+				"region": "us-east-1"
+			},
+			"example.org/token": "s3cr3t"
+		}`),
+		Credentials: map[string]*fnv1.Credentials{
+			"aws": {
+				Source: &fnv1.Credentials_CredentialData{
+					CredentialData: &fnv1.CredentialData{
+						Data: map[string][]byte{"credentials": []byte("[default]")},
+					},
+				},
+			},
+		},
+	}
+
+	type file struct {
+		content string
+		mode    os.FileMode
+	}
+
+	type want struct {
+		files map[string]file
+		err   error
+	}
+
+	cases := map[string]struct {
+		reason string
+		files  []v1beta1.File
+		want   want
+	}{
+		"Value": {
+			reason: "A file should be written from a literal value with the default mode.",
+			files:  []v1beta1.File{{Path: "input.json", Value: `{"a": 1}`}},
+			want: want{
+				files: map[string]file{"input.json": {content: `{"a": 1}`, mode: 0o600}},
+			},
+		},
+		"FieldRefWithMode": {
+			reason: "A file should be written from a composite field with the supplied mode, creating parent directories.",
+			files: []v1beta1.File{{
+				Path:     ".kube/config",
+				Mode:     "0640",
+				FieldRef: &v1beta1.FieldRef{Path: "spec.kubeconfig"},
+			}},
+			want: want{
+				files: map[string]file{".kube/config": {content: "apiVersion: v1", mode: 0o640}},
+			},
+		},
+		"ContextKey": {
+			reason: "A context value that isn't a string should be written as JSON, and a string as is.",
+			files: []v1beta1.File{
+				{Path: "environment.json", ContextKey: "apiextensions.crossplane.io/environment"},
+				{Path: "token", ContextKey: "example.org/token"},
+			},
+			want: want{
+				files: map[string]file{
+					"environment.json": {content: `{"region":"us-east-1"}`, mode: 0o600},
+					"token":            {content: "s3cr3t", mode: 0o600},
+				},
+			},
+		},
+		"Credential": {
+			reason: "A file should be written from a key of a credential.",
+			files: []v1beta1.File{{
+				Path:          "aws/credentials",
+				CredentialRef: &v1beta1.CredentialRef{Name: "aws", Key: "credentials"},
+			}},
+			want: want{
+				files: map[string]file{"aws/credentials": {content: "[default]", mode: 0o600}},
+			},
+		},
+		"MissingCredentialKey": {
+			reason: "A missing credential key should return an error.",
+			files: []v1beta1.File{{
+				Path:          "aws/credentials",
+				CredentialRef: &v1beta1.CredentialRef{Name: "aws", Key: "config"},
+			}},
+			want: want{
+				err: errors.New("cannot write file aws/credentials: credential aws has no key config"),
+			},
+		},
+		"PathEscapesWorkingDirectory": {
+			reason: "A path outside of the working directory should return an error.",
+			files:  []v1beta1.File{{Path: "../etc/passwd", Value: "root"}},
+			want: want{
+				err: errors.New("cannot write file ../etc/passwd: path must be relative to, and within, the working directory"),
+			},
+		},
+		"InvalidMode": {
+			reason: "A mode that isn't octal should return an error.",
+			files:  []v1beta1.File{{Path: "script.sh", Value: "echo", Mode: "rwx"}},
+			want: want{
+				err: errors.New(`cannot write file script.sh: mode "rwx" must be an octal file mode like 0600`),
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			err := writeFiles(context.Background(), req, dir, tc.files)
+
+			if tc.want.err != nil && err != nil {
+				if diff := cmp.Diff(tc.want.err.Error(), err.Error()); diff != "" {
+					t.Errorf("%s\nwriteFiles(...): -want err message, +got err message:\n%s", tc.reason, diff)
+				}
+				return
+			} else if diff := cmp.Diff(tc.want.err, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("%s\nwriteFiles(...): -want err, +got err:\n%s", tc.reason, diff)
+			}
+
+			for path, want := range tc.want.files {
+				content, err := os.ReadFile(filepath.Join(dir, path))
+				if err != nil {
+					t.Fatalf("%s\nos.ReadFile(%s): %v", tc.reason, path, err)
+				}
+				info, err := os.Stat(filepath.Join(dir, path))
+				if err != nil {
+					t.Fatalf("%s\nos.Stat(%s): %v", tc.reason, path, err)
+				}
+				got := file{content: string(content), mode: info.Mode().Perm()}
+				if diff := cmp.Diff(want, got, cmp.AllowUnexported(file{})); diff != "" {
+					t.Errorf("%s\nwriteFiles(...): -want file %s, +got file %s:\n%s", tc.reason, path, path, diff)
+				}
+			}
+		})
+	}
+}
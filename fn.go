@@ -1,18 +1,22 @@
 package main
 
 import (
-	"bytes"
 	"context"
 	"fmt"
+	"maps"
+	"os"
 	"os/exec"
-	"strings"
+	"slices"
 	"time"
 
-	"github.com/crossplane-contrib/function-shell/input/v1alpha1"
+	"github.com/crossplane-contrib/function-shell/input/v1beta1"
 	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
 	"github.com/crossplane/crossplane-runtime/v2/pkg/logging"
-	"github.com/keegancsmith/shell"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/protobuf/types/known/durationpb"
+	"k8s.io/apimachinery/pkg/util/validation/field"
 
 	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
 	"github.com/crossplane/function-sdk-go/request"
@@ -23,22 +27,66 @@ import (
 type Function struct {
 	fnv1.UnimplementedFunctionRunnerServiceServer
 
-	log logging.Logger
+	log     logging.Logger
+	metrics *Metrics
+	audit   *Auditor
+	policy  *Policy
+	// requireSandbox runs every shell command in a sandbox, regardless of
+	// its input.
+	requireSandbox bool
+	// defaultNetwork is the network of shell commands whose input doesn't
+	// specify one.
+	defaultNetwork v1beta1.Network
+	// literalShellCommandField runs the shellCommandField of v1alpha1 input
+	// as the command itself, rather than reading the command from the field
+	// it refers to.
+	literalShellCommandField bool
 }
 
 // RunFunction runs the Function.
 //
 //gocognit:ignore
-func (f *Function) RunFunction(_ context.Context, req *fnv1.RunFunctionRequest) (*fnv1.RunFunctionResponse, error) {
+func (f *Function) RunFunction(ctx context.Context, req *fnv1.RunFunctionRequest) (*fnv1.RunFunctionResponse, error) {
 	f.log.Info("Running function", "tag", req.GetMeta().GetTag())
 
 	rsp := response.To(req, response.DefaultTTL)
 
-	in := &v1alpha1.Parameters{}
-	if err := request.GetInput(req, in); err != nil {
+	ctx, span := tracer().Start(extractTraceContext(ctx), "RunFunction", trace.WithAttributes(attribute.String("tag", req.GetMeta().GetTag())))
+	defer func() {
+		for _, r := range rsp.GetResults() {
+			if r.GetSeverity() == fnv1.Severity_SEVERITY_FATAL {
+				span.SetStatus(codes.Error, r.GetMessage())
+			}
+		}
+		span.End()
+	}()
+
+	// Decoding ends early if the input is invalid. Ending a span again is a
+	// no-op.
+	_, decode := tracer().Start(ctx, "DecodeInput")
+	defer decode.End()
+
+	in, conv, err := getInput(req.GetInput(), f.literalShellCommandField)
+	if err != nil {
 		response.Fatal(rsp, errors.Wrapf(err, "cannot get Function from input"))
 		return rsp, nil
 	}
+	if err := conv.unknownFields(); err != nil {
+		response.Warning(rsp, errors.Wrap(err, "ignoring unknown fields of v1alpha1 input"))
+	}
+
+	oxr, err := request.GetObservedCompositeResource(req)
+	if err != nil {
+		response.Fatal(rsp, errors.Wrapf(err, "cannot get observed composite resource from %T", req))
+		return rsp, nil
+	}
+
+	// Our input is an opaque object nested in a Composition. Let's validate
+	// it, and report every problem at once.
+	if errs := conv.fieldErrors(ValidateParameters(in, oxr, f.policy)); len(errs) > 0 {
+		response.Fatal(rsp, errors.Wrap(errs.ToAggregate(), "invalid Function input"))
+		return rsp, nil
+	}
 
 	if in.CacheTTL != "" {
 		dur, err := time.ParseDuration(in.CacheTTL)
@@ -49,18 +97,26 @@ func (f *Function) RunFunction(_ context.Context, req *fnv1.RunFunctionRequest)
 		rsp.Meta.Ttl = durationpb.New(dur)
 	}
 
-	oxr, err := request.GetObservedCompositeResource(req)
-	if err != nil {
-		response.Fatal(rsp, errors.Wrapf(err, "cannot get observed composite resource from %T", req))
-		return rsp, nil
+	if in.Command.Timeout != "" {
+		dur, err := time.ParseDuration(in.Command.Timeout)
+		if err != nil {
+			response.Fatal(rsp, errors.Wrapf(err, "cannot set timeout"))
+			return rsp, nil
+		}
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dur)
+		defer cancel()
 	}
 
-	// Our input is an opaque object nested in a Composition. Let's validate it
-	if err := ValidateParameters(in, oxr); err != nil {
-		response.Fatal(rsp, errors.Wrap(err, "invalid Function input"))
+	retry, err := newRetrier(in.Command.Retry)
+	if err != nil {
+		response.Fatal(rsp, errors.Wrapf(err, "cannot set retry"))
 		return rsp, nil
 	}
 
+	decode.End()
+	span.SetAttributes(attribute.String("xr.kind", oxr.Resource.GetKind()), attribute.String("xr.name", oxr.Resource.GetName()))
+
 	log := f.log.WithValues(
 		"oxr-version", oxr.Resource.GetAPIVersion(),
 		"oxr-kind", oxr.Resource.GetKind(),
@@ -75,104 +131,328 @@ func (f *Function) RunFunction(_ context.Context, req *fnv1.RunFunctionRequest)
 
 	dxr.Resource.SetAPIVersion(oxr.Resource.GetAPIVersion())
 	dxr.Resource.SetKind(oxr.Resource.GetKind())
+	desired := &desiredState{req: req, xr: dxr}
 
-	stdoutField := in.StdoutField
-	if len(in.StdoutField) == 0 {
+	stdoutField := in.Outputs.Stdout.Field
+	if len(stdoutField) == 0 {
 		stdoutField = "status.atFunction.shell.stdout"
 	}
-	stderrField := in.StderrField
-	if len(in.StderrField) == 0 {
+	stderrField := in.Outputs.Stderr.Field
+	if len(stderrField) == 0 {
 		stderrField = "status.atFunction.shell.stderr"
 	}
-
-	shellCmd := ""
-	if len(in.ShellCommand) == 0 && len(in.ShellCommandField) == 0 {
-		log.Info("no shell command in in.ShellCommand nor in.ShellCommandField")
-		return rsp, nil
+	var refreshedField string
+	if in.Outputs.OnFailure == v1beta1.OnFailureKeepPrevious {
+		refreshedField = in.Outputs.RefreshedField
+		if len(refreshedField) == 0 {
+			refreshedField = "status.atFunction.shell.lastRefreshed"
+		}
 	}
 
-	if len(in.ShellCommand) > 0 {
-		shellCmd = in.ShellCommand
+	if in.When != nil {
+		run, err := evaluateWhen(req, in.When)
+		if err != nil {
+			response.Fatal(rsp, errors.Wrap(err, "cannot evaluate when"))
+			return rsp, nil
+		}
+		if !run {
+			log.Debug("Skipping shell command, because its when condition is false")
+			if err := keepPreviousOutputs(oxr, desired, in.Outputs, stdoutField, stderrField, refreshedField); err != nil {
+				response.Fatal(rsp, errors.Wrap(err, "cannot keep previous outputs"))
+				return rsp, nil
+			}
+			if err := desired.setResponse(rsp); err != nil {
+				response.Fatal(rsp, errors.Wrapf(err, "cannot set desired resources from %T", req))
+			}
+			return rsp, nil
+		}
 	}
 
-	// Prefer shell cmd from field over direct function input
-	if len(in.ShellCommandField) > 0 {
-		shellCmd = in.ShellCommandField
+	shellCmd := in.Command.Inline
+	if ref := in.Command.FieldRef; ref != nil {
+		shellCmd, err = fromFieldRef(ctx, req, *ref)
+		if err != nil {
+			response.Fatal(rsp, errors.Wrapf(err, "cannot get shell command from fieldRef %s", ref.Path))
+			return rsp, nil
+		}
+		if shellCmd == "" {
+			response.Fatal(rsp, errors.Errorf("shell command of fieldRef %s is empty", ref.Path))
+			return rsp, nil
+		}
+		if err := f.policy.ValidateCommand(shellCmd, oxr); err != nil {
+			response.Fatal(rsp, errors.Wrap(conv.fieldErrors(field.ErrorList{err}).ToAggregate(), "invalid Function input"))
+			return rsp, nil
+		}
 	}
 
 	shellEnvVars := make(map[string]string)
-	for _, envVar := range in.ShellEnvVars {
-		switch t := envVar.GetType(); t {
-		case v1alpha1.ShellEnvVarTypeValue:
-			shellEnvVars[envVar.Key] = envVar.Value
-		case v1alpha1.ShellEnvVarTypeValueRef:
-			envValue, err := fromValueRef(req, envVar.ValueRef)
+	for _, envVar := range in.Env.Vars {
+		if envVar.FieldRef == nil {
+			shellEnvVars[envVar.Name] = envVar.Value
+			continue
+		}
+		envValue, err := fromFieldRef(ctx, req, *envVar.FieldRef)
+		if err != nil {
+			response.Fatal(rsp, errors.Wrapf(err, "cannot process contents of fieldRef %s", envVar.FieldRef.Path))
+			return rsp, nil
+		}
+		shellEnvVars[envVar.Name] = envValue
+	}
+
+	for _, src := range in.Env.From {
+		switch {
+		case src.EnvVarRef != nil:
+			shellEnvVars, err = addShellEnvVarsFromRef(*src.EnvVarRef, shellEnvVars)
 			if err != nil {
-				response.Fatal(rsp, errors.Wrapf(err, "cannot process contents of valueRef %s", envVar.ValueRef))
+				response.Fatal(rsp, errors.Wrapf(err, "cannot process contents of envVarRef %s", src.EnvVarRef.Name))
 				return rsp, nil
 			}
-			shellEnvVars[envVar.Key] = envValue
-		case v1alpha1.ShellEnvVarTypeFieldRef:
-			envValue, err := fromFieldRef(req, *envVar.FieldRef)
+		case src.FileRef != nil:
+			shellEnvVars, err = addShellEnvVarsFromFile(*src.FileRef, shellEnvVars)
 			if err != nil {
-				response.Fatal(rsp, errors.Wrapf(err, "cannot process contents of fieldRef %s", envVar.ValueRef))
+				response.Fatal(rsp, errors.Wrapf(err, "cannot process contents of fileRef %s", src.FileRef.Path))
 				return rsp, nil
 			}
-			shellEnvVars[envVar.Key] = envValue
-		default:
-			response.Fatal(rsp, errors.Errorf("shellEnvVars: unknown type %s for key %s", t, envVar.Key))
-			return rsp, nil
+		case src.FieldRef != nil:
+			env, err := fromEnvFromFieldRef(ctx, req, *src.FieldRef)
+			if err != nil {
+				response.Fatal(rsp, errors.Wrapf(err, "cannot import contents of fieldRef %s", src.FieldRef.Path))
+				return rsp, nil
+			}
+			maps.Copy(shellEnvVars, env)
 		}
 	}
 
-	if len(in.ShellEnvVarsRef.Keys) > 0 {
-		shellEnvVars, err = addShellEnvVarsFromRef(in.ShellEnvVarsRef, shellEnvVars)
-		if err != nil {
-			response.Fatal(rsp, errors.Wrapf(err, "cannot process contents of shellEnvVarsRef %s", in.ShellEnvVarsRef.Name))
-			return rsp, nil
+	env := make([]string, 0, len(shellEnvVars))
+	for _, k := range slices.Sorted(maps.Keys(shellEnvVars)) {
+		env = append(env, k+"="+shellEnvVars[k])
+	}
+
+	log.Info(shellCmd)
+
+	network := in.Command.Network
+	if network == "" {
+		network = f.defaultNetwork
+	}
+	sb := sandboxOptions{
+		isolateProcess: f.requireSandbox || (in.Command.Sandbox != nil && in.Command.Sandbox.Enabled),
+		isolateNetwork: network == v1beta1.NetworkNone || (in.Command.Sandbox != nil && in.Command.Sandbox.IsolateNetwork),
+	}
+
+	dir, err := newWorkDir()
+	if err != nil {
+		response.Fatal(rsp, err)
+		return rsp, nil
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			log.Info("cannot remove working directory", "dir", dir, "error", err)
 		}
+	}()
+
+	if err := writeFiles(ctx, req, dir, in.Files); err != nil {
+		response.Fatal(rsp, errors.Wrap(err, "cannot process files"))
+		return rsp, nil
 	}
 
-	var exportCmds string
-	// exportCmds = "export PATH=/usr/local/bin:/usr/bin:/bin:/usr/sbin:/sbin;"
-	for k, v := range shellEnvVars {
-		exportCmds = exportCmds + "export " + k + "=\"" + v + "\";"
+	maxOutputSize := in.Outputs.MaxSize
+	if maxOutputSize == 0 {
+		maxOutputSize = defaultMaxOutputSize
 	}
 
-	log.Info(shellCmd)
+	c := command{script: shellCmd, dir: dir, maxOutputSize: maxOutputSize, isolateEnv: f.policy.IsolatesEnv(oxr)}
+	if sb.isolateProcess || sb.isolateNetwork {
+		c.sandbox = &sb
+	}
 
-	var stdout, stderr bytes.Buffer
-	cmd := shell.Commandf(exportCmds + shellCmd)
-	cmd.Stdout = &stdout
-	cmd.Stderr = &stderr
+	kind, tag := oxr.Resource.GetKind(), req.GetMeta().GetTag()
+	f.metrics.recordInvocation(kind, tag)
+	start := time.Now()
+	res, attempts := retry.run(ctx, func(ctx context.Context) commandResult {
+		ctx, span := tracer().Start(ctx, "RunCommand")
+		defer span.End()
 
-	cmderr := cmd.Run()
-	sout := strings.TrimSpace(stdout.String())
-	serr := strings.TrimSpace(stderr.String())
+		// Instrumented tools join the trace of the command.
+		c := c
+		c.env = slices.Concat(env, traceEnv(ctx))
 
-	log.Debug(shellCmd, "stdout", sout, "stderr", serr)
+		attemptStart := time.Now()
+		r := c.run(ctx)
+		f.metrics.recordExecution(kind, tag, r, time.Since(attemptStart))
 
-	err = dxr.Resource.SetValue(stdoutField, sout)
-	if err != nil {
-		response.Fatal(rsp, errors.Wrapf(err, "cannot set field %s to %s for %s", stdoutField, sout, oxr.Resource.GetKind()))
+		span.SetAttributes(attribute.Int("exit_code", r.exitCode()))
+		if r.err != nil {
+			span.RecordError(r.err)
+			span.SetStatus(codes.Error, "shell command failed")
+		}
+		return r
+	})
+
+	files := make([]string, len(in.Files))
+	for i, file := range in.Files {
+		files[i] = file.Path
+	}
+	rec := newAuditRecord(oxr, tag, shellCmd, shellEnvVars, files, attempts, res, time.Since(start), ctx.Err() != nil)
+	if err := f.audit.Write(rec); err != nil {
+		log.Info("cannot write audit record", "error", err)
+	}
+
+	_, assemble := tracer().Start(ctx, "AssembleResponse")
+	defer assemble.End()
+	sout, serr, cmderr := res.stdout, res.stderr, res.err
+
+	// Stdout that connection details are taken from is secret.
+	sensitive := false
+	for _, cd := range in.Outputs.ConnectionDetails {
+		sensitive = sensitive || cd.OutputFile == ""
+	}
+	for _, t := range in.Outputs.Targets {
+		sensitive = sensitive || (t.ConnectionDetail != "" && (t.Source == "" || t.Source == v1beta1.OutputSourceStdout))
+	}
+
+	if sensitive {
+		log.Debug(shellCmd, "stderr", serr, "attempts", attempts)
+	} else {
+		log.Debug(shellCmd, "stdout", sout, "stderr", serr, "attempts", attempts)
+	}
+
+	if res.stdoutExceeded {
+		response.Fatal(rsp, errors.Errorf("stdout of shellCmd %q for %q exceeds maxOutputSize of %d bytes", shellCmd, oxr.Resource.GetKind(), maxOutputSize))
 		return rsp, nil
 	}
 
-	err = dxr.Resource.SetValue(stderrField, serr)
-	if err != nil {
-		response.Fatal(rsp, errors.Wrapf(err, "cannot set field %s to %s for %s", stderrField, serr, oxr.Resource.GetKind()))
+	// The outputs of a failed shell command replace the observed ones,
+	// unless the observed ones should be kept.
+	keep := cmderr != nil && in.Outputs.OnFailure == v1beta1.OnFailureKeepPrevious
+	if keep {
+		if err := keepPreviousOutputs(oxr, desired, in.Outputs, stdoutField, stderrField, refreshedField); err != nil {
+			response.Fatal(rsp, errors.Wrap(err, "cannot keep previous outputs"))
+			return rsp, nil
+		}
+	}
+
+	var stdout any = sout
+	if cmderr == nil {
+		stdout, err = parseOutput([]byte(sout), in.Outputs.Stdout.Format)
+		if err != nil {
+			response.Fatal(rsp, errors.Wrapf(err, "cannot parse stdout of shellCmd %q for %q", shellCmd, oxr.Resource.GetKind()))
+			return rsp, nil
+		}
+	}
+
+	// The stdout of a failed shell command isn't transformed, because it's
+	// rarely what the expression expects. An error transforming its stderr
+	// is reported after the failure of the shell command.
+	var stderr any = serr
+	var trerr error
+	tr := newOutputTransformer(req, in.OutputTransform, stdout, serr, res.exitCode())
+	if !keep && cmderr == nil {
+		if stdout, err = tr.stdout(stdout); err != nil {
+			response.Fatal(rsp, errors.Wrap(err, "cannot process outputTransform"))
+			return rsp, nil
+		}
+	}
+	if !keep {
+		if stderr, trerr = tr.stderr(serr); trerr != nil && cmderr == nil {
+			response.Fatal(rsp, errors.Wrap(trerr, "cannot process outputTransform"))
+			return rsp, nil
+		}
+		if trerr != nil {
+			stderr = serr
+		}
 	}
-	if err := response.SetDesiredCompositeResource(rsp, dxr); err != nil {
-		response.Fatal(rsp, errors.Wrapf(err, "cannot set desired composite resources from %T", req))
+
+	if !keep && !sensitive {
+		err = desired.setValue(stdoutField, stdout)
+		if err != nil {
+			response.Fatal(rsp, errors.Wrapf(err, "cannot set field %s to %s for %s", stdoutField, sout, oxr.Resource.GetKind()))
+			return rsp, nil
+		}
+	}
+
+	if !keep {
+		err = desired.setValue(stderrField, stderr)
+		if err != nil {
+			response.Fatal(rsp, errors.Wrapf(err, "cannot set field %s to %s for %s", stderrField, serr, oxr.Resource.GetKind()))
+		}
+	}
+
+	var outputs map[string]any
+	if cmderr == nil {
+		outputs, err = readOutputFiles(rsp, desired, dir, in.Outputs.Files, maxOutputSize, tr)
+		if err != nil {
+			response.Fatal(rsp, errors.Wrap(err, "cannot process outputFiles"))
+			return rsp, nil
+		}
+		if err := setConnectionDetails(dxr, in.Outputs.ConnectionDetails, stdout, outputs); err != nil {
+			response.Fatal(rsp, errors.Wrap(err, "cannot process connectionDetails"))
+			return rsp, nil
+		}
+		if refreshedField != "" {
+			if err := desired.setValue(refreshedField, time.Now().UTC().Format(time.RFC3339)); err != nil {
+				response.Fatal(rsp, errors.Wrapf(err, "cannot set field %s", refreshedField))
+				return rsp, nil
+			}
+		}
+	}
+
+	// Targets of a failed shell command often expect output it didn't
+	// write, like an object at a fieldPath, so an error writing them is
+	// reported after the failure of the shell command.
+	var tgterr error
+	if !keep {
+		src := outputSources{stdout: stdout, stderr: stderr, exitCode: res.exitCode(), files: outputs}
+		if tgterr = writeOutputTargets(rsp, desired, in.Outputs.Targets, src); tgterr != nil && cmderr == nil {
+			response.Fatal(rsp, errors.Wrap(tgterr, "cannot process output targets"))
+			return rsp, nil
+		}
+	}
+
+	if err := desired.setResponse(rsp); err != nil {
+		response.Fatal(rsp, errors.Wrapf(err, "cannot set desired resources from %T", req))
 	}
 
-	if cmderr != nil {
-		exiterr := &exec.ExitError{}
-		if errors.As(cmderr, &exiterr) {
-			msg := fmt.Sprintf("shellCmd %q for %q failed with %s", shellCmd, oxr.Resource.GetKind(), exiterr.Stderr)
-			response.Fatal(rsp, errors.Wrap(cmderr, msg))
+	var failure error
+	switch {
+	case cmderr == nil:
+		if attempts > 1 {
+			response.Normalf(rsp, "shellCmd %q for %q succeeded after %d attempts", shellCmd, oxr.Resource.GetKind(), attempts)
 		}
+	case ctx.Err() != nil:
+		f.metrics.recordTimeout(kind, tag)
+		msg := fmt.Sprintf("shellCmd %q for %q timed out after %d attempts", shellCmd, oxr.Resource.GetKind(), attempts)
+		failure = errors.Wrap(ctx.Err(), msg)
+	default:
+		failure = commandFailure(shellCmd, oxr.Resource.GetKind(), attempts, serr, cmderr)
+	}
+
+	switch {
+	case failure == nil:
+	case keep:
+		response.Warning(rsp, errors.Wrap(failure, "keeping previous outputs"))
+	default:
+		response.Fatal(rsp, failure)
+	}
+	if trerr != nil {
+		response.Warning(rsp, errors.Wrap(trerr, "cannot process outputTransform"))
+	}
+	if tgterr != nil {
+		response.Warning(rsp, errors.Wrap(tgterr, "cannot process output targets"))
 	}
 
 	return rsp, nil
 }
+
+// commandFailure returns the error of a shell command that failed with the
+// supplied stderr, including one that could not be started, for example
+// because its sandbox could not be created.
+func commandFailure(shellCmd, kind string, attempts int, stderr string, err error) error {
+	if !errors.As(err, new(*exec.ExitError)) {
+		return errors.Wrapf(err, "cannot start shellCmd %q for %q", shellCmd, kind)
+	}
+	msg := fmt.Sprintf("shellCmd %q for %q failed with %s", shellCmd, kind, stderr)
+	if attempts > 1 {
+		msg = fmt.Sprintf("shellCmd %q for %q failed after %d attempts with %s", shellCmd, kind, attempts, stderr)
+	}
+	return errors.Wrap(err, msg)
+}
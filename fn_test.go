@@ -50,7 +50,7 @@ func TestRunFunction(t *testing.T) {
 					Results: []*fnv1.Result{
 						{
 							Severity: fnv1.Severity_SEVERITY_FATAL,
-							Message:  "invalid Function input: parameters: Required value: one of ShellCommand or ShellCommandField is required",
+							Message:  "invalid Function input: parameters.shellCommand: Required value: one of shellCommand or shellCommandField is required",
 							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
 						},
 					},
@@ -75,7 +75,7 @@ func TestRunFunction(t *testing.T) {
 					Results: []*fnv1.Result{
 						{
 							Severity: fnv1.Severity_SEVERITY_FATAL,
-							Message:  "invalid Function input: parameters: Required value: one of ShellCommand or ShellCommandField is required",
+							Message:  "invalid Function input: parameters.shellCommand: Required value: one of shellCommand or shellCommandField is required",
 							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
 						},
 					},
@@ -123,6 +123,49 @@ func TestRunFunction(t *testing.T) {
 				},
 			},
 		},
+		"ResponseIsEchoWithUnknownV1alpha1Field": {
+			reason: "The Function should ignore unknown fields of v1alpha1 input with a warning, like a description earlier releases accepted",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "template.fn.crossplane.io/v1alpha1",
+						"kind": "Parameters",
+						"description": "Prints foo",
+						"shellCommand": "echo foo",
+						"stdoutField": "status.out"
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Desired: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "",
+								"kind": "",
+								"status": {
+									"out": "foo",
+									"atFunction": {
+										"shell": {
+											"stderr": ""
+										}
+									}
+								}
+							}`),
+						},
+					},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_WARNING,
+							Message:  "ignoring unknown fields of v1alpha1 input: error unmarshaling JSON: while decoding JSON: json: unknown field \"description\"",
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
 		"ResponseIsErrorIfInvalidShellCommand": {
 			reason: "The function should write to the specified stderr when the shell command is invalid",
 			args: args{
@@ -160,7 +203,7 @@ func TestRunFunction(t *testing.T) {
 					Results: []*fnv1.Result{
 						{
 							Severity: fnv1.Severity_SEVERITY_FATAL,
-							Message:  "shellCmd \"set -euo pìpefail\" for \"\" failed with : exit status 2",
+							Message:  "shellCmd \"set -euo pìpefail\" for \"\" failed with /bin/sh: .*set: .*pìpefail: exit status 2",
 							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
 						},
 					},
@@ -349,6 +392,55 @@ func TestRunFunction(t *testing.T) {
 				},
 			},
 		},
+		"ResponseIsEchoEnvVarsWithShellCharacters": {
+			reason: "The Function should pass environment variables to the shell command as is, without expanding them",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "template.fn.crossplane.io/v1beta1",
+						"kind": "Parameters",
+						"command": {"inline": "printf '%s %s' \"$TAGS\" \"$PASSWORD\""},
+						"env": {"vars": [
+							{"name": "TAGS", "fieldRef": {"path": "spec.tags", "format": "JSON"}},
+							{"name": "PASSWORD", "value": "pa$$word \"$(id)\" 100%"}
+						]}
+					}`),
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "",
+								"kind": "",
+								"spec": {
+									"tags": {"team": "a b"}
+								}
+							}`),
+						},
+					},
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Desired: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "",
+								"kind": "",
+								"status": {
+									"atFunction": {
+										"shell": {
+											"stdout": "{\"team\":\"a b\"} pa$$word \"$(id)\" 100%",
+											"stderr": ""
+										}
+									}
+								}
+							}`),
+						},
+					},
+				},
+			},
+		},
 		"ResponseIsEchoEnvVarFieldRefDefaultValue": {
 			reason: "The Function should accept and use environment variables from a default FieldRef ",
 			args: args{
@@ -391,6 +483,54 @@ func TestRunFunction(t *testing.T) {
 				},
 			},
 		},
+		"ResponseIsEchoEnvFromOverridingVars": {
+			reason: "The Function should let variables imported by env.from override env.vars of the same name",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "template.fn.crossplane.io/v1beta1",
+						"kind": "Parameters",
+						"command": {"inline": "echo $XR_REGION $XR_SIZE"},
+						"env": {
+							"vars": [{"name": "XR_REGION", "value": "eu-west-1"}, {"name": "XR_SIZE", "value": "small"}],
+							"from": [{"fieldRef": {"path": "spec.parameters", "prefix": "XR_"}}]
+						},
+						"outputs": {"stdout": {"field": "status.out"}}
+					}`),
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "",
+								"kind": "",
+								"spec": {"parameters": {"region": "us-east-1"}}
+							}`),
+						},
+					},
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Desired: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "",
+								"kind": "",
+								"status": {
+									"out": "us-east-1 small",
+									"atFunction": {
+										"shell": {
+											"stderr": ""
+										}
+									}
+								}
+							}`),
+						},
+					},
+				},
+			},
+		},
 		"ResponseIsErrorWhenBadShellEnvVarTypeIsProvided": {
 			reason: "The Function should return an error when a bad shellEnVars type is provided",
 			args: args{
@@ -411,7 +551,7 @@ func TestRunFunction(t *testing.T) {
 					Results: []*fnv1.Result{
 						{
 							Severity: fnv1.Severity_SEVERITY_FATAL,
-							Message:  "shellEnvVars: unknown type bad for key TEST_ENV_VAR",
+							Message:  `invalid Function input: parameters.shellEnvVars[0].type: Unsupported value: "bad": supported values: "Value", "ValueRef", "FieldRef"`,
 							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
 						},
 					},
@@ -438,7 +578,7 @@ func TestRunFunction(t *testing.T) {
 					Results: []*fnv1.Result{
 						{
 							Severity: fnv1.Severity_SEVERITY_FATAL,
-							Message:  "cannot process contents of fieldRef : path must be set",
+							Message:  "invalid Function input: parameters.shellEnvVars[0].fieldRef.path: Required value: path is required",
 							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
 						},
 					},
@@ -507,40 +647,1107 @@ func TestRunFunction(t *testing.T) {
 					Results: []*fnv1.Result{
 						{
 							Severity: fnv1.Severity_SEVERITY_FATAL,
-							Message:  `cannot set cacheTTL: time: unknown unit "x" in duration "5x"`,
+							Message:  `invalid Function input: parameters.cacheTTL: Invalid value: "5x": must be a duration like 30s or 2m`,
 							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
 						},
 					},
 				},
 			},
 		},
-	}
-
-	for name, tc := range cases {
-		t.Run(name, func(t *testing.T) {
-			f := &Function{log: logging.NewNopLogger()}
-			rsp, err := f.RunFunction(tc.args.ctx, tc.args.req)
-
-			var cmpOpts []cmp.Option
-			cmpOpts = append(cmpOpts, protocmp.Transform(), protocmp.IgnoreFields(&fnv1.Result{}, "message"))
-
-			if tc.args.useRegex {
-				cmpOpts = append(cmpOpts, cmp.Comparer(func(expected, actual string) bool {
-					// If expected looks like a regex pattern, use regex matching
-					if regexp.MustCompile(`^.*\.\*.*$`).MatchString(expected) {
-						matched, _ := regexp.MatchString(expected, actual)
-						return matched
-					}
-					return expected == actual
-				}))
-			}
-
-			if diff := cmp.Diff(tc.want.rsp, rsp, cmpOpts...); diff != "" {
-				t.Errorf("%s\nf.RunFunction(...): -want rsp, +got rsp:\n%s", tc.reason, diff)
-			}
-
-			if diff := cmp.Diff(tc.want.err, err, cmpopts.EquateErrors()); diff != "" {
-				t.Errorf("%s\nf.RunFunction(...): -want err, +got err:\n%s", tc.reason, diff)
+		"ResponseListsEveryInvalidField": {
+			reason: "The Function should return one fatal result that lists every invalid field of its input",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "template.fn.crossplane.io/v1alpha1",
+						"kind": "Parameters",
+						"shellCommand": "echo test",
+						"cacheTTL": "5x",
+						"timeout": "soon",
+						"network": "Host"
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_FATAL,
+							Message:  `invalid Function input: [parameters.network: Unsupported value: "Host": supported values: "None", "Inherit", parameters.cacheTTL: Invalid value: "5x": must be a duration like 30s or 2m, parameters.timeout: Invalid value: "soon": must be a duration like 30s or 2m]`,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"ResponseIsErrorWhenNetworkIsUnknown": {
+			reason: "The Function should return a fatal result if the network is not supported",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "template.fn.crossplane.io/v1alpha1",
+						"kind": "Parameters",
+						"shellCommand": "echo foo",
+						"network": "Host"
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_FATAL,
+							Message:  `invalid Function input: parameters.network: Unsupported value: "Host": supported values: "None", "Inherit"`,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"ResponseIsFileContent": {
+			reason: "The Function should run the command in a new working directory containing the files",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "template.fn.crossplane.io/v1alpha1",
+						"kind": "Parameters",
+						"files": [{"path": "input.json", "value": "{\"a\": 1}"}],
+						"shellCommand": "ls; cat input.json",
+						"stdoutField": "spec.atFunction.shell.stdout"
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Desired: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "",
+								"kind": "",
+								"spec": {
+									"atFunction": {
+										"shell": {
+											"stdout": "input.json\n{\"a\": 1}"
+										}
+									}
+								},
+								"status": {
+									"atFunction": {
+										"shell": {
+											"stderr": ""
+										}
+									}
+								}
+							}`),
+						},
+					},
+				},
+			},
+		},
+		"ResponseIsParsedStdoutAndOutputFiles": {
+			reason: "The Function should parse stdout, and write output files to the composite and its connection details",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "template.fn.crossplane.io/v1alpha1",
+						"kind": "Parameters",
+						"shellCommand": "echo 'replicas: 3'; echo '{\"host\": \"db\"}' > out.json; printf s3cr3t > token",
+						"stdoutFormat": "YAML",
+						"outputFiles": [
+							{"path": "out.json", "format": "JSON", "field": "status.outputs"},
+							{"path": "token", "connectionDetail": "token"}
+						]
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Desired: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "",
+								"kind": "",
+								"status": {
+									"atFunction": {
+										"shell": {
+											"stdout": {"replicas": 3},
+											"stderr": ""
+										}
+									},
+									"outputs": {"host": "db"}
+								}
+							}`),
+							ConnectionDetails: map[string][]byte{"token": []byte("s3cr3t")},
+						},
+					},
+				},
+			},
+		},
+		"ResponseIsV1beta1Input": {
+			reason: "The Function should run the command of v1beta1 input, with its env and outputs",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "template.fn.crossplane.io/v1beta1",
+						"kind": "Parameters",
+						"command": {"inline": "echo {\\\"region\\\": \\\"$REGION\\\"}; echo $GREETING > greeting", "timeout": "30s"},
+						"env": {"vars": [
+							{"name": "REGION", "fieldRef": {"path": "spec.region"}},
+							{"name": "GREETING", "value": "hello"}
+						]},
+						"outputs": {
+							"stdout": {"field": "status.lookup", "format": "JSON"},
+							"stderr": {"field": "status.errors"},
+							"files": [{"path": "greeting", "contextKey": "example.org/greeting"}]
+						}
+					}`),
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "",
+								"kind": "",
+								"spec": {"region": "eu-west-1"}
+							}`),
+						},
+					},
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta:    &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Context: resource.MustStructJSON(`{"example.org/greeting": "hello"}`),
+					Desired: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "",
+								"kind": "",
+								"status": {
+									"lookup": {"region": "eu-west-1"},
+									"errors": ""
+								}
+							}`),
+						},
+					},
+				},
+			},
+		},
+		"ResponseIsCommandFromFieldRef": {
+			reason: "The Function should run the command in the field command.fieldRef refers to",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "template.fn.crossplane.io/v1beta1",
+						"kind": "Parameters",
+						"command": {"fieldRef": {"path": "spec.commands[?(@.name==\"greet\")].run"}}
+					}`),
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "",
+								"kind": "",
+								"spec": {"commands": [{"name": "greet", "run": "echo hello"}]}
+							}`),
+						},
+					},
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Desired: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "",
+								"kind": "",
+								"status": {
+									"atFunction": {
+										"shell": {
+											"stdout": "hello",
+											"stderr": ""
+										}
+									}
+								}
+							}`),
+						},
+					},
+				},
+			},
+		},
+		"ResponseIsErrorWhenShellCommandFieldIsMissing": {
+			reason: "The Function should return a fatal result if the field shellCommandField refers to doesn't exist",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "template.fn.crossplane.io/v1alpha1",
+						"kind": "Parameters",
+						"shellCommandField": "spec.command"
+					}`),
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "",
+								"kind": ""
+							}`),
+						},
+					},
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_FATAL,
+							Message:  "cannot get shell command from fieldRef spec.command: cannot get observed composite value: spec.command: no such field",
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"ResponseIsPreviousOutputsWhenSkipped": {
+			reason: "The Function should skip the command if its when condition is false, and keep the observed values of its outputs",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "template.fn.crossplane.io/v1beta1",
+						"kind": "Parameters",
+						"when": {"fields": [{"path": "spec.enabled", "equals": "true"}]},
+						"command": {"inline": "exit 1"},
+						"outputs": {
+							"files": [{"path": "token", "connectionDetail": "token"}]
+						}
+					}`),
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "",
+								"kind": "",
+								"spec": {"enabled": false},
+								"status": {
+									"atFunction": {
+										"shell": {
+											"stdout": "hello",
+											"stderr": ""
+										}
+									}
+								}
+							}`),
+							ConnectionDetails: map[string][]byte{"token": []byte("s3cr3t"), "other": []byte("x")},
+						},
+					},
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Desired: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "",
+								"kind": "",
+								"status": {
+									"atFunction": {
+										"shell": {
+											"stdout": "hello",
+											"stderr": ""
+										}
+									}
+								}
+							}`),
+							ConnectionDetails: map[string][]byte{"token": []byte("s3cr3t")},
+						},
+					},
+				},
+			},
+		},
+		"ResponseIsPreviousComposedOutputsWhenSkipped": {
+			reason: "The Function should keep the observed values of fields of desired composed resources if the command is skipped",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "template.fn.crossplane.io/v1beta1",
+						"kind": "Parameters",
+						"when": {"fields": [{"path": "spec.enabled", "equals": "true"}]},
+						"command": {"inline": "echo ami-2"},
+						"outputs": {
+							"stdout": {"field": "desiredResources[instance].spec.forProvider.ami"},
+							"stderr": {"field": "desiredResources[instance].metadata.annotations[shell-stderr]"}
+						}
+					}`),
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{"apiVersion": "", "kind": "", "spec": {"enabled": false}}`),
+						},
+						Resources: map[string]*fnv1.Resource{
+							"instance": {Resource: resource.MustStructJSON(`{
+								"apiVersion": "ec2.aws.upbound.io/v1beta1",
+								"kind": "Instance",
+								"spec": {"forProvider": {"ami": "ami-1"}}
+							}`)},
+						},
+					},
+					Desired: &fnv1.State{
+						Resources: map[string]*fnv1.Resource{
+							"instance": {Resource: resource.MustStructJSON(`{"apiVersion": "ec2.aws.upbound.io/v1beta1", "kind": "Instance"}`)},
+						},
+					},
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Desired: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{"apiVersion": "", "kind": ""}`),
+						},
+						Resources: map[string]*fnv1.Resource{
+							"instance": {Resource: resource.MustStructJSON(`{
+								"apiVersion": "ec2.aws.upbound.io/v1beta1",
+								"kind": "Instance",
+								"spec": {"forProvider": {"ami": "ami-1"}}
+							}`)},
+						},
+					},
+				},
+			},
+		},
+		"ResponseIsPreviousComposedOutputsOnFailure": {
+			reason: "The Function should keep the observed values of fields of desired composed resources if the command fails and onFailure is KeepPrevious",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "template.fn.crossplane.io/v1beta1",
+						"kind": "Parameters",
+						"command": {"inline": "echo ami-2; exit 1"},
+						"outputs": {
+							"stdout": {"field": "desiredResources[instance].spec.forProvider.ami"},
+							"stderr": {"field": "desiredResources[instance].metadata.annotations[shell-stderr]"},
+							"onFailure": "KeepPrevious",
+							"refreshedField": "desiredResources[instance].metadata.annotations[shell-refreshed]"
+						}
+					}`),
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{"apiVersion": "", "kind": "", "spec": {"enabled": false}}`),
+						},
+						Resources: map[string]*fnv1.Resource{
+							"instance": {Resource: resource.MustStructJSON(`{
+								"apiVersion": "ec2.aws.upbound.io/v1beta1",
+								"kind": "Instance",
+								"spec": {"forProvider": {"ami": "ami-1"}}
+							}`)},
+						},
+					},
+					Desired: &fnv1.State{
+						Resources: map[string]*fnv1.Resource{
+							"instance": {Resource: resource.MustStructJSON(`{"apiVersion": "ec2.aws.upbound.io/v1beta1", "kind": "Instance"}`)},
+						},
+					},
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Desired: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{"apiVersion": "", "kind": ""}`),
+						},
+						Resources: map[string]*fnv1.Resource{
+							"instance": {Resource: resource.MustStructJSON(`{
+								"apiVersion": "ec2.aws.upbound.io/v1beta1",
+								"kind": "Instance",
+								"spec": {"forProvider": {"ami": "ami-1"}}
+							}`)},
+						},
+					},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_WARNING,
+							Message:  "keeping previous outputs: shellCmd \"echo ami-2; exit 1\" for \"\" failed with : exit status 1",
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"ResponseIsPreviousOutputsOnFailure": {
+			reason: "The Function should keep the observed values of the outputs, and return a warning, if the command fails and onFailure is KeepPrevious",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "template.fn.crossplane.io/v1beta1",
+						"kind": "Parameters",
+						"command": {"inline": "echo new; exit 1"},
+						"outputs": {"onFailure": "KeepPrevious"}
+					}`),
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "",
+								"kind": "",
+								"status": {
+									"atFunction": {
+										"shell": {
+											"stdout": "old",
+											"stderr": "",
+											"lastRefreshed": "2024-01-01T00:00:00Z"
+										}
+									}
+								}
+							}`),
+						},
+					},
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Desired: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "",
+								"kind": "",
+								"status": {
+									"atFunction": {
+										"shell": {
+											"stdout": "old",
+											"stderr": "",
+											"lastRefreshed": "2024-01-01T00:00:00Z"
+										}
+									}
+								}
+							}`),
+						},
+					},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_WARNING,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"ResponseIsRefreshedTimeOnSuccess": {
+			reason: "The Function should write the time the outputs were refreshed if the command succeeds and onFailure is KeepPrevious",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "template.fn.crossplane.io/v1beta1",
+						"kind": "Parameters",
+						"command": {"inline": "echo new"},
+						"outputs": {"onFailure": "KeepPrevious", "refreshedField": "status.refreshed"}
+					}`),
+				},
+				useRegex: true,
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Desired: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "",
+								"kind": "",
+								"status": {
+									"atFunction": {
+										"shell": {
+											"stdout": "new",
+											"stderr": ""
+										}
+									},
+									"refreshed": "^\\d{4}-\\d\\d-\\d\\dT.*Z$"
+								}
+							}`),
+						},
+					},
+				},
+			},
+		},
+		"ResponseIsFailureNotTransformedOutputs": {
+			reason: "The Function should report the failure of the shell command, rather than an error of an outputTransform expression",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "template.fn.crossplane.io/v1beta1",
+						"kind": "Parameters",
+						"command": {"inline": "echo oops; echo boom >&2; exit 3"},
+						"outputs": {
+							"stdout": {"format": "JSON"}
+						},
+						"outputTransform": {
+							"stdout": "stdout.image.id",
+							"stderr": "stderr.missing"
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Desired: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "",
+								"kind": "",
+								"status": {
+									"atFunction": {
+										"shell": {
+											"stdout": "oops",
+											"stderr": "boom"
+										}
+									}
+								}
+							}`),
+						},
+					},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_FATAL,
+							Message:  "shellCmd \"echo oops; echo boom >&2; exit 3\" for \"\" failed with boom: exit status 3",
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_WARNING,
+							Message:  "cannot process outputTransform: cannot transform stderr: no such key: missing",
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"ResponseIsTransformedOutputs": {
+			reason: "The Function should write the values of the outputTransform expressions instead of the parsed outputs",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "template.fn.crossplane.io/v1beta1",
+						"kind": "Parameters",
+						"command": {"inline": "echo '{\"Images\": [{\"ImageId\": \"ami-1\"}]}'; echo '{\"host\": \"db\"}' > out.json"},
+						"outputs": {
+							"stdout": {"format": "JSON"},
+							"files": [{"path": "out.json", "format": "JSON", "field": "status.endpoint"}]
+						},
+						"outputTransform": {
+							"stdout": "stdout.Images[0].ImageId",
+							"files": [{"path": "out.json", "expression": "file.host + '.' + xr.spec.domain"}]
+						}
+					}`),
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "",
+								"kind": "",
+								"spec": {"domain": "example.org"}
+							}`),
+						},
+					},
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Desired: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "",
+								"kind": "",
+								"status": {
+									"atFunction": {
+										"shell": {
+											"stdout": "ami-1",
+											"stderr": ""
+										}
+									},
+									"endpoint": "db.example.org"
+								}
+							}`),
+						},
+					},
+				},
+			},
+		},
+		"ResponseIsOutputTargets": {
+			reason: "The Function should write every output target",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "template.fn.crossplane.io/v1beta1",
+						"kind": "Parameters",
+						"command": {"inline": "echo '{\"image\": \"ami-1\", \"size\": \"small\"}'"},
+						"outputs": {
+							"stdout": {"format": "JSON"},
+							"targets": [
+								{"fieldPath": "size", "field": "status.size"},
+								{"source": "ExitCode", "contextKey": "example.org/exit-code"},
+								{"fieldPath": "image", "field": "desiredResources[instance].spec.forProvider.ami"}
+							]
+						}
+					}`),
+					Desired: &fnv1.State{
+						Resources: map[string]*fnv1.Resource{
+							"instance": {Resource: resource.MustStructJSON(`{"apiVersion": "ec2.aws.upbound.io/v1beta1", "kind": "Instance"}`)},
+						},
+					},
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta:    &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Context: resource.MustStructJSON(`{"example.org/exit-code": 0}`),
+					Desired: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "",
+								"kind": "",
+								"status": {
+									"atFunction": {
+										"shell": {
+											"stdout": {"image": "ami-1", "size": "small"},
+											"stderr": ""
+										}
+									},
+									"size": "small"
+								}
+							}`),
+						},
+						Resources: map[string]*fnv1.Resource{
+							"instance": {Resource: resource.MustStructJSON(`{
+								"apiVersion": "ec2.aws.upbound.io/v1beta1",
+								"kind": "Instance",
+								"spec": {"forProvider": {"ami": "ami-1"}}
+							}`)},
+						},
+					},
+				},
+			},
+		},
+		"ResponseIsFailureNotOutputTargets": {
+			reason: "The Function should report the failure of the shell command, rather than an error of an output target that expects output the command didn't write",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "template.fn.crossplane.io/v1beta1",
+						"kind": "Parameters",
+						"command": {"inline": "echo boom >&2; exit 3"},
+						"outputs": {
+							"stdout": {"format": "JSON"},
+							"targets": [
+								{"source": "ExitCode", "contextKey": "example.org/exit-code"},
+								{"fieldPath": "image", "field": "status.image"}
+							]
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta:    &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Context: resource.MustStructJSON(`{"example.org/exit-code": 3}`),
+					Desired: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "",
+								"kind": "",
+								"status": {
+									"atFunction": {
+										"shell": {
+											"stdout": "",
+											"stderr": "boom"
+										}
+									}
+								}
+							}`),
+						},
+					},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_FATAL,
+							Message:  "shellCmd \"echo boom >&2; exit 3\" for \"\" failed with boom: exit status 3",
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+						{
+							Severity: fnv1.Severity_SEVERITY_WARNING,
+							Message:  "cannot process output targets: cannot get value of target 1: cannot get fieldPath image: output is not an object",
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"ResponseIsPatchedDesiredResources": {
+			reason: "The Function should write outputs to fields of desired composed resources",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "template.fn.crossplane.io/v1beta1",
+						"kind": "Parameters",
+						"command": {"inline": "echo ami-1"},
+						"outputs": {
+							"stdout": {"field": "desiredResources[instance].spec.forProvider.ami"}
+						}
+					}`),
+					Desired: &fnv1.State{
+						Resources: map[string]*fnv1.Resource{
+							"instance": {Resource: resource.MustStructJSON(`{"apiVersion": "ec2.aws.upbound.io/v1beta1", "kind": "Instance"}`)},
+						},
+					},
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Desired: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "",
+								"kind": "",
+								"status": {
+									"atFunction": {
+										"shell": {
+											"stderr": ""
+										}
+									}
+								}
+							}`),
+						},
+						Resources: map[string]*fnv1.Resource{
+							"instance": {Resource: resource.MustStructJSON(`{
+								"apiVersion": "ec2.aws.upbound.io/v1beta1",
+								"kind": "Instance",
+								"spec": {"forProvider": {"ami": "ami-1"}}
+							}`)},
+						},
+					},
+				},
+			},
+		},
+		"ResponseIsFatalWhenDesiredResourceIsMissing": {
+			reason: "The Function should return a fatal result when an output is written to a desired composed resource that doesn't exist",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "template.fn.crossplane.io/v1beta1",
+						"kind": "Parameters",
+						"command": {"inline": "echo ami-1"},
+						"outputs": {
+							"stdout": {"field": "desiredResources[instance].spec.forProvider.ami"}
+						}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_FATAL,
+							Message:  "cannot set field desiredResources[instance].spec.forProvider.ami: desired composed resource instance does not exist",
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"ResponseIsConnectionDetailsFromStdout": {
+			reason: "The Function should write connection details from stdout, and not write stdout to the composite",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "template.fn.crossplane.io/v1alpha1",
+						"kind": "Parameters",
+						"shellCommand": "echo '{\"token\": \"s3cr3t\"}'",
+						"stdoutFormat": "JSON",
+						"connectionDetails": [{"name": "token", "fieldPath": "token"}]
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Desired: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "",
+								"kind": "",
+								"status": {
+									"atFunction": {
+										"shell": {
+											"stderr": ""
+										}
+									}
+								}
+							}`),
+							ConnectionDetails: map[string][]byte{"token": []byte("s3cr3t")},
+						},
+					},
+				},
+			},
+		},
+		"ResponseIsFatalWhenStdoutExceedsMaxOutputSize": {
+			reason: "The Function should return a fatal result when stdout is larger than maxOutputSize",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "template.fn.crossplane.io/v1alpha1",
+						"kind": "Parameters",
+						"shellCommand": "echo hello world",
+						"maxOutputSize": 5
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_FATAL,
+							Message:  "stdout of shellCmd \"echo hello world\" for \"\" exceeds maxOutputSize of 5 bytes",
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"ResponseIsFatalAfterRetriesAreExhausted": {
+			reason: "The Function should retry a command failing with a retryable exit code and return a fatal result once attempts are exhausted",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "template.fn.crossplane.io/v1alpha1",
+						"kind": "Parameters",
+						"shellCommand": "echo 'throttled' >&2; exit 3",
+						"retry": {"attempts": 2, "initialBackoff": "1ms", "retryableExitCodes": [3]}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Desired: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "",
+								"kind": "",
+								"status": {
+									"atFunction": {
+										"shell": {
+											"stdout": "",
+											"stderr": "throttled"
+										}
+									}
+								}
+							}`),
+						},
+					},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_FATAL,
+							Message:  "shellCmd \"echo 'throttled' >&2; exit 3\" for \"\" failed after 2 attempts with throttled: exit status 3",
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"ResponseWithInvalidRetryBackoff": {
+			reason: "The Function should return a fatal error when the retry backoff has an invalid format",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "template.fn.crossplane.io/v1alpha1",
+						"kind": "Parameters",
+						"shellCommand": "echo test",
+						"retry": {"initialBackoff": "1x"}
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_FATAL,
+							Message:  `invalid Function input: parameters.retry.initialBackoff: Invalid value: "1x": must be a duration like 30s or 2m`,
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+		"ResponseIsFatalWhenTimeoutIsExceeded": {
+			reason: "The Function should kill the command and return a fatal result when the timeout is exceeded",
+			args: args{
+				req: &fnv1.RunFunctionRequest{
+					Meta: &fnv1.RequestMeta{Tag: "hello"},
+					Input: resource.MustStructJSON(`{
+						"apiVersion": "template.fn.crossplane.io/v1alpha1",
+						"kind": "Parameters",
+						"shellCommand": "sleep 10",
+						"timeout": "100ms"
+					}`),
+				},
+			},
+			want: want{
+				rsp: &fnv1.RunFunctionResponse{
+					Meta: &fnv1.ResponseMeta{Tag: "hello", Ttl: durationpb.New(response.DefaultTTL)},
+					Desired: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{
+								"apiVersion": "",
+								"kind": "",
+								"status": {
+									"atFunction": {
+										"shell": {
+											"stdout": "",
+											"stderr": ""
+										}
+									}
+								}
+							}`),
+						},
+					},
+					Results: []*fnv1.Result{
+						{
+							Severity: fnv1.Severity_SEVERITY_FATAL,
+							Message:  "shellCmd \"sleep 10\" for \"\" timed out after 1 attempts: context deadline exceeded",
+							Target:   fnv1.Target_TARGET_COMPOSITE.Enum(),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			ctx := tc.args.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			f := &Function{log: logging.NewNopLogger()}
+			rsp, err := f.RunFunction(ctx, tc.args.req)
+
+			var cmpOpts []cmp.Option
+			cmpOpts = append(cmpOpts, protocmp.Transform(), protocmp.IgnoreFields(&fnv1.Result{}, "message"))
+
+			if tc.args.useRegex {
+				cmpOpts = append(cmpOpts, cmp.Comparer(func(expected, actual string) bool {
+					// If expected looks like a regex pattern, use regex matching
+					if regexp.MustCompile(`^.*\.\*.*$`).MatchString(expected) {
+						matched, _ := regexp.MatchString(expected, actual)
+						return matched
+					}
+					return expected == actual
+				}))
+			}
+
+			if diff := cmp.Diff(tc.want.rsp, rsp, cmpOpts...); diff != "" {
+				t.Errorf("%s\nf.RunFunction(...): -want rsp, +got rsp:\n%s", tc.reason, diff)
+			}
+
+			if diff := cmp.Diff(tc.want.err, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("%s\nf.RunFunction(...): -want err, +got err:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestCommandFailure(t *testing.T) {
+	type args struct {
+		c        command
+		attempts int
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   string
+	}{
+		"Failed": {
+			reason: "A shell command that exits with a non-zero code should fail.",
+			args: args{
+				c:        command{script: "echo boom >&2; exit 3"},
+				attempts: 2,
+			},
+			want: `shellCmd "echo boom >&2; exit 3" for "XBucket" failed after 2 attempts with boom: exit status 3`,
+		},
+		"NotStarted": {
+			reason: "A shell command that could not be started, like one whose sandbox could not be created, should fail rather than succeed without output.",
+			args: args{
+				c:        command{script: "echo hello", dir: "/nonexistent"},
+				attempts: 1,
+			},
+			want: `cannot start shellCmd "echo hello" for "XBucket": fork/exec /bin/sh: no such file or directory`,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := tc.args.c.run(context.Background())
+			got := commandFailure(tc.args.c.script, "XBucket", tc.args.attempts, r.stderr, r.err)
+			if diff := cmp.Diff(tc.want, got.Error()); diff != "" {
+				t.Errorf("%s\ncommandFailure(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestCommandIsolateEnv(t *testing.T) {
+	t.Setenv("FUNCTION_SHELL_TEST_SECRET", "s3cr3t")
+
+	cases := map[string]struct {
+		reason string
+		c      command
+		want   string
+	}{
+		"Inherited": {
+			reason: "A shell command should inherit the environment of the function.",
+			c:      command{script: `echo "${FUNCTION_SHELL_TEST_SECRET:-unset} ${FOO:-unset}"`, env: []string{"FOO=bar"}},
+			want:   "s3cr3t bar",
+		},
+		"Isolated": {
+			reason: "A shell command with an isolated environment should only get PATH and HOME of the environment of the function.",
+			c:      command{script: `echo "${FUNCTION_SHELL_TEST_SECRET:-unset} ${FOO:-unset} ${PATH:+path}"`, env: []string{"FOO=bar"}, isolateEnv: true},
+			want:   "unset bar path",
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := tc.c.run(context.Background())
+			if r.err != nil {
+				t.Fatalf("%s\nrun(...): %v", tc.reason, r.err)
+			}
+			if diff := cmp.Diff(tc.want, r.stdout); diff != "" {
+				t.Errorf("%s\nrun(...): -want stdout, +got stdout:\n%s", tc.reason, diff)
 			}
 		})
 	}
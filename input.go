@@ -0,0 +1,94 @@
+package main
+
+import (
+	"slices"
+
+	"github.com/crossplane-contrib/function-shell/input/v1alpha1"
+	"github.com/crossplane-contrib/function-shell/input/v1beta1"
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/yaml"
+)
+
+// getInput returns the Parameters input of the function as v1beta1. Input of
+// any other version is decoded as v1alpha1 and converted, and the returned
+// conversion reports problems at the v1alpha1 fields. If literalCommandField
+// is true the shellCommandField of v1alpha1 input is the command itself, like
+// earlier releases, rather than a reference to it.
+func getInput(s *structpb.Struct, literalCommandField bool) (*v1beta1.Parameters, *conversion, error) {
+	b, err := protojson.Marshal(s)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "cannot marshal input to JSON")
+	}
+
+	if isV1beta1(s) {
+		in := &v1beta1.Parameters{}
+		if err := yaml.UnmarshalStrict(b, in); err != nil {
+			return nil, nil, err
+		}
+		return in, nil, nil
+	}
+
+	// Earlier releases ignored unknown v1alpha1 fields, like a description,
+	// so they're reported rather than rejected.
+	old := &v1alpha1.Parameters{}
+	if err := yaml.Unmarshal(b, old); err != nil {
+		return nil, nil, err
+	}
+	unknown := yaml.UnmarshalStrict(b, &v1alpha1.Parameters{})
+	in := &v1beta1.Parameters{}
+	errs := old.ConvertTo(in)
+	if literalCommandField && old.ShellCommandField != "" {
+		in.Command.Inline = old.ShellCommandField
+		in.Command.FieldRef = nil
+	}
+	return in, &conversion{from: old, errs: errs, unknown: unknown}, nil
+}
+
+// A conversion of v1alpha1 input to v1beta1. A nil conversion is input that
+// was v1beta1 to begin with.
+type conversion struct {
+	from *v1alpha1.Parameters
+	// errs are the problems with v1alpha1 fields that can't be converted.
+	errs field.ErrorList
+	// unknown is the error decoding fields v1alpha1 doesn't know, which are
+	// ignored.
+	unknown error
+}
+
+// unknownFields returns an error if the v1alpha1 input had fields that were
+// ignored.
+func (c *conversion) unknownFields() error {
+	if c == nil {
+		return nil
+	}
+	return c.unknown
+}
+
+// fieldErrors returns the problems with fields that can't be converted, and
+// the supplied problems with the converted input at the v1alpha1 fields they
+// were converted from, so that every problem is reported at a field of the
+// input the user wrote.
+func (c *conversion) fieldErrors(errs field.ErrorList) field.ErrorList {
+	if c == nil {
+		return errs
+	}
+	return append(slices.Clone(c.errs), c.from.ConvertFieldErrors(errs)...)
+}
+
+// isV1beta1 returns true if the input is v1beta1. Earlier examples used the
+// v1beta1 apiVersion with v1alpha1 fields, so v1beta1 input with a top-level
+// shellCommand or shellCommandField is treated as v1alpha1.
+func isV1beta1(s *structpb.Struct) bool {
+	f := s.GetFields()
+	gv, err := schema.ParseGroupVersion(f["apiVersion"].GetStringValue())
+	if err != nil || gv.Version != v1beta1.Version {
+		return false
+	}
+	_, cmd := f["shellCommand"]
+	_, cmdField := f["shellCommandField"]
+	return !cmd && !cmdField
+}
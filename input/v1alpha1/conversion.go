@@ -0,0 +1,238 @@
+package v1alpha1
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	"github.com/crossplane-contrib/function-shell/input/v1beta1"
+)
+
+// ConvertTo converts the Parameters to v1beta1. It returns the problems with
+// fields that can't be converted, like shell environment variables of an
+// unknown type. Fields with problems are left unset in dst.
+func (p *Parameters) ConvertTo(dst *v1beta1.Parameters) field.ErrorList {
+	var errs field.ErrorList
+	root := field.NewPath("parameters")
+
+	gvk := p.GroupVersionKind()
+	dst.SetGroupVersionKind(schema.GroupVersionKind{Group: gvk.Group, Version: v1beta1.Version, Kind: gvk.Kind})
+	dst.ObjectMeta = p.ObjectMeta
+
+	// ShellCommandField is a reference to the field that contains the
+	// command, and is preferred over ShellCommand.
+	dst.Command.Inline = p.ShellCommand
+	if p.ShellCommandField != "" {
+		dst.Command.Inline = ""
+		dst.Command.FieldRef = &v1beta1.FieldRef{Path: p.ShellCommandField, Policy: v1beta1.FieldRefPolicyRequired}
+	}
+	if p.ShellCommand != "" && p.ShellCommandField != "" {
+		errs = append(errs, field.Required(root, "exactly one of ShellCommand or ShellCommandField is required"))
+	}
+	dst.Command.Timeout = p.Timeout
+	if p.Retry != nil {
+		dst.Command.Retry = &v1beta1.Retry{
+			Attempts:                p.Retry.Attempts,
+			InitialBackoff:          p.Retry.InitialBackoff,
+			MaxBackoff:              p.Retry.MaxBackoff,
+			RetryableExitCodes:      p.Retry.RetryableExitCodes,
+			RetryableStderrPatterns: p.Retry.RetryableStderrPatterns,
+		}
+	}
+	if p.Sandbox != nil {
+		dst.Command.Sandbox = &v1beta1.Sandbox{
+			Enabled:        p.Sandbox.Enabled,
+			IsolateNetwork: p.Sandbox.IsolateNetwork,
+		}
+	}
+	dst.Command.Network = v1beta1.Network(p.Network)
+
+	for i, ev := range p.ShellEnvVars {
+		v, err := convertShellEnvVar(root.Child("shellEnvVars").Index(i), ev)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		dst.Env.Vars = append(dst.Env.Vars, v)
+	}
+	// Keys of a shellEnvVarsRef that don't exist aren't an error, so they
+	// are optional keys of an envVarRef.
+	if len(p.ShellEnvVarsRef.Keys) > 0 {
+		dst.Env.From = []v1beta1.EnvFromSource{{
+			EnvVarRef: &v1beta1.EnvVarRef{Name: p.ShellEnvVarsRef.Name, OptionalKeys: p.ShellEnvVarsRef.Keys},
+		}}
+	}
+
+	for _, f := range p.Files {
+		dst.Files = append(dst.Files, v1beta1.File{
+			Path:          f.Path,
+			Mode:          f.Mode,
+			Value:         f.Value,
+			FieldRef:      convertFieldRef(f.FieldRef),
+			ContextKey:    f.ContextKey,
+			CredentialRef: (*v1beta1.CredentialRef)(f.CredentialRef),
+			Type:          v1beta1.FileType(f.Type),
+		})
+	}
+
+	dst.Outputs.Stdout = v1beta1.Stdout{Field: p.StdoutField, Format: v1beta1.OutputFormat(p.StdoutFormat)}
+	dst.Outputs.Stderr = v1beta1.Stderr{Field: p.StderrField}
+	dst.Outputs.MaxSize = p.MaxOutputSize
+	for _, f := range p.OutputFiles {
+		dst.Outputs.Files = append(dst.Outputs.Files, v1beta1.OutputFile{
+			Path:             f.Path,
+			Format:           v1beta1.OutputFormat(f.Format),
+			Optional:         f.Optional,
+			Field:            f.Field,
+			ContextKey:       f.ContextKey,
+			ConnectionDetail: f.ConnectionDetail,
+		})
+	}
+	for _, cd := range p.ConnectionDetails {
+		dst.Outputs.ConnectionDetails = append(dst.Outputs.ConnectionDetails, v1beta1.ConnectionDetail(cd))
+	}
+
+	dst.CacheTTL = p.CacheTTL
+
+	return errs
+}
+
+// convertShellEnvVar converts a ShellEnvVar to a v1beta1 EnvVar, or returns
+// the problem that prevents it.
+func convertShellEnvVar(fp *field.Path, ev ShellEnvVar) (v1beta1.EnvVar, *field.Error) {
+	v := v1beta1.EnvVar{Name: ev.Key}
+	switch t := ev.GetType(); t {
+	case ShellEnvVarTypeValue:
+		v.Value = ev.Value
+	case ShellEnvVarTypeValueRef:
+		// A valueRef behaves like a fieldRef with a Required policy.
+		if ev.ValueRef == "" {
+			return v, field.Required(fp.Child("valueRef"), "valueRef is required for type ValueRef")
+		}
+		v.FieldRef = &v1beta1.FieldRef{Path: ev.ValueRef, Policy: v1beta1.FieldRefPolicyRequired}
+	case ShellEnvVarTypeFieldRef:
+		if ev.FieldRef == nil {
+			return v, field.Required(fp.Child("fieldRef"), "fieldRef is required for type FieldRef")
+		}
+		v.FieldRef = convertFieldRef(ev.FieldRef)
+	case "":
+		return v, field.Required(fp, "one of value, valueRef or fieldRef is required")
+	default:
+		return v, field.NotSupported(fp.Child("type"), t, []ShellEnvVarType{ShellEnvVarTypeValue, ShellEnvVarTypeValueRef, ShellEnvVarTypeFieldRef})
+	}
+	return v, nil
+}
+
+func convertFieldRef(ref *FieldRef) *v1beta1.FieldRef {
+	if ref == nil {
+		return nil
+	}
+	return &v1beta1.FieldRef{
+		Path:         ref.Path,
+		Policy:       v1beta1.FieldRefPolicy(ref.Policy),
+		DefaultValue: ref.DefaultValue,
+	}
+}
+
+// convertedFields are the paths of v1beta1 fields, relative to the
+// parameters, and the v1alpha1 fields they are converted from. Paths of
+// fields that aren't listed are the same in both versions.
+var convertedFields = []struct{ v1beta1, v1alpha1 string }{
+	{"command.timeout", "timeout"},
+	{"command.retry", "retry"},
+	{"command.sandbox", "sandbox"},
+	{"command.network", "network"},
+	{"env.from[0].envVarRef.optionalKeys", "shellEnvVarsRef.keys"},
+	{"env.from[0].envVarRef", "shellEnvVarsRef"},
+	{"env.from[0]", "shellEnvVarsRef"},
+	{"outputs.stdout.field", "stdoutField"},
+	{"outputs.stdout.format", "stdoutFormat"},
+	{"outputs.stderr.field", "stderrField"},
+	{"outputs.maxSize", "maxOutputSize"},
+	{"outputs.files", "outputFiles"},
+	{"outputs.connectionDetails", "connectionDetails"},
+}
+
+// envVarPathRegex matches the path of a v1beta1 env var, relative to the
+// parameters.
+var envVarPathRegex = regexp.MustCompile(`^env\.vars\[(\d+)](.*)$`)
+
+// ConvertFieldErrors returns the supplied problems with the v1beta1
+// Parameters that p was converted to, at the fields of p they were converted
+// from, so that they can be found in the input the user wrote.
+func (p *Parameters) ConvertFieldErrors(errs field.ErrorList) field.ErrorList {
+	// The ShellEnvVars that could not be converted have no v1beta1 env var.
+	var envVars []int
+	for i, ev := range p.ShellEnvVars {
+		if _, err := convertShellEnvVar(nil, ev); err == nil {
+			envVars = append(envVars, i)
+		}
+	}
+
+	out := make(field.ErrorList, len(errs))
+	for i, err := range errs {
+		e := *err
+		e.Field = p.convertFieldPath(e.Field, envVars)
+		if err.Field == "parameters.command.inline" && e.Type == field.ErrorTypeRequired {
+			e.Detail = "one of shellCommand or shellCommandField is required"
+		}
+		out[i] = &e
+	}
+	return out
+}
+
+// convertFieldPath returns the path of the v1alpha1 field the v1beta1 field
+// at path was converted from. envVars are the indexes of the ShellEnvVars
+// the v1beta1 env vars were converted from.
+func (p *Parameters) convertFieldPath(path string, envVars []int) string {
+	const root = "parameters."
+	rest, ok := strings.CutPrefix(path, root)
+	if !ok {
+		return path
+	}
+
+	command := "shellCommand"
+	if p.ShellCommand == "" && p.ShellCommandField != "" {
+		command = "shellCommandField"
+	}
+	switch {
+	case hasFieldPrefix(rest, "command.inline"):
+		return root + command
+	case hasFieldPrefix(rest, "command.fieldRef"):
+		// The shellCommandField is the path of the fieldRef.
+		return root + "shellCommandField"
+	}
+
+	if m := envVarPathRegex.FindStringSubmatch(rest); m != nil {
+		j, err := strconv.Atoi(m[1])
+		if err != nil || j >= len(envVars) {
+			return path
+		}
+		i, suffix := envVars[j], m[2]
+		switch {
+		case suffix == ".name":
+			suffix = ".key"
+		case hasFieldPrefix(suffix, ".fieldRef") && p.ShellEnvVars[i].GetType() == ShellEnvVarTypeValueRef:
+			// The valueRef is the path of the fieldRef.
+			suffix = ".valueRef"
+		}
+		return root + "shellEnvVars[" + strconv.Itoa(i) + "]" + suffix
+	}
+
+	for _, f := range convertedFields {
+		if hasFieldPrefix(rest, f.v1beta1) {
+			return root + f.v1alpha1 + strings.TrimPrefix(rest, f.v1beta1)
+		}
+	}
+	return path
+}
+
+// hasFieldPrefix returns true if path is the field prefix, or a field within
+// it.
+func hasFieldPrefix(path, prefix string) bool {
+	rest, ok := strings.CutPrefix(path, prefix)
+	return ok && (rest == "" || rest[0] == '.' || rest[0] == '[')
+}
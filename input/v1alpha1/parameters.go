@@ -13,7 +13,6 @@ import (
 
 // Parameters can be used to provide input to this Function.
 // +kubebuilder:object:root=true
-// +kubebuilder:storageversion
 // +kubebuilder:resource:categories=crossplane
 type Parameters struct {
 	metav1.TypeMeta   `json:",inline"`
@@ -31,7 +30,10 @@ type Parameters struct {
 	// +optional
 	ShellCommand string `json:"shellCommand"`
 
-	// shellCmdField
+	// shellCmdField is the path of a field that contains the shell command,
+	// like a fieldRef. Earlier releases ran its value as the command
+	// itself, which the --literal-shell-command-field flag of the function
+	// restores.
 	// +optional
 	ShellCommandField string `json:"shellCommandField,omitempty"`
 
@@ -43,12 +45,263 @@ type Parameters struct {
 	// +optional
 	StderrField string `json:"stderrField,omitempty"`
 
+	// StdoutFormat is the format stdout is parsed as before it is written
+	// to StdoutField: Text, JSON or YAML. JSON and YAML are written as
+	// structured values. The stdout of a failed shell command is always
+	// written as Text.
+	// +optional
+	// +kubebuilder:default:=Text
+	// +kubebuilder:validation:Enum=Text;JSON;YAML
+	StdoutFormat OutputFormat `json:"stdoutFormat,omitempty"`
+
+	// MaxOutputSize is the maximum size in bytes of stdout, and of every
+	// output file. Larger outputs are a fatal error.
+	// +optional
+	// +kubebuilder:default:=1048576
+	// +kubebuilder:validation:Minimum=1
+	MaxOutputSize int64 `json:"maxOutputSize,omitempty"`
+
 	// TTL for response cache. Function Response caching is an
 	// alpha feature in Crossplane can be deprecated or changed
 	// in the future.
 	// +optional
 	// +kubebuilder:default:="1m"
 	CacheTTL string `json:"cacheTTL,omitempty"`
+
+	// Timeout for running the shell command, including all retries,
+	// using a time duration like 30s or 2m. Defaults to the deadline of
+	// the function call.
+	// +optional
+	Timeout string `json:"timeout,omitempty"`
+
+	// Retry re-runs the shell command when it fails transiently.
+	// +optional
+	Retry *Retry `json:"retry,omitempty"`
+
+	// Sandbox runs the shell command isolated from the function container.
+	// +optional
+	Sandbox *Sandbox `json:"sandbox,omitempty"`
+
+	// Network the shell command can reach. None runs the shell command in
+	// a new network namespace, in which only the loopback interface is
+	// available. Inherit uses the network of the function. Defaults to the
+	// default network of the function server, which is Inherit unless
+	// configured otherwise.
+	// +optional
+	// +kubebuilder:validation:Enum=None;Inherit
+	Network Network `json:"network,omitempty"`
+
+	// Files are written to the working directory of the shell command
+	// before it runs. Every invocation gets a new, empty working directory
+	// that is removed after the shell command exits.
+	// +optional
+	Files []File `json:"files,omitempty"`
+
+	// OutputFiles are read from the working directory of the shell command
+	// after it succeeds, and written to composite fields, context keys or
+	// connection details.
+	// +optional
+	OutputFiles []OutputFile `json:"outputFiles,omitempty"`
+
+	// ConnectionDetails are taken from the parsed stdout or output files of
+	// the shell command after it succeeds, and written to the connection
+	// secret of the composite resource. Stdout that connection details are
+	// taken from isn't written to StdoutField.
+	// +optional
+	ConnectionDetails []ConnectionDetail `json:"connectionDetails,omitempty"`
+}
+
+// ConnectionDetail is a composite connection detail taken from the output
+// of a shell command, like a token or an endpoint.
+type ConnectionDetail struct {
+	// Name of the connection detail.
+	Name string `json:"name"`
+	// OutputFile is the path of the output file the connection detail is
+	// taken from. It must be the path of one of OutputFiles. The
+	// connection detail is taken from stdout if OutputFile isn't set.
+	// +optional
+	OutputFile string `json:"outputFile,omitempty"`
+	// FieldPath of the value in the parsed JSON or YAML output, like
+	// credentials.token. The whole output is used if FieldPath isn't set.
+	// Values that aren't strings are written as JSON.
+	// +optional
+	FieldPath string `json:"fieldPath,omitempty"`
+}
+
+// OutputFormat is the format of the output of a shell command.
+type OutputFormat string
+
+const (
+	// OutputFormatText is plain text, with leading and trailing white
+	// space removed.
+	OutputFormatText OutputFormat = "Text"
+	// OutputFormatJSON is a JSON document.
+	OutputFormatJSON OutputFormat = "JSON"
+	// OutputFormatYAML is a YAML document.
+	OutputFormatYAML OutputFormat = "YAML"
+)
+
+// OutputFile is a file the shell command writes to its working directory,
+// like the output of terraform output -json. At least one of Field,
+// ContextKey or ConnectionDetail must be set, unless ConnectionDetails take
+// values from the file.
+type OutputFile struct {
+	// Path of the file, relative to the working directory.
+	Path string `json:"path"`
+	// Format the file is parsed as: Text, JSON or YAML.
+	// +optional
+	// +kubebuilder:default:=Text
+	// +kubebuilder:validation:Enum=Text;JSON;YAML
+	Format OutputFormat `json:"format,omitempty"`
+	// Optional files that the shell command didn't write are skipped.
+	// Missing files are otherwise a fatal error.
+	// +optional
+	Optional bool `json:"optional,omitempty"`
+	// Field is the path of the composite field the parsed file is written
+	// to, like status.outputs.
+	// +optional
+	Field string `json:"field,omitempty"`
+	// ContextKey is the key of the pipeline context the parsed file is
+	// written to.
+	// +optional
+	ContextKey string `json:"contextKey,omitempty"`
+	// ConnectionDetail is the key of the composite connection detail the
+	// file is written to. Parsed values that aren't strings are written as
+	// JSON.
+	// +optional
+	ConnectionDetail string `json:"connectionDetail,omitempty"`
+}
+
+// FileType is a type of File.
+type FileType string
+
+const (
+	// FileTypeValue populates the file from a string.
+	FileTypeValue FileType = "Value"
+	// FileTypeFieldRef populates the file from a field in the Composition.
+	FileTypeFieldRef FileType = "FieldRef"
+	// FileTypeContextKey populates the file from a key of the pipeline
+	// context.
+	FileTypeContextKey FileType = "ContextKey"
+	// FileTypeCredential populates the file from a key of a credential
+	// supplied to the function.
+	FileTypeCredential FileType = "Credential"
+)
+
+// File is a file written to the working directory of the shell command, like
+// a kubeconfig or a JSON document.
+type File struct {
+	// Path of the file, relative to the working directory.
+	Path string `json:"path"`
+	// Mode of the file in octal notation.
+	// +optional
+	// +kubebuilder:default:="0600"
+	Mode string `json:"mode,omitempty"`
+	// Value is the literal content of the file.
+	// +optional
+	Value string `json:"value,omitempty"`
+	// FieldRef is a reference to a field in the Composition whose value
+	// is the content of the file.
+	// +optional
+	FieldRef *FieldRef `json:"fieldRef,omitempty"`
+	// ContextKey is a key of the pipeline context whose value is the
+	// content of the file. Values that aren't strings are written as JSON.
+	// +optional
+	ContextKey string `json:"contextKey,omitempty"`
+	// CredentialRef is a key of a credential supplied to the function
+	// whose value is the content of the file.
+	// +optional
+	CredentialRef *CredentialRef `json:"credentialRef,omitempty"`
+	// Type is the type of File: Value, FieldRef, ContextKey or Credential.
+	// +optional
+	// +kubebuilder:validation:Enum=Value;FieldRef;ContextKey;Credential
+	Type FileType `json:"type,omitempty"`
+}
+
+// GetType determines the File type.
+func (f *File) GetType() FileType {
+	if f.Type == "" {
+		if f.Value != "" {
+			return FileTypeValue
+		}
+		if f.FieldRef != nil {
+			return FileTypeFieldRef
+		}
+		if f.ContextKey != "" {
+			return FileTypeContextKey
+		}
+		if f.CredentialRef != nil {
+			return FileTypeCredential
+		}
+	}
+	return f.Type
+}
+
+// CredentialRef refers to a key of a credential supplied to the function.
+type CredentialRef struct {
+	// Name of the credential, as named in the pipeline step.
+	Name string `json:"name"`
+	// Key of the credential data.
+	Key string `json:"key"`
+}
+
+// Network is the network a shell command can reach.
+type Network string
+
+const (
+	// NetworkNone isolates the shell command from any network.
+	NetworkNone Network = "None"
+	// NetworkInherit uses the network of the function.
+	NetworkInherit Network = "Inherit"
+)
+
+// Sandbox configures running a shell command in fresh Linux namespaces.
+type Sandbox struct {
+	// Enabled runs the shell command in new user, mount and PID
+	// namespaces, with a read-only root filesystem and a private tmpfs
+	// mounted at /tmp.
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// IsolateNetwork runs the shell command in a new network namespace,
+	// in which only the loopback interface is available. It is equivalent
+	// to setting Network to None.
+	// +optional
+	IsolateNetwork bool `json:"isolateNetwork,omitempty"`
+}
+
+// Retry configures re-running a failed shell command with exponential
+// backoff.
+type Retry struct {
+	// Attempts is the maximum number of times the shell command is run,
+	// including the first attempt.
+	// +optional
+	// +kubebuilder:default:=3
+	// +kubebuilder:validation:Minimum=1
+	Attempts int `json:"attempts,omitempty"`
+
+	// InitialBackoff is the time to wait before the first retry. The
+	// backoff doubles after every retry.
+	// +optional
+	// +kubebuilder:default:="1s"
+	InitialBackoff string `json:"initialBackoff,omitempty"`
+
+	// MaxBackoff caps the time to wait between retries.
+	// +optional
+	// +kubebuilder:default:="30s"
+	MaxBackoff string `json:"maxBackoff,omitempty"`
+
+	// RetryableExitCodes are the exit codes that cause the shell command
+	// to be retried. If neither RetryableExitCodes nor
+	// RetryableStderrPatterns are set, any non-zero exit code is retried,
+	// except 125, the exit code of a sandbox that could not be set up.
+	// +optional
+	RetryableExitCodes []int `json:"retryableExitCodes,omitempty"`
+
+	// RetryableStderrPatterns are regular expressions matched against the
+	// stderr of a failed shell command. A match causes a retry.
+	// +optional
+	RetryableStderrPatterns []string `json:"retryableStderrPatterns,omitempty"`
 }
 
 // ShellEnvVarType is a type of ShellEnvVar.
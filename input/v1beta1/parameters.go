@@ -0,0 +1,725 @@
+// Package v1beta1 contains the input type for this Function
+// +kubebuilder:object:generate=true
+// +groupName=template.fn.crossplane.io
+// +versionName=v1beta1
+package v1beta1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Version of this input API.
+const Version = "v1beta1"
+
+// This isn't a custom resource, in the sense that we never install its CRD.
+// It is a KRM-like object, so we generate a CRD to describe its schema.
+
+// Parameters can be used to provide input to this Function.
+// +kubebuilder:object:root=true
+// +kubebuilder:storageversion
+// +kubebuilder:resource:categories=crossplane
+type Parameters struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	// When is the condition the shell command runs on. The shell command
+	// always runs if not set. If the condition is false the shell command
+	// is skipped, and the observed values of its output fields are kept.
+	// +optional
+	When *When `json:"when,omitempty"`
+
+	// Command is the shell command to run, and how to run it.
+	Command Command `json:"command"`
+
+	// Env is the environment of the shell command.
+	// +optional
+	Env Env `json:"env,omitempty"`
+
+	// Files are written to the working directory of the shell command
+	// before it runs. Every invocation gets a new, empty working directory
+	// that is removed after the shell command exits.
+	// +optional
+	Files []File `json:"files,omitempty"`
+
+	// Outputs configures where the output of the shell command is written.
+	// +optional
+	Outputs Outputs `json:"outputs,omitempty"`
+
+	// OutputTransform replaces the parsed outputs of the shell command with
+	// the values of CEL expressions before they're written.
+	// +optional
+	OutputTransform *OutputTransform `json:"outputTransform,omitempty"`
+
+	// TTL for response cache. Function Response caching is an
+	// alpha feature in Crossplane can be deprecated or changed
+	// in the future.
+	// +optional
+	// +kubebuilder:default:="1m"
+	CacheTTL string `json:"cacheTTL,omitempty"`
+}
+
+// When is a condition on the observed composite resource, the pipeline
+// context and the observed composed resources. It's true if every field
+// condition and the CEL expression are true.
+type When struct {
+	// Fields are conditions on fields.
+	// +optional
+	Fields []WhenField `json:"fields,omitempty"`
+
+	// CEL is a CEL expression that must evaluate to true or false. The
+	// variable xr is the observed composite resource, context is the
+	// pipeline context, and resources are the observed composed resources
+	// by name.
+	// +optional
+	CEL string `json:"cel,omitempty"`
+}
+
+// WhenField is a condition on a field. It's true if every one of exists,
+// equals and matches that is set is true. A field condition with none of
+// them set is true if the field exists.
+type WhenField struct {
+	// Path of the field, like the path of a FieldRef.
+	Path string `json:"path"`
+
+	// Exists is true if the field must exist, and false if it must not.
+	// +optional
+	Exists *bool `json:"exists,omitempty"`
+
+	// Equals is the value the field must have, compared to the value
+	// written in the Raw format of a FieldRef.
+	// +optional
+	Equals *string `json:"equals,omitempty"`
+
+	// Matches is a regular expression the value of the field must match,
+	// written in the Raw format of a FieldRef.
+	// +optional
+	Matches string `json:"matches,omitempty"`
+}
+
+// Command is a shell command, run with /bin/sh -c.
+type Command struct {
+	// Inline is the shell command line, like echo hello | tr a-z A-Z.
+	// Exactly one of inline or fieldRef is required.
+	// +optional
+	Inline string `json:"inline,omitempty"`
+
+	// FieldRef reads the shell command line from a field, like a field of
+	// the composite resource or a key of the pipeline context.
+	// +optional
+	FieldRef *FieldRef `json:"fieldRef,omitempty"`
+
+	// Timeout for running the shell command, including all retries,
+	// using a time duration like 30s or 2m. Defaults to the deadline of
+	// the function call.
+	// +optional
+	Timeout string `json:"timeout,omitempty"`
+
+	// Retry re-runs the shell command when it fails transiently.
+	// +optional
+	Retry *Retry `json:"retry,omitempty"`
+
+	// Sandbox runs the shell command isolated from the function container.
+	// +optional
+	Sandbox *Sandbox `json:"sandbox,omitempty"`
+
+	// Network the shell command can reach. None runs the shell command in
+	// a new network namespace, in which only the loopback interface is
+	// available. Inherit uses the network of the function. Defaults to the
+	// default network of the function server, which is Inherit unless
+	// configured otherwise.
+	// +optional
+	// +kubebuilder:validation:Enum=None;Inherit
+	Network Network `json:"network,omitempty"`
+}
+
+// Env is the environment of a shell command.
+type Env struct {
+	// Vars are environment variables of the shell command.
+	// +optional
+	Vars []EnvVar `json:"vars,omitempty"`
+
+	// From imports environment variables in bulk. Every source overrides
+	// Vars and earlier sources of the same name. Keys of one source that
+	// map to the same name are an error.
+	// +optional
+	From []EnvFromSource `json:"from,omitempty"`
+}
+
+// EnvVar is an environment variable of a shell command. Its value is either
+// Value, or the value of the field FieldRef refers to.
+type EnvVar struct {
+	// Name of the environment variable, like API_KEY.
+	Name string `json:"name"`
+	// Value is a fixed value, like http://api.example.com.
+	// +optional
+	Value string `json:"value,omitempty"`
+	// FieldRef is a reference to a field in the Composition.
+	// +optional
+	FieldRef *FieldRef `json:"fieldRef,omitempty"`
+}
+
+// EnvFromSource is a source of many environment variables. Exactly one of its
+// fields must be set.
+type EnvFromSource struct {
+	// EnvVarRef imports keys of an object that is the value of an
+	// environment variable of the function, like a secret loaded into the
+	// function pod.
+	// +optional
+	EnvVarRef *EnvVarRef `json:"envVarRef,omitempty"`
+
+	// FieldRef imports every field of an object, like a subtree of the
+	// composite resource, as an environment variable of the shell command.
+	// +optional
+	FieldRef *EnvFromFieldRef `json:"fieldRef,omitempty"`
+
+	// FileRef imports keys of a file or directory in the function pod, like
+	// a secret mounted as a volume. It's read on every call, so rotated
+	// secrets are used without restarting the function.
+	// +optional
+	FileRef *EnvFileRef `json:"fileRef,omitempty"`
+}
+
+// EnvVarRef refers to an environment variable of the function whose value is
+// an object in JSON, YAML or Dotenv format. Values that aren't strings are
+// imported too, numbers in decimal notation and objects and lists as JSON.
+type EnvVarRef struct {
+	// Name of the environment variable of the function.
+	Name string `json:"name"`
+
+	// Format of the value of the environment variable.
+	// +optional
+	// +kubebuilder:default:=JSON
+	// +kubebuilder:validation:Enum=JSON;YAML;Dotenv
+	Format EnvFormat `json:"format,omitempty"`
+
+	// Keys to import. Each key is the name of an environment variable of
+	// the shell command. A key that doesn't exist is an error. The key *
+	// imports every key.
+	// +optional
+	Keys []string `json:"keys,omitempty"`
+
+	// OptionalKeys to import if they exist.
+	// +optional
+	OptionalKeys []string `json:"optionalKeys,omitempty"`
+}
+
+// EnvFormat is the format of a set of environment variables.
+type EnvFormat string
+
+// Formats of a set of environment variables.
+const (
+	// EnvFormatJSON is a JSON object.
+	EnvFormatJSON EnvFormat = "JSON"
+	// EnvFormatYAML is a YAML object.
+	EnvFormatYAML EnvFormat = "YAML"
+	// EnvFormatDotenv is a file of KEY=value lines.
+	EnvFormatDotenv EnvFormat = "Dotenv"
+)
+
+// EnvFileRef imports keys of a file or directory in the function pod. Each
+// file of a directory is a key, named after the file, like the files of a
+// Kubernetes secret volume.
+type EnvFileRef struct {
+	// Path of the file or directory in the function pod, like
+	// /var/run/secrets/datadog.
+	Path string `json:"path"`
+
+	// Format of the file. Defaults to JSON for files ending in .json, YAML
+	// for files ending in .yaml or .yml, and Dotenv for other files. Not
+	// used for directories.
+	// +optional
+	// +kubebuilder:validation:Enum=JSON;YAML;Dotenv
+	Format EnvFormat `json:"format,omitempty"`
+
+	// Keys to import. Each key is the name of an environment variable of
+	// the shell command. A key that doesn't exist is an error. The key *
+	// imports every key. Imports every key if neither keys nor
+	// optionalKeys are set.
+	// +optional
+	Keys []string `json:"keys,omitempty"`
+
+	// OptionalKeys to import if they exist.
+	// +optional
+	OptionalKeys []string `json:"optionalKeys,omitempty"`
+}
+
+// EnvNameCase is how the keys of an imported object are written in the names
+// of environment variables.
+type EnvNameCase string
+
+// Cases of the names of imported environment variables.
+const (
+	// EnvNameCaseUpperSnake splits camelCase keys into words, and writes
+	// them in upper case separated by underscores, like INSTANCE_TYPE.
+	EnvNameCaseUpperSnake EnvNameCase = "UpperSnake"
+	// EnvNameCaseUpper writes keys in upper case, like INSTANCETYPE.
+	EnvNameCaseUpper EnvNameCase = "Upper"
+	// EnvNameCasePreserve writes keys as is, like instanceType.
+	EnvNameCasePreserve EnvNameCase = "Preserve"
+)
+
+// EnvFromFieldRef imports every field of an object as an environment
+// variable. Nested objects are flattened, joining the keys of each level
+// with an underscore. Lists and other values are formatted like a FieldRef.
+type EnvFromFieldRef struct {
+	// Path of the object to import. Like the path of a FieldRef, it may
+	// refer to a field of the observed composite resource like spec, a key
+	// of the pipeline context like context[key] or context[key].path, or an
+	// observed composed resource like resources[name].status.atProvider.
+	Path string `json:"path"`
+
+	// Prefix of the name of each environment variable, like XR_.
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+
+	// NameCase is how keys are written in the names of environment
+	// variables.
+	// +optional
+	// +kubebuilder:default:=UpperSnake
+	// +kubebuilder:validation:Enum=UpperSnake;Upper;Preserve
+	NameCase EnvNameCase `json:"nameCase,omitempty"`
+
+	// Policy of the object. A required object that doesn't exist is an
+	// error, and an optional one imports nothing.
+	// +optional
+	// +kubebuilder:default:=Required
+	// +kubebuilder:validation:Enum=Optional;Required
+	Policy FieldRefPolicy `json:"policy,omitempty"`
+
+	// Format of the value of each environment variable.
+	// +optional
+	// +kubebuilder:default:=Raw
+	// +kubebuilder:validation:Enum=Raw;JSON;YAML;Base64;CSV
+	Format FieldRefFormat `json:"format,omitempty"`
+}
+
+// FieldRefPolicy is a field path Policy.
+type FieldRefPolicy string
+
+const (
+	// FieldRefPolicyOptional uses the DefaultValue of the FieldRef if the
+	// field is not available.
+	FieldRefPolicyOptional FieldRefPolicy = "Optional"
+	// FieldRefPolicyRequired returns an error if the field is not
+	// available.
+	FieldRefPolicyRequired FieldRefPolicy = "Required"
+)
+
+// FieldRef refers to a composite field like spec.region, or to a field of a
+// key of the pipeline context like context[example.org/env].region.
+type FieldRef struct {
+	// Path is the field path of the field being referenced, i.e. spec.myfield, status.output
+	Path string `json:"path"`
+	// Policy when the field is not available. If set to "Required" will return
+	// an error if a field is missing. If set to "Optional" will return DefaultValue.
+	// +optional
+	// +kubebuilder:default:=Required
+	// +kubebuilder:validation:Enum=Optional;Required
+	Policy FieldRefPolicy `json:"policy,omitempty"`
+	// DefaultValue when Policy is Optional and field is not available defaults to ""
+	// +optional
+	DefaultValue string `json:"defaultValue,omitempty"`
+	// Format the value of the field is written in. Raw writes strings as
+	// is, numbers in decimal notation, and objects and lists as JSON. The
+	// DefaultValue is always written as is.
+	// +optional
+	// +kubebuilder:default:=Raw
+	// +kubebuilder:validation:Enum=Raw;JSON;YAML;Base64;CSV
+	Format FieldRefFormat `json:"format,omitempty"`
+}
+
+// FieldRefFormat is the format the value of a FieldRef is written in.
+type FieldRefFormat string
+
+const (
+	// FieldRefFormatRaw writes strings as is, numbers in decimal notation,
+	// and objects and lists as JSON.
+	FieldRefFormatRaw FieldRefFormat = "Raw"
+	// FieldRefFormatJSON writes the value as JSON. Strings are quoted.
+	FieldRefFormatJSON FieldRefFormat = "JSON"
+	// FieldRefFormatYAML writes the value as YAML.
+	FieldRefFormatYAML FieldRefFormat = "YAML"
+	// FieldRefFormatBase64 writes the Raw value encoded as standard base64.
+	FieldRefFormatBase64 FieldRefFormat = "Base64"
+	// FieldRefFormatCSV writes a list of values as one CSV record, and a
+	// list of lists as one record per list. Objects can't be written as
+	// CSV.
+	FieldRefFormatCSV FieldRefFormat = "CSV"
+)
+
+// FileType is a type of File.
+type FileType string
+
+const (
+	// FileTypeValue populates the file from a string.
+	FileTypeValue FileType = "Value"
+	// FileTypeFieldRef populates the file from a field in the Composition.
+	FileTypeFieldRef FileType = "FieldRef"
+	// FileTypeContextKey populates the file from a key of the pipeline
+	// context.
+	FileTypeContextKey FileType = "ContextKey"
+	// FileTypeCredential populates the file from a key of a credential
+	// supplied to the function.
+	FileTypeCredential FileType = "Credential"
+)
+
+// File is a file written to the working directory of the shell command, like
+// a kubeconfig or a JSON document.
+type File struct {
+	// Path of the file, relative to the working directory.
+	Path string `json:"path"`
+	// Mode of the file in octal notation.
+	// +optional
+	// +kubebuilder:default:="0600"
+	Mode string `json:"mode,omitempty"`
+	// Value is the literal content of the file.
+	// +optional
+	Value string `json:"value,omitempty"`
+	// FieldRef is a reference to a field in the Composition whose value
+	// is the content of the file.
+	// +optional
+	FieldRef *FieldRef `json:"fieldRef,omitempty"`
+	// ContextKey is a key of the pipeline context whose value is the
+	// content of the file. Values that aren't strings are written as JSON.
+	// +optional
+	ContextKey string `json:"contextKey,omitempty"`
+	// CredentialRef is a key of a credential supplied to the function
+	// whose value is the content of the file.
+	// +optional
+	CredentialRef *CredentialRef `json:"credentialRef,omitempty"`
+	// Type is the type of File: Value, FieldRef, ContextKey or Credential.
+	// +optional
+	// +kubebuilder:validation:Enum=Value;FieldRef;ContextKey;Credential
+	Type FileType `json:"type,omitempty"`
+}
+
+// GetType determines the File type.
+func (f *File) GetType() FileType {
+	if f.Type == "" {
+		if f.Value != "" {
+			return FileTypeValue
+		}
+		if f.FieldRef != nil {
+			return FileTypeFieldRef
+		}
+		if f.ContextKey != "" {
+			return FileTypeContextKey
+		}
+		if f.CredentialRef != nil {
+			return FileTypeCredential
+		}
+	}
+	return f.Type
+}
+
+// CredentialRef refers to a key of a credential supplied to the function.
+type CredentialRef struct {
+	// Name of the credential, as named in the pipeline step.
+	Name string `json:"name"`
+	// Key of the credential data.
+	Key string `json:"key"`
+}
+
+// Outputs configures where the output of a shell command is written.
+type Outputs struct {
+	// Stdout configures where stdout is written.
+	// +optional
+	Stdout Stdout `json:"stdout,omitempty"`
+
+	// Stderr configures where stderr is written.
+	// +optional
+	Stderr Stderr `json:"stderr,omitempty"`
+
+	// MaxSize is the maximum size in bytes of stdout, and of every output
+	// file. Larger outputs are a fatal error.
+	// +optional
+	// +kubebuilder:default:=1048576
+	// +kubebuilder:validation:Minimum=1
+	MaxSize int64 `json:"maxSize,omitempty"`
+
+	// Files are read from the working directory of the shell command
+	// after it succeeds, and written to composite fields, context keys or
+	// connection details.
+	// +optional
+	Files []OutputFile `json:"files,omitempty"`
+
+	// ConnectionDetails are taken from the parsed stdout or output files of
+	// the shell command after it succeeds, and written to the connection
+	// secret of the composite resource. Stdout that connection details are
+	// taken from isn't written to the stdout field.
+	// +optional
+	ConnectionDetails []ConnectionDetail `json:"connectionDetails,omitempty"`
+
+	// Targets write outputs of the shell command, or values in them, to
+	// composite fields, context keys, connection details, or fields of
+	// desired composed resources. Targets are written after the shell
+	// command runs, and aren't written if the observed outputs are kept.
+	// +optional
+	Targets []OutputTarget `json:"targets,omitempty"`
+
+	// OnFailure is what happens to the outputs of the shell command when it
+	// fails or times out. Overwrite writes the stdout and stderr of the
+	// failed shell command, and returns a fatal result. KeepPrevious keeps
+	// the observed values of the output fields and connection details, and
+	// returns a warning, so that a transient failure doesn't delete the last
+	// good value. Skipped shell commands always keep the observed values.
+	// +optional
+	// +kubebuilder:validation:Enum=Overwrite;KeepPrevious
+	// +kubebuilder:default:=Overwrite
+	OnFailure OnFailure `json:"onFailure,omitempty"`
+
+	// RefreshedField is the path of the composite field the time the
+	// outputs were last written is written to, like 2024-01-01T00:00:00Z.
+	// It's only written if OnFailure is KeepPrevious, and is kept like the
+	// output fields.
+	// +optional
+	// +kubebuilder:default:="status.atFunction.shell.lastRefreshed"
+	RefreshedField string `json:"refreshedField,omitempty"`
+}
+
+// OutputTarget writes an output of a shell command, or a value in it, to one
+// or more destinations.
+type OutputTarget struct {
+	// Source is the output the value is taken from: Stdout, Stderr,
+	// ExitCode or File. Stdout and files are parsed and transformed first.
+	// +optional
+	// +kubebuilder:validation:Enum=Stdout;Stderr;ExitCode;File
+	// +kubebuilder:default:=Stdout
+	Source OutputSource `json:"source,omitempty"`
+
+	// File is the path of the output file the value is taken from, if
+	// Source is File. It must be the path of one of the output files.
+	// Targets of optional files that don't exist, and of the files of a
+	// failed shell command, are skipped.
+	// +optional
+	File string `json:"file,omitempty"`
+
+	// FieldPath of the value in the parsed JSON or YAML output, like
+	// credentials.token. The whole output is used if FieldPath isn't set.
+	// +optional
+	FieldPath string `json:"fieldPath,omitempty"`
+
+	// Field is the path of the composite field the value is written to,
+	// or of a field of a desired composed resource like
+	// desiredResources[instance].spec.forProvider.imageId.
+	// +optional
+	Field string `json:"field,omitempty"`
+
+	// ContextKey is the key of the pipeline context the value is written
+	// to.
+	// +optional
+	ContextKey string `json:"contextKey,omitempty"`
+
+	// ConnectionDetail is the key of the composite connection detail the
+	// value is written to. Values that aren't strings are written as JSON.
+	// Stdout that connection details are taken from isn't written to the
+	// stdout field.
+	// +optional
+	ConnectionDetail string `json:"connectionDetail,omitempty"`
+}
+
+// OutputSource is an output of a shell command.
+type OutputSource string
+
+const (
+	// OutputSourceStdout is the parsed stdout.
+	OutputSourceStdout OutputSource = "Stdout"
+	// OutputSourceStderr is stderr.
+	OutputSourceStderr OutputSource = "Stderr"
+	// OutputSourceExitCode is the exit code.
+	OutputSourceExitCode OutputSource = "ExitCode"
+	// OutputSourceFile is a parsed output file.
+	OutputSourceFile OutputSource = "File"
+)
+
+// OutputTransform is a set of CEL expressions that replace the parsed outputs
+// of a shell command. Every expression can use the variables stdout, the
+// parsed stdout, stderr, exitCode, xr, the observed composite resource,
+// context, the pipeline context, and resources, the observed composed
+// resources by name.
+type OutputTransform struct {
+	// Stdout is a CEL expression whose value replaces the parsed stdout,
+	// before it's written to the stdout field and connection details.
+	// +optional
+	Stdout string `json:"stdout,omitempty"`
+
+	// Stderr is a CEL expression whose value replaces stderr, before it's
+	// written to the stderr field.
+	// +optional
+	Stderr string `json:"stderr,omitempty"`
+
+	// Files are CEL expressions whose values replace parsed output files.
+	// +optional
+	Files []OutputFileTransform `json:"files,omitempty"`
+}
+
+// OutputFileTransform is a CEL expression whose value replaces a parsed output
+// file, before it's written to the targets of the output file and connection
+// details. The variable file is the parsed output file.
+type OutputFileTransform struct {
+	// Path of the output file. It must be the path of one of the output
+	// files.
+	Path string `json:"path"`
+
+	// Expression is the CEL expression.
+	Expression string `json:"expression"`
+}
+
+// OnFailure is what happens to the outputs of a shell command that fails.
+type OnFailure string
+
+const (
+	// OnFailureOverwrite writes the outputs of the failed shell command.
+	OnFailureOverwrite OnFailure = "Overwrite"
+	// OnFailureKeepPrevious keeps the observed values of the outputs.
+	OnFailureKeepPrevious OnFailure = "KeepPrevious"
+)
+
+// Stdout configures where the stdout of a shell command is written.
+type Stdout struct {
+	// Field is the path of the composite field stdout is written to, or of
+	// a field of a desired composed resource like
+	// desiredResources[instance].spec.forProvider.imageId.
+	// +optional
+	// +kubebuilder:default:="status.atFunction.shell.stdout"
+	Field string `json:"field,omitempty"`
+
+	// Format stdout is parsed as before it is written to Field: Text,
+	// JSON or YAML. JSON and YAML are written as structured values. The
+	// stdout of a failed shell command is always written as Text.
+	// +optional
+	// +kubebuilder:default:=Text
+	// +kubebuilder:validation:Enum=Text;JSON;YAML
+	Format OutputFormat `json:"format,omitempty"`
+}
+
+// Stderr configures where the stderr of a shell command is written.
+type Stderr struct {
+	// Field is the path of the composite field stderr is written to, or of
+	// a field of a desired composed resource like
+	// desiredResources[instance].metadata.annotations[shell-stderr].
+	// +optional
+	// +kubebuilder:default:="status.atFunction.shell.stderr"
+	Field string `json:"field,omitempty"`
+}
+
+// OutputFormat is the format of the output of a shell command.
+type OutputFormat string
+
+const (
+	// OutputFormatText is plain text, with leading and trailing white
+	// space removed.
+	OutputFormatText OutputFormat = "Text"
+	// OutputFormatJSON is a JSON document.
+	OutputFormatJSON OutputFormat = "JSON"
+	// OutputFormatYAML is a YAML document.
+	OutputFormatYAML OutputFormat = "YAML"
+)
+
+// OutputFile is a file the shell command writes to its working directory,
+// like the output of terraform output -json. At least one of Field,
+// ContextKey or ConnectionDetail must be set, unless ConnectionDetails take
+// values from the file.
+type OutputFile struct {
+	// Path of the file, relative to the working directory.
+	Path string `json:"path"`
+	// Format the file is parsed as: Text, JSON or YAML.
+	// +optional
+	// +kubebuilder:default:=Text
+	// +kubebuilder:validation:Enum=Text;JSON;YAML
+	Format OutputFormat `json:"format,omitempty"`
+	// Optional files that the shell command didn't write are skipped.
+	// Missing files are otherwise a fatal error.
+	// +optional
+	Optional bool `json:"optional,omitempty"`
+	// Field is the path of the composite field the parsed file is written
+	// to, like status.outputs, or of a field of a desired composed resource
+	// like desiredResources[instance].spec.forProvider.tags.
+	// +optional
+	Field string `json:"field,omitempty"`
+	// ContextKey is the key of the pipeline context the parsed file is
+	// written to.
+	// +optional
+	ContextKey string `json:"contextKey,omitempty"`
+	// ConnectionDetail is the key of the composite connection detail the
+	// file is written to. Parsed values that aren't strings are written as
+	// JSON.
+	// +optional
+	ConnectionDetail string `json:"connectionDetail,omitempty"`
+}
+
+// ConnectionDetail is a composite connection detail taken from the output
+// of a shell command, like a token or an endpoint.
+type ConnectionDetail struct {
+	// Name of the connection detail.
+	Name string `json:"name"`
+	// OutputFile is the path of the output file the connection detail is
+	// taken from. It must be the path of one of the output files. The
+	// connection detail is taken from stdout if OutputFile isn't set.
+	// +optional
+	OutputFile string `json:"outputFile,omitempty"`
+	// FieldPath of the value in the parsed JSON or YAML output, like
+	// credentials.token. The whole output is used if FieldPath isn't set.
+	// Values that aren't strings are written as JSON.
+	// +optional
+	FieldPath string `json:"fieldPath,omitempty"`
+}
+
+// Network is the network a shell command can reach.
+type Network string
+
+const (
+	// NetworkNone isolates the shell command from any network.
+	NetworkNone Network = "None"
+	// NetworkInherit uses the network of the function.
+	NetworkInherit Network = "Inherit"
+)
+
+// Sandbox configures running a shell command in fresh Linux namespaces.
+type Sandbox struct {
+	// Enabled runs the shell command in new user, mount and PID
+	// namespaces, with a read-only root filesystem and a private tmpfs
+	// mounted at /tmp.
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// IsolateNetwork runs the shell command in a new network namespace,
+	// in which only the loopback interface is available. It is equivalent
+	// to setting Network to None.
+	// +optional
+	IsolateNetwork bool `json:"isolateNetwork,omitempty"`
+}
+
+// Retry configures re-running a failed shell command with exponential
+// backoff.
+type Retry struct {
+	// Attempts is the maximum number of times the shell command is run,
+	// including the first attempt.
+	// +optional
+	// +kubebuilder:default:=3
+	// +kubebuilder:validation:Minimum=1
+	Attempts int `json:"attempts,omitempty"`
+
+	// InitialBackoff is the time to wait before the first retry. The
+	// backoff doubles after every retry.
+	// +optional
+	// +kubebuilder:default:="1s"
+	InitialBackoff string `json:"initialBackoff,omitempty"`
+
+	// MaxBackoff caps the time to wait between retries.
+	// +optional
+	// +kubebuilder:default:="30s"
+	MaxBackoff string `json:"maxBackoff,omitempty"`
+
+	// RetryableExitCodes are the exit codes that cause the shell command
+	// to be retried. If neither RetryableExitCodes nor
+	// RetryableStderrPatterns are set, any non-zero exit code is retried,
+	// except 125, the exit code of a sandbox that could not be set up.
+	// +optional
+	RetryableExitCodes []int `json:"retryableExitCodes,omitempty"`
+
+	// RetryableStderrPatterns are regular expressions matched against the
+	// stderr of a failed shell command. A match causes a retry.
+	// +optional
+	RetryableStderrPatterns []string `json:"retryableStderrPatterns,omitempty"`
+}
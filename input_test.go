@@ -0,0 +1,252 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/crossplane-contrib/function-shell/input/v1beta1"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	"github.com/crossplane/function-sdk-go/resource"
+)
+
+func TestGetInput(t *testing.T) {
+	type want struct {
+		in   *v1beta1.Parameters
+		errs field.ErrorList
+		// unknown is true if fields of v1alpha1 input are ignored.
+		unknown bool
+		err     bool
+	}
+
+	cases := map[string]struct {
+		reason              string
+		input               string
+		literalCommandField bool
+		want                want
+	}{
+		"V1beta1": {
+			reason: "v1beta1 input should be decoded as is.",
+			input: `{
+				"apiVersion": "template.fn.crossplane.io/v1beta1",
+				"kind": "Parameters",
+				"command": {"inline": "echo $A", "timeout": "30s"},
+				"env": {"vars": [{"name": "A", "value": "a"}]},
+				"outputs": {"stdout": {"field": "status.out", "format": "JSON"}}
+			}`,
+			want: want{
+				in: &v1beta1.Parameters{
+					TypeMeta: metav1.TypeMeta{APIVersion: "template.fn.crossplane.io/v1beta1", Kind: "Parameters"},
+					Command:  v1beta1.Command{Inline: "echo $A", Timeout: "30s"},
+					Env:      v1beta1.Env{Vars: []v1beta1.EnvVar{{Name: "A", Value: "a"}}},
+					Outputs:  v1beta1.Outputs{Stdout: v1beta1.Stdout{Field: "status.out", Format: v1beta1.OutputFormatJSON}},
+				},
+			},
+		},
+		"V1alpha1": {
+			reason: "v1alpha1 input should be converted to v1beta1.",
+			input: `{
+				"apiVersion": "template.fn.crossplane.io/v1alpha1",
+				"kind": "Parameters",
+				"shellCommand": "echo $A $B $C",
+				"timeout": "30s",
+				"network": "None",
+				"retry": {"attempts": 2},
+				"shellEnvVars": [
+					{"key": "A", "value": "a"},
+					{"key": "B", "valueRef": "spec.b"},
+					{"key": "C", "fieldRef": {"path": "spec.c", "policy": "Optional", "defaultValue": "c"}}
+				],
+				"shellEnvVarsRef": {"name": "SECRETS", "keys": ["TOKEN"]},
+				"stdoutField": "status.out",
+				"stdoutFormat": "JSON",
+				"stderrField": "status.err",
+				"maxOutputSize": 1024,
+				"cacheTTL": "5m"
+			}`,
+			want: want{
+				in: &v1beta1.Parameters{
+					TypeMeta: metav1.TypeMeta{APIVersion: "template.fn.crossplane.io/v1beta1", Kind: "Parameters"},
+					Command: v1beta1.Command{
+						Inline:  "echo $A $B $C",
+						Timeout: "30s",
+						Retry:   &v1beta1.Retry{Attempts: 2},
+						Network: v1beta1.NetworkNone,
+					},
+					Env: v1beta1.Env{
+						Vars: []v1beta1.EnvVar{
+							{Name: "A", Value: "a"},
+							{Name: "B", FieldRef: &v1beta1.FieldRef{Path: "spec.b", Policy: v1beta1.FieldRefPolicyRequired}},
+							{Name: "C", FieldRef: &v1beta1.FieldRef{Path: "spec.c", Policy: v1beta1.FieldRefPolicyOptional, DefaultValue: "c"}},
+						},
+						From: []v1beta1.EnvFromSource{{EnvVarRef: &v1beta1.EnvVarRef{Name: "SECRETS", OptionalKeys: []string{"TOKEN"}}}},
+					},
+					Outputs: v1beta1.Outputs{
+						Stdout:  v1beta1.Stdout{Field: "status.out", Format: v1beta1.OutputFormatJSON},
+						Stderr:  v1beta1.Stderr{Field: "status.err"},
+						MaxSize: 1024,
+					},
+					CacheTTL: "5m",
+				},
+			},
+		},
+		"V1alpha1ShellCommandField": {
+			reason: "The shellCommandField of v1alpha1 input should be a reference to the command.",
+			input: `{
+				"apiVersion": "template.fn.crossplane.io/v1alpha1",
+				"kind": "Parameters",
+				"shellCommandField": "spec.command"
+			}`,
+			want: want{
+				in: &v1beta1.Parameters{
+					TypeMeta: metav1.TypeMeta{APIVersion: "template.fn.crossplane.io/v1beta1", Kind: "Parameters"},
+					Command:  v1beta1.Command{FieldRef: &v1beta1.FieldRef{Path: "spec.command", Policy: v1beta1.FieldRefPolicyRequired}},
+				},
+			},
+		},
+		"V1alpha1LiteralShellCommandField": {
+			reason: "The shellCommandField of v1alpha1 input should be the inline command if literalCommandField is true.",
+			input: `{
+				"apiVersion": "template.fn.crossplane.io/v1alpha1",
+				"kind": "Parameters",
+				"shellCommandField": "echo hello"
+			}`,
+			literalCommandField: true,
+			want: want{
+				in: &v1beta1.Parameters{
+					TypeMeta: metav1.TypeMeta{APIVersion: "template.fn.crossplane.io/v1beta1", Kind: "Parameters"},
+					Command:  v1beta1.Command{Inline: "echo hello"},
+				},
+			},
+		},
+		"V1beta1WithV1alpha1Fields": {
+			reason: "v1beta1 input with a shellCommand, like earlier examples, should be read as v1alpha1.",
+			input: `{
+				"apiVersion": "shell.fn.crossplane.io/v1beta1",
+				"kind": "Parameters",
+				"shellCommand": "echo hello",
+				"stdoutField": "status.out"
+			}`,
+			want: want{
+				in: &v1beta1.Parameters{
+					TypeMeta: metav1.TypeMeta{APIVersion: "shell.fn.crossplane.io/v1beta1", Kind: "Parameters"},
+					Command:  v1beta1.Command{Inline: "echo hello"},
+					Outputs:  v1beta1.Outputs{Stdout: v1beta1.Stdout{Field: "status.out"}},
+				},
+			},
+		},
+		"V1alpha1ConversionErrors": {
+			reason: "v1alpha1 fields that can't be converted should be reported, and left unset.",
+			input: `{
+				"apiVersion": "template.fn.crossplane.io/v1alpha1",
+				"kind": "Parameters",
+				"shellCommand": "echo hello",
+				"shellCommandField": "spec.command",
+				"shellEnvVars": [{"key": "A", "type": "Secret"}]
+			}`,
+			want: want{
+				in: &v1beta1.Parameters{
+					TypeMeta: metav1.TypeMeta{APIVersion: "template.fn.crossplane.io/v1beta1", Kind: "Parameters"},
+					Command:  v1beta1.Command{FieldRef: &v1beta1.FieldRef{Path: "spec.command", Policy: v1beta1.FieldRefPolicyRequired}},
+				},
+				errs: field.ErrorList{
+					field.Required(field.NewPath("parameters"), ""),
+					field.NotSupported(field.NewPath("parameters", "shellEnvVars").Index(0).Child("type"), "Secret", []string{}),
+				},
+			},
+		},
+		"V1alpha1UnknownField": {
+			reason: "Unknown fields of v1alpha1 input, like a description, should be ignored and reported rather than be an error, like earlier releases.",
+			input: `{
+				"apiVersion": "template.fn.crossplane.io/v1alpha1",
+				"kind": "Parameters",
+				"description": "Prints hello",
+				"shellCommand": "echo hello"
+			}`,
+			want: want{
+				in: &v1beta1.Parameters{
+					TypeMeta: metav1.TypeMeta{APIVersion: "template.fn.crossplane.io/v1beta1", Kind: "Parameters"},
+					Command:  v1beta1.Command{Inline: "echo hello"},
+				},
+				unknown: true,
+			},
+		},
+		"UnknownField": {
+			reason: "v1beta1 input with unknown fields should be an error.",
+			input: `{
+				"apiVersion": "template.fn.crossplane.io/v1beta1",
+				"kind": "Parameters",
+				"command": {"inline": "echo hello"},
+				"stdoutField": "status.out"
+			}`,
+			want: want{
+				err: true,
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			in, conv, err := getInput(resource.MustStructJSON(tc.input), tc.literalCommandField)
+			errs := conv.fieldErrors(nil)
+
+			if (err != nil) != tc.want.err {
+				t.Fatalf("%s\ngetInput(...): want error %t, got %v", tc.reason, tc.want.err, err)
+			}
+			if diff := cmp.Diff(tc.want.in, in, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("%s\ngetInput(...): -want, +got:\n%s", tc.reason, diff)
+			}
+			if unknown := conv.unknownFields() != nil; unknown != tc.want.unknown {
+				t.Errorf("%s\ngetInput(...): want unknown fields %t, got %v", tc.reason, tc.want.unknown, conv.unknownFields())
+			}
+			// Only compare the type and field of each error, not its details.
+			if diff := cmp.Diff(tc.want.errs, errs, cmpopts.EquateEmpty(), cmpopts.IgnoreFields(field.Error{}, "BadValue", "Detail", "Origin", "CoveredByDeclarative")); diff != "" {
+				t.Errorf("%s\ngetInput(...): -want errs, +got errs:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestConversionFieldErrors(t *testing.T) {
+	input := `{
+		"apiVersion": "template.fn.crossplane.io/v1alpha1",
+		"kind": "Parameters",
+		"shellCommandField": "spec.command[",
+		"shellEnvVars": [
+			{"key": "A", "type": "Secret"},
+			{"key": "1B", "valueRef": "spec.b["},
+			{"key": "C", "fieldRef": {"path": "spec.c", "policy": "Sometimes"}}
+		],
+		"shellEnvVarsRef": {"name": "SECRETS", "keys": ["1TOKEN"]},
+		"stdoutField": "status..out",
+		"outputFiles": [{"path": "../out.json", "field": "status.out"}],
+		"timeout": "soon",
+		"cacheTTL": "soon"
+	}`
+	in, conv, err := getInput(resource.MustStructJSON(input), false)
+	if err != nil {
+		t.Fatalf("getInput(...): %v", err)
+	}
+
+	want := []string{
+		"parameters.shellEnvVars[0].type",
+		"parameters.shellCommandField",
+		"parameters.cacheTTL",
+		"parameters.timeout",
+		"parameters.shellEnvVars[1].key",
+		"parameters.shellEnvVars[1].valueRef",
+		"parameters.shellEnvVars[2].fieldRef.policy",
+		"parameters.shellEnvVarsRef.keys[0]",
+		"parameters.stdoutField",
+		"parameters.outputFiles[0].path",
+	}
+	var got []string
+	for _, err := range conv.fieldErrors(ValidateParameters(in, nil, nil)) {
+		got = append(got, err.Field)
+	}
+	if diff := cmp.Diff(want, got, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+		t.Errorf("Problems with converted v1alpha1 input should be reported at the v1alpha1 fields.\nconv.fieldErrors(...): -want, +got:\n%s", diff)
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/crossplane-contrib/function-shell/input/v1beta1"
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	"google.golang.org/protobuf/types/known/structpb"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+
+	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/crossplane/function-sdk-go/resource/composite"
+)
+
+// inputGroups are the API groups of the Parameters input of this function.
+var inputGroups = []string{"template.fn.crossplane.io", "shell.fn.crossplane.io"}
+
+// LintCmd validates the function-shell inputs of Compositions.
+type LintCmd struct {
+	Paths []string `arg:"" help:"Composition YAML files, or directories to search for them." type:"path"`
+
+	FunctionName []string `default:"function-shell"                                                                                                      help:"Name of this function in the functionRef of pipeline steps. Steps whose input is a Parameters of this function are linted too."`
+	ScriptRoot   string   `help:"Directory that mirrors the filesystem of the function. Scripts that shell commands run by absolute path must exist in it." type:"existingdir"`
+	PolicyFile   string   `help:"Path to a YAML policy file to enforce, like the function server's --policy-file."                                         type:"existingfile"`
+
+	LiteralShellCommandField bool `help:"Lint the shellCommandField of v1alpha1 input as the shell command itself, like the function server's --literal-shell-command-field."`
+}
+
+// A lintProblem is a problem with the function-shell input of a step of a
+// Composition.
+type lintProblem struct {
+	file        string
+	composition string
+	step        string
+	err         error
+}
+
+func (p lintProblem) String() string {
+	if p.composition == "" {
+		return fmt.Sprintf("%s: %s", p.file, p.err)
+	}
+	return fmt.Sprintf("%s: composition %s: step %s: %s", p.file, p.composition, p.step, p.err)
+}
+
+// lintComposition is the part of a Composition the linter reads.
+type lintComposition struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name string `json:"name"`
+	} `json:"metadata"`
+	Spec struct {
+		CompositeTypeRef struct {
+			APIVersion string `json:"apiVersion"`
+			Kind       string `json:"kind"`
+		} `json:"compositeTypeRef"`
+		Pipeline []struct {
+			Step        string `json:"step"`
+			FunctionRef struct {
+				Name string `json:"name"`
+			} `json:"functionRef"`
+			Input json.RawMessage `json:"input,omitempty"`
+		} `json:"pipeline"`
+	} `json:"spec"`
+}
+
+// Run the linter, and print every problem found. It returns an error if any
+// were found.
+func (c *LintCmd) Run() error {
+	var pol *Policy
+	if c.PolicyFile != "" {
+		var err error
+		if pol, err = LoadPolicy(c.PolicyFile); err != nil {
+			return err
+		}
+	}
+
+	files, err := yamlFiles(c.Paths)
+	if err != nil {
+		return err
+	}
+
+	var problems []lintProblem
+	for _, f := range files {
+		p, err := c.lintFile(f, pol)
+		if err != nil {
+			return err
+		}
+		problems = append(problems, p...)
+	}
+
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		return errors.Errorf("found %d problems", len(problems))
+	}
+	return nil
+}
+
+// yamlFiles returns the supplied files, and the YAML files of the supplied
+// directories.
+func yamlFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, p := range paths {
+		err := filepath.WalkDir(p, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			if path == p || strings.HasSuffix(path, ".yaml") || strings.HasSuffix(path, ".yml") {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot read %s", p)
+		}
+	}
+	return files, nil
+}
+
+// lintFile lints every function-shell step of every Composition in a stream of
+// YAML documents.
+func (c *LintCmd) lintFile(filename string, pol *Policy) ([]lintProblem, error) {
+	b, err := os.ReadFile(filename) //nolint:gosec // The file is supplied by the user.
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read %s", filename)
+	}
+
+	var problems []lintProblem
+	r := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(b)))
+	for {
+		doc, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return problems, nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot read %s", filename)
+		}
+
+		comp := &lintComposition{}
+		if err := yaml.Unmarshal(doc, comp); err != nil {
+			problems = append(problems, lintProblem{file: filename, err: errors.Wrap(err, "cannot parse YAML")})
+			continue
+		}
+		gv, _ := schema.ParseGroupVersion(comp.APIVersion)
+		if gv.Group != "apiextensions.crossplane.io" || comp.Kind != "Composition" {
+			continue
+		}
+
+		oxr := &resource.Composite{Resource: composite.New()}
+		oxr.Resource.SetAPIVersion(comp.Spec.CompositeTypeRef.APIVersion)
+		oxr.Resource.SetKind(comp.Spec.CompositeTypeRef.Kind)
+
+		for _, s := range comp.Spec.Pipeline {
+			if !c.isShellStep(s.FunctionRef.Name, s.Input) {
+				continue
+			}
+			for _, err := range c.lintInput(s.Input, oxr, pol) {
+				problems = append(problems, lintProblem{file: filename, composition: comp.Metadata.Name, step: s.Step, err: err})
+			}
+		}
+	}
+}
+
+// isShellStep returns true if a pipeline step runs this function.
+func (c *LintCmd) isShellStep(fn string, input json.RawMessage) bool {
+	for _, n := range c.FunctionName {
+		if fn == n {
+			return true
+		}
+	}
+	tm := struct {
+		APIVersion string `json:"apiVersion"`
+		Kind       string `json:"kind"`
+	}{}
+	if err := json.Unmarshal(input, &tm); err != nil || tm.Kind != "Parameters" {
+		return false
+	}
+	gv, _ := schema.ParseGroupVersion(tm.APIVersion)
+	for _, g := range inputGroups {
+		if gv.Group == g {
+			return true
+		}
+	}
+	return false
+}
+
+// lintInput returns every problem with the input of a function-shell step.
+func (c *LintCmd) lintInput(input json.RawMessage, oxr *resource.Composite, pol *Policy) []error {
+	if len(input) == 0 {
+		return []error{field.Required(field.NewPath("input"), "function-shell requires a Parameters input")}
+	}
+
+	s := &structpb.Struct{}
+	if err := s.UnmarshalJSON(input); err != nil {
+		return []error{errors.Wrap(err, "cannot parse input")}
+	}
+	in, conv, err := getInput(s, c.LiteralShellCommandField)
+	if err != nil {
+		return []error{errors.Wrap(err, "cannot parse input")}
+	}
+
+	// The function ignores unknown fields of v1alpha1 input, but they're
+	// usually typos.
+	var errs []error
+	if err := conv.unknownFields(); err != nil {
+		errs = append(errs, errors.Wrap(err, "ignoring unknown fields of v1alpha1 input"))
+	}
+	for _, err := range conv.fieldErrors(append(ValidateParameters(in, oxr, pol), c.validateScripts(in)...)) {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// validateScripts validates that the scripts a shell command runs by absolute
+// path exist in the script root.
+func (c *LintCmd) validateScripts(in *v1beta1.Parameters) field.ErrorList {
+	if c.ScriptRoot == "" || in.Command.Inline == "" {
+		return nil
+	}
+	calls, err := parseCalls(in.Command.Inline)
+	if err != nil {
+		// ValidateParameters reports commands that can't be parsed.
+		return nil
+	}
+
+	var errs field.ErrorList
+	for _, call := range calls {
+		for _, s := range call.scripts {
+			if !path.IsAbs(s) {
+				continue
+			}
+			if _, err := os.Stat(filepath.Join(c.ScriptRoot, filepath.FromSlash(s))); err != nil {
+				errs = append(errs, field.NotFound(field.NewPath("parameters", "command", "inline"), s))
+			}
+		}
+	}
+	return errs
+}
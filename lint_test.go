@@ -0,0 +1,135 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLintFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "root", "scripts"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "root", "scripts", "exists.sh"), nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string]struct {
+		reason string
+		yaml   string
+		want   []string
+	}{
+		"Valid": {
+			reason: "A valid input should have no problems.",
+			yaml: `
+apiVersion: apiextensions.crossplane.io/v1
+kind: Composition
+metadata:
+  name: valid
+spec:
+  compositeTypeRef:
+    apiVersion: example.org/v1
+    kind: XR
+  mode: Pipeline
+  pipeline:
+  - step: shell
+    functionRef:
+      name: function-shell
+    input:
+      apiVersion: shell.fn.crossplane.io/v1alpha1
+      kind: Parameters
+      shellEnvVars:
+      - key: REGION
+        fieldRef:
+          path: spec.region
+      shellCommand: /scripts/exists.sh "$REGION"
+      cacheTTL: 5m
+`,
+		},
+		"Problems": {
+			reason: "Every problem of every function-shell step should be reported, and other steps and documents ignored.",
+			yaml: `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: ignored
+---
+apiVersion: apiextensions.crossplane.io/v1
+kind: Composition
+metadata:
+  name: invalid
+spec:
+  compositeTypeRef:
+    apiVersion: example.org/v1
+    kind: XR
+  mode: Pipeline
+  pipeline:
+  - step: patch
+    functionRef:
+      name: function-patch-and-transform
+    input:
+      apiVersion: pt.fn.crossplane.io/v1beta1
+      kind: Resources
+  - step: shell
+    functionRef:
+      name: my-shell
+    input:
+      apiVersion: template.fn.crossplane.io/v1alpha1
+      kind: Parameters
+      shellEnvVars:
+      - key: 1REGION
+        fieldRef:
+          path: spec.regions[0
+          policy: Sometimes
+      - key: ZONE
+        type: Secret
+      shellCommand: /scripts/missing.sh
+      cacheTTL: 5 minutes
+      stdoutField: status..stdout
+  - step: typo
+    functionRef:
+      name: function-shell
+    input:
+      apiVersion: shell.fn.crossplane.io/v1alpha1
+      kind: Parameters
+      shellComand: echo hello
+`,
+			want: []string{
+				`composition invalid: step shell: parameters.shellEnvVars[1].type: Unsupported value: "Secret": supported values: "Value", "ValueRef", "FieldRef"`,
+				`composition invalid: step shell: parameters.cacheTTL: Invalid value: "5 minutes": must be a duration like 30s or 2m`,
+				`composition invalid: step shell: parameters.shellEnvVars[0].key: Invalid value: "1REGION": must be a valid environment variable name, like API_KEY`,
+				`composition invalid: step shell: parameters.shellEnvVars[0].fieldRef.path: Invalid value: "spec.regions[0": unterminated '[' at position 12`,
+				`composition invalid: step shell: parameters.shellEnvVars[0].fieldRef.policy: Unsupported value: "Sometimes": supported values: "Optional", "Required"`,
+				`composition invalid: step shell: parameters.stdoutField: Invalid value: "status..stdout": unexpected '.' at position 7`,
+				`composition invalid: step shell: parameters.shellCommand: Not found: "/scripts/missing.sh"`,
+				`composition invalid: step typo: ignoring unknown fields of v1alpha1 input: error unmarshaling JSON: while decoding JSON: json: unknown field "shellComand"`,
+				`composition invalid: step typo: parameters.shellCommand: Required value: one of shellCommand or shellCommandField is required`,
+			},
+		},
+	}
+
+	c := &LintCmd{FunctionName: []string{"function-shell"}, ScriptRoot: filepath.Join(dir, "root")}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			filename := filepath.Join(dir, name+".yaml")
+			if err := os.WriteFile(filename, []byte(tc.yaml), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			problems, err := c.lintFile(filename, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, p := range problems {
+				got = append(got, p.String()[len(filename)+2:])
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("%s\nlintFile(...): -want problems, +got problems:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
@@ -2,7 +2,17 @@
 package main
 
 import (
+	"context"
+	"os"
+	"time"
+
 	"github.com/alecthomas/kong"
+	"github.com/crossplane-contrib/function-shell/input/v1beta1"
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	"github.com/crossplane/crossplane-runtime/v2/pkg/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
 
 	"github.com/crossplane/function-sdk-go"
 )
@@ -11,28 +21,116 @@ import (
 type CLI struct {
 	Debug bool `help:"Emit debug logs in addition to info logs." short:"d"`
 
+	Serve  ServeCmd  `cmd:"" default:"withargs" help:"Serve the function over gRPC. This is the default command."`
+	Render RenderCmd `aliases:"run"             cmd:""                                                                     help:"Run the function once against local files, and print its output as YAML."`
+	Lint   LintCmd   `cmd:""                    help:"Validate the function-shell inputs of Compositions, and print every problem found."`
+}
+
+// FunctionFlags configure the Function, whether it is served or run locally.
+type FunctionFlags struct {
+	PolicyFile     string `help:"Path to a YAML policy file declaring the interpreters, scripts, executables and environment variable sources shell commands may use." type:"existingfile"`
+	RequireSandbox bool   `help:"Run every shell command in a sandbox of Linux namespaces, regardless of its input."`
+	DefaultNetwork string `default:"Inherit"                                                                                                                      enum:"None,Inherit" help:"Network of shell commands whose input doesn't specify one. None isolates them from any network."`
+
+	LiteralShellCommandField bool `help:"Run the shellCommandField of v1alpha1 input as the shell command itself, like earlier releases, instead of the command in the field it refers to."`
+}
+
+// function returns a Function configured by the flags.
+func (c *FunctionFlags) function(log logging.Logger) (*Function, error) {
+	var pol *Policy
+	if c.PolicyFile != "" {
+		var err error
+		if pol, err = LoadPolicy(c.PolicyFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Function{
+		log:            log,
+		policy:         pol,
+		requireSandbox: c.RequireSandbox,
+		defaultNetwork: v1beta1.Network(c.DefaultNetwork),
+
+		literalShellCommandField: c.LiteralShellCommandField,
+	}, nil
+}
+
+// ServeCmd serves the Function.
+type ServeCmd struct {
 	Network            string `default:"tcp"                                                                                        help:"Network on which to listen for gRPC connections."`
 	Address            string `default:":9443"                                                                                      help:"Address at which to listen for gRPC connections."`
 	TLSCertsDir        string `env:"TLS_SERVER_CERTS_DIR"                                                                           help:"Directory containing server certs (tls.key, tls.crt) and the CA used to verify client certificates (ca.crt)"`
 	Insecure           bool   `help:"Run without mTLS credentials. If you supply this flag --tls-server-certs-dir will be ignored."`
 	MaxRecvMessageSize int    `default:"4"                                                                                          help:"Maximum size of received messages in MB."`
+	MetricsAddress     string `default:":8080"                                                                                      help:"Address at which to serve Prometheus metrics. Set to an empty string to disable metrics."`
+	MetricsTagLabel    bool   `help:"Label metrics with the tag of the function call. Tags can be unique per call, so this can create many series."`
+	TracingExporter    string `default:"none"                                                                                       enum:"none,otlp-grpc,otlp-http" help:"Exporter of OpenTelemetry traces of function calls and shell commands."`
+	TracingEndpoint    string `help:"URL of the OTLP endpoint traces are exported to. Defaults to the OTEL_EXPORTER_OTLP_ENDPOINT environment variable."`
+	AuditLog           string `help:"File to append an audit record of every shell command to, as JSON lines. Use - for stdout."`
+
+	FunctionFlags `embed:""`
 }
 
 // Run this Function.
-func (c *CLI) Run() error {
-	log, err := function.NewLogger(c.Debug)
+func (c *ServeCmd) Run(cli *CLI) error {
+	log, err := function.NewLogger(cli.Debug)
 	if err != nil {
 		return err
 	}
 
-	return function.Serve(&Function{log: log},
+	f, err := c.function(log)
+	if err != nil {
+		return err
+	}
+
+	if c.TracingExporter != tracingExporterNone {
+		tp, err := newTracerProvider(context.Background(), c.TracingExporter, c.TracingEndpoint)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			_ = tp.Shutdown(ctx)
+		}()
+		otel.SetTracerProvider(tp)
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	}
+
+	switch c.AuditLog {
+	case "":
+	case "-":
+		f.audit = NewAuditor(os.Stdout)
+	default:
+		w, err := os.OpenFile(c.AuditLog, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+		if err != nil {
+			return errors.Wrap(err, "cannot open audit log")
+		}
+		defer w.Close() //nolint:errcheck // Nothing to do if closing fails.
+		f.audit = NewAuditor(w)
+	}
+
+	f.metrics = NewMetrics(c.MetricsTagLabel)
+	if err := prometheus.DefaultRegisterer.Register(f.metrics); err != nil {
+		return err
+	}
+
+	return function.Serve(f,
 		function.Listen(c.Network, c.Address),
 		function.MTLSCertificates(c.TLSCertsDir),
 		function.Insecure(c.Insecure),
-		function.MaxRecvMessageSize(c.MaxRecvMessageSize*1024*1024))
+		function.MaxRecvMessageSize(c.MaxRecvMessageSize*1024*1024),
+		function.WithMetricsServer(c.MetricsAddress))
 }
 
 func main() {
-	ctx := kong.Parse(&CLI{}, kong.Description("A Crossplane Composition Function."))
-	ctx.FatalIfErrorf(ctx.Run())
+	// The function re-executes itself to set up the sandbox of a shell
+	// command. See sandboxInit.
+	if os.Args[0] == sandboxInitArg {
+		sandboxInit(os.Args[1:])
+	}
+
+	cli := &CLI{}
+	ctx := kong.Parse(cli, kong.Description("A Crossplane Composition Function."))
+	ctx.FatalIfErrorf(ctx.Run(cli))
 }
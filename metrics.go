@@ -0,0 +1,117 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "function_shell"
+
+// Metrics of the shell commands the function runs. Every metric is labelled
+// with the kind of the composite resource, and optionally the tag of the
+// function call. A nil *Metrics records nothing.
+type Metrics struct {
+	invocations *prometheus.CounterVec
+	executions  *prometheus.CounterVec
+	duration    *prometheus.HistogramVec
+	timeouts    *prometheus.CounterVec
+	outputBytes *prometheus.HistogramVec
+
+	// tag labels metrics with the tag of the function call. Tags can be
+	// unique per call, so the number of series they create is unbounded.
+	tag bool
+}
+
+// NewMetrics returns new metrics, labelled with the tag of the function call
+// if tag is true. They must be registered with a Prometheus registry to be
+// exported.
+func NewMetrics(tag bool) *Metrics {
+	labels := func(names ...string) []string {
+		if tag {
+			return append([]string{"kind", "tag"}, names...)
+		}
+		return append([]string{"kind"}, names...)
+	}
+	return &Metrics{
+		tag: tag,
+		invocations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "invocations_total",
+			Help:      "Number of function calls that ran a shell command.",
+		}, labels()),
+		executions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "command_executions_total",
+			Help:      "Number of times a shell command was run, including retries, by exit code. Commands that didn't exit normally have exit code -1.",
+		}, labels("exit_code")),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "command_duration_seconds",
+			Help:      "Time a shell command took to run, per attempt.",
+			Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+		}, labels()),
+		timeouts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "command_timeouts_total",
+			Help:      "Number of function calls whose shell command was killed because it exceeded its timeout.",
+		}, labels()),
+		outputBytes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "command_output_bytes",
+			Help:      "Size of the stdout and stderr of a shell command, per attempt.",
+			Buckets:   prometheus.ExponentialBuckets(64, 4, 9),
+		}, labels("stream")),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	m.invocations.Describe(ch)
+	m.executions.Describe(ch)
+	m.duration.Describe(ch)
+	m.timeouts.Describe(ch)
+	m.outputBytes.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	m.invocations.Collect(ch)
+	m.executions.Collect(ch)
+	m.duration.Collect(ch)
+	m.timeouts.Collect(ch)
+	m.outputBytes.Collect(ch)
+}
+
+// labelValues returns the values of the labels of a metric.
+func (m *Metrics) labelValues(kind, tag string, values ...string) []string {
+	if m.tag {
+		return append([]string{kind, tag}, values...)
+	}
+	return append([]string{kind}, values...)
+}
+
+func (m *Metrics) recordInvocation(kind, tag string) {
+	if m == nil {
+		return
+	}
+	m.invocations.WithLabelValues(m.labelValues(kind, tag)...).Inc()
+}
+
+func (m *Metrics) recordExecution(kind, tag string, r commandResult, d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.executions.WithLabelValues(m.labelValues(kind, tag, strconv.Itoa(r.exitCode()))...).Inc()
+	m.duration.WithLabelValues(m.labelValues(kind, tag)...).Observe(d.Seconds())
+	m.outputBytes.WithLabelValues(m.labelValues(kind, tag, "stdout")...).Observe(float64(len(r.stdout)))
+	m.outputBytes.WithLabelValues(m.labelValues(kind, tag, "stderr")...).Observe(float64(len(r.stderr)))
+}
+
+func (m *Metrics) recordTimeout(kind, tag string) {
+	if m == nil {
+		return
+	}
+	m.timeouts.WithLabelValues(m.labelValues(kind, tag)...).Inc()
+}
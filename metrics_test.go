@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/crossplane/crossplane-runtime/v2/pkg/logging"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+)
+
+func TestMetrics(t *testing.T) {
+	cases := map[string]struct {
+		reason string
+		tag    bool
+		want   string
+	}{
+		"Kind": {
+			reason: "Metrics should be labelled with the kind of the composite resource.",
+			want: `
+# HELP function_shell_command_executions_total Number of times a shell command was run, including retries, by exit code. Commands that didn't exit normally have exit code -1.
+# TYPE function_shell_command_executions_total counter
+function_shell_command_executions_total{exit_code="-1",kind="XBucket"} 1
+function_shell_command_executions_total{exit_code="0",kind="XBucket"} 1
+function_shell_command_executions_total{exit_code="3",kind="XBucket"} 2
+# HELP function_shell_command_timeouts_total Number of function calls whose shell command was killed because it exceeded its timeout.
+# TYPE function_shell_command_timeouts_total counter
+function_shell_command_timeouts_total{kind="XBucket"} 1
+# HELP function_shell_invocations_total Number of function calls that ran a shell command.
+# TYPE function_shell_invocations_total counter
+function_shell_invocations_total{kind="XBucket"} 3
+`,
+		},
+		"KindAndTag": {
+			reason: "Metrics should be labelled with the tag of the function call too if it's enabled.",
+			tag:    true,
+			want: `
+# HELP function_shell_command_executions_total Number of times a shell command was run, including retries, by exit code. Commands that didn't exit normally have exit code -1.
+# TYPE function_shell_command_executions_total counter
+function_shell_command_executions_total{exit_code="-1",kind="XBucket",tag="hello"} 1
+function_shell_command_executions_total{exit_code="0",kind="XBucket",tag="hello"} 1
+function_shell_command_executions_total{exit_code="3",kind="XBucket",tag="hello"} 2
+# HELP function_shell_command_timeouts_total Number of function calls whose shell command was killed because it exceeded its timeout.
+# TYPE function_shell_command_timeouts_total counter
+function_shell_command_timeouts_total{kind="XBucket",tag="hello"} 1
+# HELP function_shell_invocations_total Number of function calls that ran a shell command.
+# TYPE function_shell_invocations_total counter
+function_shell_invocations_total{kind="XBucket",tag="hello"} 3
+`,
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			m := NewMetrics(tc.tag)
+			f := &Function{log: logging.NewNopLogger(), metrics: m}
+
+			run := func(input string) {
+				t.Helper()
+				req := &fnv1.RunFunctionRequest{
+					Meta:  &fnv1.RequestMeta{Tag: "hello"},
+					Input: resource.MustStructJSON(input),
+					Observed: &fnv1.State{
+						Composite: &fnv1.Resource{
+							Resource: resource.MustStructJSON(`{"apiVersion": "example.org/v1", "kind": "XBucket"}`),
+						},
+					},
+				}
+				if _, err := f.RunFunction(context.Background(), req); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			run(`{"apiVersion": "template.fn.crossplane.io/v1alpha1", "kind": "Parameters", "shellCommand": "echo hello"}`)
+			run(`{"apiVersion": "template.fn.crossplane.io/v1alpha1", "kind": "Parameters", "shellCommand": "exit 3", "retry": {"attempts": 2, "initialBackoff": "1ms"}}`)
+			run(`{"apiVersion": "template.fn.crossplane.io/v1alpha1", "kind": "Parameters", "shellCommand": "sleep 10", "timeout": "10ms"}`)
+
+			if err := testutil.CollectAndCompare(m, strings.NewReader(tc.want),
+				"function_shell_command_executions_total",
+				"function_shell_command_timeouts_total",
+				"function_shell_invocations_total",
+			); err != nil {
+				t.Errorf("%s\n%v", tc.reason, err)
+			}
+
+			if got := testutil.CollectAndCount(m, "function_shell_command_duration_seconds"); got != 1 {
+				t.Errorf("%s\nCollectAndCount(function_shell_command_duration_seconds): want 1, got %d", tc.reason, got)
+			}
+			if got := testutil.CollectAndCount(m, "function_shell_command_output_bytes"); got != 2 {
+				t.Errorf("%s\nCollectAndCount(function_shell_command_output_bytes): want 2, got %d", tc.reason, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+
+	"github.com/crossplane-contrib/function-shell/input/v1beta1"
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	"github.com/crossplane/crossplane-runtime/v2/pkg/fieldpath"
+	"google.golang.org/protobuf/types/known/structpb"
+	"sigs.k8s.io/yaml"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/request"
+	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/crossplane/function-sdk-go/response"
+)
+
+// defaultMaxOutputSize is the maximum size of stdout and output files of
+// shell commands whose input doesn't specify one.
+const defaultMaxOutputSize = 1 << 20
+
+// A cappedBuffer is a buffer that stores at most max bytes. It discards the
+// rest of what is written to it, so that the shell command isn't killed by
+// a broken pipe.
+type cappedBuffer struct {
+	buf bytes.Buffer
+	// max is the maximum number of bytes stored. Zero means no maximum.
+	max      int64
+	exceeded bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.max == 0 {
+		return b.buf.Write(p)
+	}
+	if room := b.max - int64(b.buf.Len()); int64(len(p)) > room {
+		b.exceeded = true
+		_, _ = b.buf.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *cappedBuffer) String() string {
+	return b.buf.String()
+}
+
+// parseOutput parses the output of a shell command in the supplied format.
+// Text is returned as a string without leading and trailing white space.
+func parseOutput(b []byte, f v1beta1.OutputFormat) (any, error) {
+	switch f {
+	case "", v1beta1.OutputFormatText:
+		return strings.TrimSpace(string(b)), nil
+	case v1beta1.OutputFormatJSON:
+		var v any
+		err := json.Unmarshal(b, &v)
+		return v, errors.Wrap(err, "cannot parse JSON")
+	case v1beta1.OutputFormatYAML:
+		var v any
+		err := yaml.Unmarshal(b, &v)
+		return v, errors.Wrap(err, "cannot parse YAML")
+	default:
+		return nil, errors.Errorf("unknown format %s", f)
+	}
+}
+
+// connectionDetail returns a parsed output as the value of a connection
+// detail. Strings are returned as is, anything else as JSON.
+func connectionDetail(v any) ([]byte, error) {
+	if s, ok := v.(string); ok {
+		return []byte(s), nil
+	}
+	return json.Marshal(v)
+}
+
+// readOutputFiles reads the output files of a shell command from the working
+// directory dir, and writes them to the desired state ds or the context of
+// rsp. Parsed output files are transformed by tr. It returns the
+// transformed output files by path. Optional files that don't exist are
+// omitted.
+func readOutputFiles(rsp *fnv1.RunFunctionResponse, ds *desiredState, dir string, files []v1beta1.OutputFile, maxSize int64, tr *outputTransformer) (map[string]any, error) {
+	outputs := make(map[string]any, len(files))
+	for _, f := range files {
+		v, ok, err := readOutputFile(rsp, ds, dir, f, maxSize, tr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot read output file %s", f.Path)
+		}
+		if ok {
+			outputs[f.Path] = v
+		}
+	}
+	return outputs, nil
+}
+
+func readOutputFile(rsp *fnv1.RunFunctionResponse, ds *desiredState, dir string, f v1beta1.OutputFile, maxSize int64, tr *outputTransformer) (any, bool, error) {
+	if !filepath.IsLocal(f.Path) {
+		return nil, false, errors.New("path must be relative to, and within, the working directory")
+	}
+
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, false, err
+	}
+	defer root.Close() //nolint:errcheck // Nothing to do if closing fails.
+
+	// The shell command could make the file a symlink to a file of the
+	// function, which the root doesn't follow, or a FIFO, which doesn't
+	// block a non-blocking open.
+	file, err := root.OpenFile(f.Path, os.O_RDONLY|syscall.O_NONBLOCK, 0)
+	if errors.Is(err, fs.ErrNotExist) && f.Optional {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer file.Close() //nolint:errcheck // Nothing to do if closing fails.
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, false, err
+	}
+	if !info.Mode().IsRegular() {
+		return nil, false, errors.New("not a regular file")
+	}
+	if info.Size() > maxSize {
+		return nil, false, errors.Errorf("file is %d bytes, which exceeds maxOutputSize of %d bytes", info.Size(), maxSize)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(file, maxSize))
+	if err != nil {
+		return nil, false, err
+	}
+	v, err := parseOutput(b, f.Format)
+	if err != nil {
+		return nil, false, err
+	}
+	if v, err = tr.file(f.Path, v); err != nil {
+		return nil, false, err
+	}
+
+	if f.Field != "" {
+		if err := ds.setValue(f.Field, v); err != nil {
+			return nil, false, errors.Wrapf(err, "cannot set field %s", f.Field)
+		}
+	}
+	if f.ContextKey != "" {
+		cv, err := structpb.NewValue(v)
+		if err != nil {
+			return nil, false, errors.Wrapf(err, "cannot convert to context key %s", f.ContextKey)
+		}
+		response.SetContextKey(rsp, f.ContextKey, cv)
+	}
+	if f.ConnectionDetail != "" {
+		cd, err := connectionDetail(v)
+		if err != nil {
+			return nil, false, errors.Wrapf(err, "cannot convert to connection detail %s", f.ConnectionDetail)
+		}
+		ds.xr.ConnectionDetails[f.ConnectionDetail] = cd
+	}
+	return v, true, nil
+}
+
+// desiredResourcesRefRegex matches field paths that refer to a desired
+// composed resource, like desiredResources[instance].spec.forProvider.ami.
+var desiredResourcesRefRegex = regexp.MustCompile(`^desiredResources\[(.+?)]\.(.+)$`)
+
+// A desiredState is the desired state the outputs of a shell command are
+// written to: the desired composite resource, and the desired composed
+// resources of the request, which are read when one is first written to.
+type desiredState struct {
+	req  *fnv1.RunFunctionRequest
+	xr   *resource.Composite
+	dcds map[resource.Name]*resource.DesiredComposed
+}
+
+// setValue sets the field at the supplied path of the desired composite
+// resource, or of a desired composed resource if the path is like
+// desiredResources[name].path. The path may have wildcards or filters.
+func (d *desiredState) setValue(path string, v any) error {
+	if match := desiredResourcesRefRegex.FindStringSubmatch(path); match != nil {
+		return d.setComposedValue(match[1], match[2], v)
+	}
+	if !isFieldExpr(path) {
+		return d.xr.Resource.SetValue(path, v)
+	}
+	return setFieldValue(fieldpath.Pave(d.xr.Resource.Object), path, v)
+}
+
+// setComposedValue sets the field at the supplied path of the named desired
+// composed resource, which an earlier function of the pipeline must have
+// added.
+func (d *desiredState) setComposedValue(name, path string, v any) error {
+	if d.dcds == nil {
+		dcds, err := request.GetDesiredComposedResources(d.req)
+		if err != nil {
+			return errors.Wrapf(err, "cannot get desired composed resources from %T", d.req)
+		}
+		d.dcds = dcds
+	}
+	dcd, ok := d.dcds[resource.Name(name)]
+	if !ok {
+		return errors.Errorf("desired composed resource %s does not exist", name)
+	}
+	return setFieldValue(fieldpath.Pave(dcd.Resource.Object), path, v)
+}
+
+// setResponse sets the desired composite resource of rsp, and the desired
+// composed resources if any was written to.
+func (d *desiredState) setResponse(rsp *fnv1.RunFunctionResponse) error {
+	if err := response.SetDesiredCompositeResource(rsp, d.xr); err != nil {
+		return errors.Wrap(err, "cannot set desired composite resource")
+	}
+	if d.dcds == nil {
+		return nil
+	}
+	return errors.Wrap(response.SetDesiredComposedResources(rsp, d.dcds), "cannot set desired composed resources")
+}
+
+// keepPreviousOutputs copies the observed values of the supplied fields, the
+// fields of the output files and targets, and the connection details of a
+// shell command that was skipped or failed from the observed composite
+// resource oxr, and the observed composed resources, to the desired state ds,
+// so that they aren't deleted. Empty fields, fields with wildcards or
+// filters, and values that weren't observed, are skipped.
+func keepPreviousOutputs(oxr *resource.Composite, ds *desiredState, out v1beta1.Outputs, fields ...string) error {
+	names := make([]string, 0, len(out.ConnectionDetails)+len(out.Files))
+	for _, cd := range out.ConnectionDetails {
+		names = append(names, cd.Name)
+	}
+	for _, f := range out.Files {
+		if f.Field != "" {
+			fields = append(fields, f.Field)
+		}
+		if f.ConnectionDetail != "" {
+			names = append(names, f.ConnectionDetail)
+		}
+	}
+	for _, t := range out.Targets {
+		if t.Field != "" {
+			fields = append(fields, t.Field)
+		}
+		if t.ConnectionDetail != "" {
+			names = append(names, t.ConnectionDetail)
+		}
+	}
+
+	var ocds map[resource.Name]resource.ObservedComposed
+	for _, path := range fields {
+		if path == "" || isFieldExpr(path) {
+			continue
+		}
+		match := desiredResourcesRefRegex.FindStringSubmatch(path)
+		if match == nil {
+			v, err := oxr.Resource.GetValue(path)
+			if fieldpath.IsNotFound(err) {
+				continue
+			}
+			if err != nil {
+				return errors.Wrapf(err, "cannot get observed field %s", path)
+			}
+			if err := ds.xr.Resource.SetValue(path, v); err != nil {
+				return errors.Wrapf(err, "cannot set field %s", path)
+			}
+			continue
+		}
+
+		if ocds == nil {
+			var err error
+			if ocds, err = request.GetObservedComposedResources(ds.req); err != nil {
+				return errors.Wrapf(err, "cannot get observed composed resources from %T", ds.req)
+			}
+		}
+		ocd, ok := ocds[resource.Name(match[1])]
+		if !ok {
+			continue
+		}
+		v, err := fieldpath.Pave(ocd.Resource.Object).GetValue(match[2])
+		if fieldpath.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return errors.Wrapf(err, "cannot get observed field %s", path)
+		}
+		if err := ds.setValue(path, v); err != nil {
+			return errors.Wrapf(err, "cannot set field %s", path)
+		}
+	}
+	for _, name := range names {
+		if v, ok := oxr.ConnectionDetails[name]; ok {
+			ds.xr.ConnectionDetails[name] = v
+		}
+	}
+	return nil
+}
+
+// outputSources are the outputs of a shell command that output targets take
+// values from.
+type outputSources struct {
+	stdout   any
+	stderr   any
+	exitCode int
+	// files are the parsed output files by path. They're nil if the output
+	// files weren't read.
+	files map[string]any
+}
+
+// writeOutputTargets writes the values of the supplied output targets to the
+// desired state ds, and the context of rsp.
+func writeOutputTargets(rsp *fnv1.RunFunctionResponse, ds *desiredState, targets []v1beta1.OutputTarget, src outputSources) error {
+	for i, t := range targets {
+		v, ok, err := outputTargetValue(t, src)
+		if err != nil {
+			return errors.Wrapf(err, "cannot get value of target %d", i)
+		}
+		if !ok {
+			continue
+		}
+
+		if t.Field != "" {
+			if err := ds.setValue(t.Field, v); err != nil {
+				return errors.Wrapf(err, "cannot set field %s", t.Field)
+			}
+		}
+		if t.ContextKey != "" {
+			cv, err := structpb.NewValue(v)
+			if err != nil {
+				return errors.Wrapf(err, "cannot convert to context key %s", t.ContextKey)
+			}
+			response.SetContextKey(rsp, t.ContextKey, cv)
+		}
+		if t.ConnectionDetail != "" {
+			cd, err := connectionDetail(v)
+			if err != nil {
+				return errors.Wrapf(err, "cannot convert to connection detail %s", t.ConnectionDetail)
+			}
+			ds.xr.ConnectionDetails[t.ConnectionDetail] = cd
+		}
+	}
+	return nil
+}
+
+// outputTargetValue returns the value of the supplied output target. It
+// returns false if the output file the value is taken from wasn't read.
+func outputTargetValue(t v1beta1.OutputTarget, src outputSources) (any, bool, error) {
+	var v any
+	switch t.Source {
+	case "", v1beta1.OutputSourceStdout:
+		v = src.stdout
+	case v1beta1.OutputSourceStderr:
+		v = src.stderr
+	case v1beta1.OutputSourceExitCode:
+		v = int64(src.exitCode)
+	case v1beta1.OutputSourceFile:
+		var ok bool
+		if v, ok = src.files[t.File]; !ok {
+			return nil, false, nil
+		}
+	default:
+		return nil, false, errors.Errorf("unknown source %s", t.Source)
+	}
+	if t.FieldPath == "" {
+		return v, true, nil
+	}
+	o, ok := v.(map[string]any)
+	if !ok {
+		return nil, false, errors.Errorf("cannot get fieldPath %s: output is not an object", t.FieldPath)
+	}
+	fv, err := getFieldValue(fieldpath.Pave(o), t.FieldPath)
+	if err != nil {
+		return nil, false, errors.Wrapf(err, "cannot get fieldPath %s", t.FieldPath)
+	}
+	return fv, true, nil
+}
+
+// setConnectionDetails sets the connection details of the desired composite
+// resource dxr from the parsed stdout and output files of a shell command.
+// Connection details from optional output files that don't exist are skipped.
+func setConnectionDetails(dxr *resource.Composite, cds []v1beta1.ConnectionDetail, stdout any, outputs map[string]any) error {
+	for _, cd := range cds {
+		v := stdout
+		if cd.OutputFile != "" {
+			var ok bool
+			if v, ok = outputs[cd.OutputFile]; !ok {
+				continue
+			}
+		}
+		if cd.FieldPath != "" {
+			o, ok := v.(map[string]any)
+			if !ok {
+				return errors.Errorf("cannot get fieldPath %s of connection detail %s: output is not an object", cd.FieldPath, cd.Name)
+			}
+			fv, err := getFieldValue(fieldpath.Pave(o), cd.FieldPath)
+			if err != nil {
+				return errors.Wrapf(err, "cannot get fieldPath %s of connection detail %s", cd.FieldPath, cd.Name)
+			}
+			v = fv
+		}
+		b, err := connectionDetail(v)
+		if err != nil {
+			return errors.Wrapf(err, "cannot convert connection detail %s", cd.Name)
+		}
+		dxr.ConnectionDetails[cd.Name] = b
+	}
+	return nil
+}
@@ -0,0 +1,489 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/crossplane-contrib/function-shell/input/v1beta1"
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/crossplane/function-sdk-go/resource/composite"
+)
+
+func TestCappedBuffer(t *testing.T) {
+	type want struct {
+		s        string
+		exceeded bool
+	}
+
+	cases := map[string]struct {
+		reason string
+		max    int64
+		writes []string
+		want   want
+	}{
+		"NoMaximum": {
+			reason: "A buffer without a maximum should store everything.",
+			writes: []string{"hello ", "world"},
+			want:   want{s: "hello world"},
+		},
+		"WithinMaximum": {
+			reason: "A buffer should store writes up to its maximum.",
+			max:    11,
+			writes: []string{"hello ", "world"},
+			want:   want{s: "hello world"},
+		},
+		"ExceedsMaximum": {
+			reason: "A buffer should cut writes beyond its maximum, and report it.",
+			max:    8,
+			writes: []string{"hello ", "world", "!"},
+			want:   want{s: "hello wo", exceeded: true},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			b := &cappedBuffer{max: tc.max}
+			for _, w := range tc.writes {
+				n, err := b.Write([]byte(w))
+				if err != nil || n != len(w) {
+					t.Fatalf("%s\nWrite(%q): want %d, <nil>, got %d, %v", tc.reason, w, len(w), n, err)
+				}
+			}
+			if diff := cmp.Diff(tc.want, want{s: b.String(), exceeded: b.exceeded}, cmp.AllowUnexported(want{})); diff != "" {
+				t.Errorf("%s\nWrite(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestParseOutput(t *testing.T) {
+	type want struct {
+		v   any
+		err error
+	}
+
+	cases := map[string]struct {
+		reason string
+		b      string
+		format v1beta1.OutputFormat
+		want   want
+	}{
+		"Text": {
+			reason: "Text should be returned without leading and trailing white space.",
+			b:      "  hello\n",
+			format: v1beta1.OutputFormatText,
+			want:   want{v: "hello"},
+		},
+		"DefaultIsText": {
+			reason: "No format should be parsed as text.",
+			b:      `{"a": 1}`,
+			want:   want{v: `{"a": 1}`},
+		},
+		"JSON": {
+			reason: "JSON should be parsed.",
+			b:      `{"a": 1, "b": ["c"]}`,
+			format: v1beta1.OutputFormatJSON,
+			want:   want{v: map[string]any{"a": float64(1), "b": []any{"c"}}},
+		},
+		"YAML": {
+			reason: "YAML should be parsed.",
+			b:      "a: 1\nb:\n- c\n",
+			format: v1beta1.OutputFormatYAML,
+			want:   want{v: map[string]any{"a": float64(1), "b": []any{"c"}}},
+		},
+		"InvalidJSON": {
+			reason: "Invalid JSON should return an error.",
+			b:      `{"a": `,
+			format: v1beta1.OutputFormatJSON,
+			want:   want{err: errors.New("cannot parse JSON: unexpected end of JSON input")},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			v, err := parseOutput([]byte(tc.b), tc.format)
+
+			if diff := cmp.Diff(tc.want.v, v); diff != "" {
+				t.Errorf("%s\nparseOutput(...): -want, +got:\n%s", tc.reason, diff)
+			}
+			if tc.want.err != nil && err != nil {
+				if diff := cmp.Diff(tc.want.err.Error(), err.Error()); diff != "" {
+					t.Errorf("%s\nparseOutput(...): -want err message, +got err message:\n%s", tc.reason, diff)
+				}
+			} else if diff := cmp.Diff(tc.want.err, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("%s\nparseOutput(...): -want err, +got err:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestReadOutputFiles(t *testing.T) {
+	dir := t.TempDir()
+	for path, content := range map[string]string{
+		"out.json":   `{"endpoint": "https://example.org", "port": 443}`,
+		"out.yaml":   "token: s3cr3t\n",
+		"version":    "1.2.3\n",
+		"large.json": `{"a": "` + strings.Repeat("a", 64) + `"}`,
+	} {
+		if err := os.WriteFile(filepath.Join(dir, path), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("/etc/hostname", filepath.Join(dir, "hostname")); err != nil {
+		t.Fatal(err)
+	}
+
+	type want struct {
+		xr  map[string]any
+		cd  resource.ConnectionDetails
+		ctx string
+		err error
+	}
+
+	cases := map[string]struct {
+		reason string
+		files  []v1beta1.OutputFile
+		want   want
+	}{
+		"Field": {
+			reason: "A parsed file should be written to a composite field.",
+			files:  []v1beta1.OutputFile{{Path: "out.json", Format: v1beta1.OutputFormatJSON, Field: "status.outputs"}},
+			want: want{
+				xr: map[string]any{"status": map[string]any{"outputs": map[string]any{"endpoint": "https://example.org", "port": int64(443)}}},
+				cd: resource.ConnectionDetails{},
+			},
+		},
+		"ContextKey": {
+			reason: "A text file should be written to a context key.",
+			files:  []v1beta1.OutputFile{{Path: "version", ContextKey: "example.org/version"}},
+			want: want{
+				xr:  map[string]any{},
+				cd:  resource.ConnectionDetails{},
+				ctx: `{"example.org/version":"1.2.3"}`,
+			},
+		},
+		"ConnectionDetail": {
+			reason: "A parsed file that isn't a string should be written to a connection detail as JSON.",
+			files:  []v1beta1.OutputFile{{Path: "out.yaml", Format: v1beta1.OutputFormatYAML, ConnectionDetail: "outputs"}},
+			want: want{
+				xr: map[string]any{},
+				cd: resource.ConnectionDetails{"outputs": []byte(`{"token":"s3cr3t"}`)},
+			},
+		},
+		"OptionalMissing": {
+			reason: "A missing optional file should be skipped.",
+			files:  []v1beta1.OutputFile{{Path: "missing.json", Optional: true, Field: "status.missing"}},
+			want: want{
+				xr: map[string]any{},
+				cd: resource.ConnectionDetails{},
+			},
+		},
+		"RequiredMissing": {
+			reason: "A missing file that isn't optional should return an error.",
+			files:  []v1beta1.OutputFile{{Path: "missing.json", Field: "status.missing"}},
+			want: want{
+				xr:  map[string]any{},
+				cd:  resource.ConnectionDetails{},
+				err: errors.New("cannot read output file missing.json: openat missing.json: no such file or directory"),
+			},
+		},
+		"ExceedsMaxOutputSize": {
+			reason: "A file larger than the maximum output size should return an error.",
+			files:  []v1beta1.OutputFile{{Path: "large.json", Field: "status.large"}},
+			want: want{
+				xr:  map[string]any{},
+				cd:  resource.ConnectionDetails{},
+				err: errors.New("cannot read output file large.json: file is 73 bytes, which exceeds maxOutputSize of 64 bytes"),
+			},
+		},
+		"SymlinkOutOfWorkingDirectory": {
+			reason: "A symlink out of the working directory should not be followed.",
+			files:  []v1beta1.OutputFile{{Path: "hostname", Field: "status.hostname"}},
+			want: want{
+				xr:  map[string]any{},
+				cd:  resource.ConnectionDetails{},
+				err: errors.New("cannot read output file hostname: openat hostname: path escapes from parent"),
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			rsp := &fnv1.RunFunctionResponse{}
+			dxr := &resource.Composite{Resource: composite.New(), ConnectionDetails: resource.ConnectionDetails{}}
+			_, err := readOutputFiles(rsp, &desiredState{xr: dxr}, dir, tc.files, 64, nil)
+
+			if diff := cmp.Diff(tc.want.xr, dxr.Resource.Object); diff != "" {
+				t.Errorf("%s\nreadOutputFiles(...): -want xr, +got xr:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.cd, dxr.ConnectionDetails); diff != "" {
+				t.Errorf("%s\nreadOutputFiles(...): -want connection details, +got connection details:\n%s", tc.reason, diff)
+			}
+			var ctx *fnv1.RunFunctionResponse
+			if tc.want.ctx != "" {
+				ctx = &fnv1.RunFunctionResponse{Context: resource.MustStructJSON(tc.want.ctx)}
+			} else {
+				ctx = &fnv1.RunFunctionResponse{}
+			}
+			if diff := cmp.Diff(ctx, rsp, protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nreadOutputFiles(...): -want context, +got context:\n%s", tc.reason, diff)
+			}
+			if tc.want.err != nil && err != nil {
+				if diff := cmp.Diff(tc.want.err.Error(), err.Error()); diff != "" {
+					t.Errorf("%s\nreadOutputFiles(...): -want err message, +got err message:\n%s", tc.reason, diff)
+				}
+			} else if diff := cmp.Diff(tc.want.err, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("%s\nreadOutputFiles(...): -want err, +got err:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestSetConnectionDetails(t *testing.T) {
+	stdout := map[string]any{"credentials": map[string]any{"token": "s3cr3t"}, "port": float64(443)}
+	outputs := map[string]any{"endpoint": "https://example.org", "out.json": map[string]any{"user": "admin"}}
+
+	type want struct {
+		cd  resource.ConnectionDetails
+		err error
+	}
+
+	cases := map[string]struct {
+		reason string
+		cds    []v1beta1.ConnectionDetail
+		stdout any
+		want   want
+	}{
+		"FromStdout": {
+			reason: "Connection details should be taken from fields of the parsed stdout, with values that aren't strings as JSON.",
+			cds: []v1beta1.ConnectionDetail{
+				{Name: "token", FieldPath: "credentials.token"},
+				{Name: "port", FieldPath: "port"},
+				{Name: "credentials", FieldPath: "credentials"},
+			},
+			stdout: stdout,
+			want: want{
+				cd: resource.ConnectionDetails{
+					"token":       []byte("s3cr3t"),
+					"port":        []byte("443"),
+					"credentials": []byte(`{"token":"s3cr3t"}`),
+				},
+			},
+		},
+		"FromOutputFiles": {
+			reason: "Connection details should be taken from whole output files, or fields of them.",
+			cds: []v1beta1.ConnectionDetail{
+				{Name: "endpoint", OutputFile: "endpoint"},
+				{Name: "user", OutputFile: "out.json", FieldPath: "user"},
+			},
+			want: want{
+				cd: resource.ConnectionDetails{
+					"endpoint": []byte("https://example.org"),
+					"user":     []byte("admin"),
+				},
+			},
+		},
+		"MissingOptionalOutputFile": {
+			reason: "Connection details from an optional output file that doesn't exist should be skipped.",
+			cds:    []v1beta1.ConnectionDetail{{Name: "missing", OutputFile: "missing.json"}},
+			want:   want{cd: resource.ConnectionDetails{}},
+		},
+		"FieldPathOfText": {
+			reason: "A field path of text output should return an error.",
+			cds:    []v1beta1.ConnectionDetail{{Name: "token", FieldPath: "token"}},
+			stdout: "s3cr3t",
+			want: want{
+				cd:  resource.ConnectionDetails{},
+				err: errors.New("cannot get fieldPath token of connection detail token: output is not an object"),
+			},
+		},
+		"MissingFieldPath": {
+			reason: "A field path that doesn't exist should return an error.",
+			cds:    []v1beta1.ConnectionDetail{{Name: "password", FieldPath: "credentials.password"}},
+			stdout: stdout,
+			want: want{
+				cd:  resource.ConnectionDetails{},
+				err: errors.New("cannot get fieldPath credentials.password of connection detail password: credentials.password: no such field"),
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			dxr := &resource.Composite{Resource: composite.New(), ConnectionDetails: resource.ConnectionDetails{}}
+			err := setConnectionDetails(dxr, tc.cds, tc.stdout, outputs)
+
+			if diff := cmp.Diff(tc.want.cd, dxr.ConnectionDetails); diff != "" {
+				t.Errorf("%s\nsetConnectionDetails(...): -want connection details, +got connection details:\n%s", tc.reason, diff)
+			}
+			if tc.want.err != nil && err != nil {
+				if diff := cmp.Diff(tc.want.err.Error(), err.Error()); diff != "" {
+					t.Errorf("%s\nsetConnectionDetails(...): -want err message, +got err message:\n%s", tc.reason, diff)
+				}
+			} else if diff := cmp.Diff(tc.want.err, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("%s\nsetConnectionDetails(...): -want err, +got err:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestWriteOutputTargets(t *testing.T) {
+	req := &fnv1.RunFunctionRequest{
+		Desired: &fnv1.State{
+			Resources: map[string]*fnv1.Resource{
+				"instance": {
+					Resource: resource.MustStructJSON(`{
+						"apiVersion": "ec2.aws.upbound.io/v1beta1",
+						"kind": "Instance",
+						"spec": {"forProvider": {"region": "eu-west-1"}}
+					}`),
+					Ready: fnv1.Ready_READY_TRUE,
+				},
+				"network": {
+					Resource: resource.MustStructJSON(`{
+						"apiVersion": "ec2.aws.upbound.io/v1beta1",
+						"kind": "VPC"
+					}`),
+				},
+			},
+		},
+	}
+	src := outputSources{
+		stdout:   map[string]any{"image": map[string]any{"id": "ami-1"}, "token": "s3cr3t"},
+		stderr:   "warning",
+		exitCode: 0,
+		files:    map[string]any{"out.json": map[string]any{"names": []any{"a", "b"}}},
+	}
+
+	type want struct {
+		xr       map[string]any
+		cd       resource.ConnectionDetails
+		composed map[resource.Name]map[string]any
+		rsp      *fnv1.RunFunctionResponse
+		err      string
+	}
+
+	cases := map[string]struct {
+		reason  string
+		targets []v1beta1.OutputTarget
+		want    want
+	}{
+		"Destinations": {
+			reason: "Every source should be written to every destination of its target.",
+			targets: []v1beta1.OutputTarget{
+				{FieldPath: "image.id", Field: "status.imageId", ContextKey: "example.org/image"},
+				{Source: v1beta1.OutputSourceStdout, FieldPath: "token", ConnectionDetail: "token"},
+				{Source: v1beta1.OutputSourceStderr, Field: "status.warning"},
+				{Source: v1beta1.OutputSourceExitCode, Field: "status.exitCode"},
+				{Source: v1beta1.OutputSourceFile, File: "out.json", FieldPath: "names", ConnectionDetail: "names"},
+			},
+			want: want{
+				xr: map[string]any{"status": map[string]any{
+					"imageId":  "ami-1",
+					"warning":  "warning",
+					"exitCode": int64(0),
+				}},
+				cd:  resource.ConnectionDetails{"token": []byte("s3cr3t"), "names": []byte(`["a","b"]`)},
+				rsp: &fnv1.RunFunctionResponse{Context: resource.MustStructJSON(`{"example.org/image": "ami-1"}`)},
+			},
+		},
+		"ComposedResource": {
+			reason: "Fields of desired composed resources should be patched in place.",
+			targets: []v1beta1.OutputTarget{
+				{FieldPath: "image.id", Field: "desiredResources[instance].spec.forProvider.ami"},
+				{FieldPath: "image.id", Field: "desiredResources[network].spec.forProvider.tags.image"},
+			},
+			want: want{
+				xr: map[string]any{},
+				cd: resource.ConnectionDetails{},
+				composed: map[resource.Name]map[string]any{
+					"instance": {
+						"apiVersion": "ec2.aws.upbound.io/v1beta1",
+						"kind":       "Instance",
+						"spec":       map[string]any{"forProvider": map[string]any{"region": "eu-west-1", "ami": "ami-1"}},
+					},
+					"network": {
+						"apiVersion": "ec2.aws.upbound.io/v1beta1",
+						"kind":       "VPC",
+						"spec":       map[string]any{"forProvider": map[string]any{"tags": map[string]any{"image": "ami-1"}}},
+					},
+				},
+				rsp: &fnv1.RunFunctionResponse{},
+			},
+		},
+		"MissingComposedResource": {
+			reason: "A field path of a composed resource that isn't desired should be an error.",
+			targets: []v1beta1.OutputTarget{
+				{Field: "desiredResources[bucket].spec.name"},
+			},
+			want: want{
+				xr:  map[string]any{},
+				cd:  resource.ConnectionDetails{},
+				rsp: &fnv1.RunFunctionResponse{},
+				err: "cannot set field desiredResources[bucket].spec.name: desired composed resource bucket does not exist",
+			},
+		},
+		"MissingFile": {
+			reason: "Targets of output files that weren't read should be skipped.",
+			targets: []v1beta1.OutputTarget{
+				{Source: v1beta1.OutputSourceFile, File: "missing.json", Field: "status.missing"},
+			},
+			want: want{
+				xr:  map[string]any{},
+				cd:  resource.ConnectionDetails{},
+				rsp: &fnv1.RunFunctionResponse{},
+			},
+		},
+		"NotAnObject": {
+			reason: "A fieldPath of an output that isn't an object should be an error.",
+			targets: []v1beta1.OutputTarget{
+				{Source: v1beta1.OutputSourceStderr, FieldPath: "message", Field: "status.message"},
+			},
+			want: want{
+				xr:  map[string]any{},
+				cd:  resource.ConnectionDetails{},
+				rsp: &fnv1.RunFunctionResponse{},
+				err: "cannot get value of target 0: cannot get fieldPath message: output is not an object",
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			rsp := &fnv1.RunFunctionResponse{}
+			dxr := &resource.Composite{Resource: composite.New(), ConnectionDetails: resource.ConnectionDetails{}}
+			ds := &desiredState{req: req, xr: dxr}
+			err := writeOutputTargets(rsp, ds, tc.targets, src)
+
+			if diff := cmp.Diff(tc.want.xr, dxr.Resource.Object); diff != "" {
+				t.Errorf("%s\nwriteOutputTargets(...): -want xr, +got xr:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.cd, dxr.ConnectionDetails); diff != "" {
+				t.Errorf("%s\nwriteOutputTargets(...): -want connection details, +got connection details:\n%s", tc.reason, diff)
+			}
+			for name, want := range tc.want.composed {
+				if diff := cmp.Diff(want, ds.dcds[name].Resource.Object); diff != "" {
+					t.Errorf("%s\nwriteOutputTargets(...): -want composed resource %s, +got composed resource %s:\n%s", tc.reason, name, name, diff)
+				}
+			}
+			if diff := cmp.Diff(tc.want.rsp, rsp, protocmp.Transform()); diff != "" {
+				t.Errorf("%s\nwriteOutputTargets(...): -want rsp, +got rsp:\n%s", tc.reason, diff)
+			}
+			var got string
+			if err != nil {
+				got = err.Error()
+			}
+			if diff := cmp.Diff(tc.want.err, got); diff != "" {
+				t.Errorf("%s\nwriteOutputTargets(...): -want err message, +got err message:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,590 @@
+package main
+
+import (
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/crossplane-contrib/function-shell/input/v1beta1"
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"mvdan.cc/sh/v3/expand"
+	"mvdan.cc/sh/v3/syntax"
+	"sigs.k8s.io/yaml"
+
+	"github.com/crossplane/function-sdk-go/resource"
+)
+
+// A Policy restricts the shell commands Compositions may run. It is loaded by
+// the function server from a file, so Composition authors cannot change it.
+type Policy struct {
+	// Rules of the policy. A shell command must be allowed by every rule
+	// that matches its composite resource.
+	Rules []PolicyRule `json:"rules"`
+}
+
+// A PolicyRule restricts the shell commands run for the composite resources
+// it matches.
+type PolicyRule struct {
+	// Match selects the composite resources the rule applies to. An empty
+	// Match applies the rule to every composite resource.
+	Match PolicyMatch `json:"match,omitempty"`
+
+	// Interpreters that may run scripts or inline code, like bash or
+	// python3.
+	Interpreters PolicyList `json:"interpreters,omitempty"`
+
+	// ScriptRefs are the paths of scripts that may be run, either by an
+	// interpreter, by sourcing them or by running them directly.
+	ScriptRefs PolicyList `json:"scriptRefs,omitempty"`
+
+	// Executables that may be run. Patterns without a slash match the
+	// base name of the executable.
+	Executables PolicyList `json:"executables,omitempty"`
+
+	// EnvSources that may populate environment variables and files, in the
+	// form Value, FieldRef:<path>, EnvVarRef:<name>, FileRef:<path>,
+	// ContextKey:<key> or CredentialRef:<name>. The v1alpha1 forms
+	// ValueRef:<path> and ShellEnvVarsRef:<name> are read as FieldRef:<path>
+	// and EnvVarRef:<name>.
+	EnvSources PolicyList `json:"envSources,omitempty"`
+}
+
+// PolicyMatch selects composite resources by apiVersion and kind. Both may be
+// glob patterns.
+type PolicyMatch struct {
+	APIVersion string `json:"apiVersion,omitempty"`
+	Kind       string `json:"kind,omitempty"`
+}
+
+// A PolicyList allows and denies values using glob patterns, where * matches
+// any sequence of characters. A value is denied if it matches any Deny
+// pattern, or if Allow is set and it matches no Allow pattern.
+type PolicyList struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+}
+
+// interpreters are the base names of executables known to run scripts or
+// inline code.
+var interpreters = []string{
+	"sh", "bash", "dash", "zsh", "ksh", "ash",
+	"python*", "node", "perl", "ruby", "php", "pwsh", "lua",
+}
+
+// shells are the interpreters whose inline code is a shell command line.
+var shells = []string{"sh", "bash", "dash", "zsh", "ksh", "ash"}
+
+// A wrapper is a command that runs the command passed as its arguments, like
+// env or nice.
+type wrapper struct {
+	// valueOptions are the options whose value is the next argument, like
+	// the -n of nice -n 10.
+	valueOptions []string
+	// unknownOptions are the options that make the command the wrapper runs
+	// impossible to determine, like the -S of env -S 'aws s3 ls'.
+	unknownOptions []string
+	// assignments are true if the wrapper takes variable assignments, like
+	// the NAME=value of env NAME=value, before the command.
+	assignments bool
+	// operands is the number of arguments before the command, like the
+	// duration of timeout 5s.
+	operands int
+}
+
+// wrappers by base name.
+var wrappers = map[string]wrapper{
+	"builtin": {},
+	"command": {},
+	"env":     {valueOptions: []string{"-u", "--unset", "-C", "--chdir"}, unknownOptions: []string{"-S", "--split-string"}, assignments: true},
+	"exec":    {valueOptions: []string{"-a"}},
+	"nice":    {valueOptions: []string{"-n", "--adjustment"}},
+	"nohup":   {},
+	"setsid":  {},
+	"stdbuf":  {valueOptions: []string{"-i", "-o", "-e", "--input", "--output", "--error"}},
+	"time":    {valueOptions: []string{"-f", "--format", "-o", "--output"}},
+	"timeout": {valueOptions: []string{"-k", "--kill-after", "-s", "--signal"}, operands: 1},
+}
+
+// launchers are commands that run commands which can't be determined from
+// their arguments, like eval, xargs or sudo.
+var launchers = []string{
+	"eval", "trap", "xargs", "parallel", "watch", "flock", "sudo", "doas", "su", "runuser",
+	"chroot", "unshare", "nsenter", "ionice", "chrt", "taskset", "strace", "ltrace", "script", "busybox",
+}
+
+// findActions are the actions of find that run a command.
+var findActions = []string{"-exec", "-execdir", "-ok", "-okdir"}
+
+// LoadPolicy loads a Policy from a YAML file.
+func LoadPolicy(filename string) (*Policy, error) {
+	data, err := os.ReadFile(filename) //nolint:gosec // The policy file is supplied by the operator.
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read policy file")
+	}
+	p := &Policy{}
+	if err := yaml.UnmarshalStrict(data, p); err != nil {
+		return nil, errors.Wrap(err, "cannot parse policy file")
+	}
+	return p, nil
+}
+
+// Validate returns an error if the Parameters are not allowed by the rules
+// of the Policy that match the composite resource. A nil Policy allows
+// everything.
+func (p *Policy) Validate(in *v1beta1.Parameters, oxr *resource.Composite) *field.Error {
+	if p == nil {
+		return nil
+	}
+
+	root := field.NewPath("parameters")
+	for _, r := range p.Rules {
+		if !r.Match.matches(oxr) {
+			continue
+		}
+		if err := r.validateCommand(in.Command.Inline); err != nil {
+			return field.Forbidden(root.Child("command", "inline"), err.Error())
+		}
+		for i, ev := range in.Env.Vars {
+			if src := envSource(ev); !r.EnvSources.allows(src, matchEnvSource) {
+				return field.Forbidden(root.Child("env", "vars").Index(i), errors.Errorf("env source %q is not allowed by policy", src).Error())
+			}
+		}
+		for i, from := range in.Env.From {
+			fp := root.Child("env", "from").Index(i)
+			if from.EnvVarRef != nil {
+				if src := envSourceEnvVarRef + ":" + from.EnvVarRef.Name; !r.EnvSources.allows(src, matchEnvSource) {
+					return field.Forbidden(fp.Child("envVarRef"), errors.Errorf("env source %q is not allowed by policy", src).Error())
+				}
+			}
+			if from.FieldRef != nil {
+				if src := envSourceFieldRef + ":" + from.FieldRef.Path; !r.EnvSources.allows(src, matchEnvSource) {
+					return field.Forbidden(fp.Child("fieldRef"), errors.Errorf("env source %q is not allowed by policy", src).Error())
+				}
+			}
+			if from.FileRef != nil {
+				if src := envSourceFileRef + ":" + from.FileRef.Path; !r.EnvSources.allows(src, matchEnvSource) {
+					return field.Forbidden(fp.Child("fileRef"), errors.Errorf("env source %q is not allowed by policy", src).Error())
+				}
+			}
+		}
+		for i, file := range in.Files {
+			if src := fileSource(file); !r.EnvSources.allows(src, matchEnvSource) {
+				return field.Forbidden(root.Child("files").Index(i), errors.Errorf("file source %q is not allowed by policy", src).Error())
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateCommand validates a shell command read from the field a
+// command.fieldRef refers to against the rules of the Policy that match the
+// composite resource. A nil Policy allows everything.
+func (p *Policy) ValidateCommand(shellCmd string, oxr *resource.Composite) *field.Error {
+	if p == nil {
+		return nil
+	}
+	for _, r := range p.Rules {
+		if !r.Match.matches(oxr) {
+			continue
+		}
+		if err := r.validateCommand(shellCmd); err != nil {
+			return field.Forbidden(field.NewPath("parameters", "command", "fieldRef"), err.Error())
+		}
+	}
+	return nil
+}
+
+// IsolatesEnv reports whether a rule of the Policy that matches the composite
+// resource restricts the EnvVarRef sources of environment variables. The shell
+// command then doesn't inherit the environment of the function, besides PATH
+// and HOME, since it could otherwise read the denied variables directly. A nil
+// Policy isolates nothing.
+func (p *Policy) IsolatesEnv(oxr *resource.Composite) bool {
+	if p == nil {
+		return false
+	}
+	for _, r := range p.Rules {
+		if r.Match.matches(oxr) && r.EnvSources.restrictsEnvVarRefs() {
+			return true
+		}
+	}
+	return false
+}
+
+func (m PolicyMatch) matches(oxr *resource.Composite) bool {
+	var apiVersion, kind string
+	if oxr != nil && oxr.Resource != nil {
+		apiVersion, kind = oxr.Resource.GetAPIVersion(), oxr.Resource.GetKind()
+	}
+	if m.APIVersion != "" && !matchGlob(m.APIVersion, apiVersion) {
+		return false
+	}
+	if m.Kind != "" && !matchGlob(m.Kind, kind) {
+		return false
+	}
+	return true
+}
+
+func (r PolicyRule) validateCommand(shellCmd string) error {
+	if r.Interpreters.empty() && r.ScriptRefs.empty() && r.Executables.empty() {
+		return nil
+	}
+	calls, err := parseCalls(shellCmd)
+	if err != nil {
+		return errors.Wrap(err, "cannot parse shell command to enforce policy")
+	}
+	for _, c := range calls {
+		if !r.Executables.allows(c.name, matchExecutable) {
+			return errors.Errorf("executable %q is not allowed by policy", c.name)
+		}
+		if c.interpreter && !r.Interpreters.allows(c.name, matchExecutable) {
+			return errors.Errorf("interpreter %q is not allowed by policy", c.name)
+		}
+		for _, s := range c.scripts {
+			if !r.ScriptRefs.allows(s, matchScript) {
+				return errors.Errorf("script %q is not allowed by policy", s)
+			}
+		}
+	}
+	return nil
+}
+
+func (l PolicyList) empty() bool {
+	return len(l.Allow) == 0 && len(l.Deny) == 0
+}
+
+// allows reports whether the list allows the value. An empty value stands for
+// a value that cannot be determined statically, like $CMD, and is only
+// allowed by an empty list.
+func (l PolicyList) allows(value string, match func(pattern, value string) bool) bool {
+	if l.empty() {
+		return true
+	}
+	if value == "" {
+		return false
+	}
+	for _, p := range l.Deny {
+		if match(p, value) {
+			return false
+		}
+	}
+	if len(l.Allow) == 0 {
+		return true
+	}
+	for _, p := range l.Allow {
+		if match(p, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob reports whether value matches a glob pattern in which * matches
+// any sequence of characters and ? matches any single character.
+func matchGlob(pattern, value string) bool {
+	re := regexp.QuoteMeta(pattern)
+	re = strings.ReplaceAll(re, `\*`, ".*")
+	re = strings.ReplaceAll(re, `\?`, ".")
+	m, err := regexp.MatchString("^"+re+"$", value)
+	return err == nil && m
+}
+
+// matchExecutable matches patterns without a slash against the base name of
+// an executable, so that denying aws also denies /usr/local/bin/aws.
+func matchExecutable(pattern, value string) bool {
+	if !strings.Contains(pattern, "/") {
+		value = path.Base(value)
+	}
+	return matchGlob(pattern, value)
+}
+
+// matchScript matches a pattern against the cleaned path of a script, so that
+// /scripts/* does not allow /scripts/../etc/script.sh.
+func matchScript(pattern, value string) bool {
+	return matchGlob(pattern, path.Clean(value))
+}
+
+// Policy names of environment variable sources.
+const (
+	envSourceValue     = "Value"
+	envSourceFieldRef  = "FieldRef"
+	envSourceEnvVarRef = "EnvVarRef"
+	envSourceFileRef   = "FileRef"
+
+	envSourceContextKey    = "ContextKey"
+	envSourceCredentialRef = "CredentialRef"
+)
+
+// legacyEnvSources are the policy names of the environment variable sources
+// of v1alpha1, and the names they are converted to.
+var legacyEnvSources = map[string]string{
+	"ValueRef:":        envSourceFieldRef + ":",
+	"ShellEnvVarsRef:": envSourceEnvVarRef + ":",
+}
+
+// matchEnvSource matches a pattern against the policy name of an environment
+// variable source, reading v1alpha1 patterns as their v1beta1 equivalent.
+func matchEnvSource(pattern, value string) bool {
+	return matchGlob(envSourcePattern(pattern), value)
+}
+
+// envSourcePattern returns the v1beta1 equivalent of a v1alpha1 env source
+// pattern, or the pattern itself.
+func envSourcePattern(pattern string) string {
+	for old, src := range legacyEnvSources {
+		if strings.HasPrefix(pattern, old) {
+			return src + strings.TrimPrefix(pattern, old)
+		}
+	}
+	return pattern
+}
+
+// restrictsEnvVarRefs reports whether the list may deny an EnvVarRef source,
+// that is whether a Deny pattern may match one, or Allow is set and has no
+// pattern that matches them all.
+func (l PolicyList) restrictsEnvVarRefs() bool {
+	prefix := envSourceEnvVarRef + ":"
+	for _, p := range l.Deny {
+		p = envSourcePattern(p)
+		i := strings.IndexAny(p, "*?")
+		if i < 0 {
+			if strings.HasPrefix(p, prefix) {
+				return true
+			}
+			continue
+		}
+		if strings.HasPrefix(p[:i], prefix) || strings.HasPrefix(prefix, p[:i]) {
+			return true
+		}
+	}
+	if len(l.Allow) == 0 {
+		return false
+	}
+	for _, p := range l.Allow {
+		p = envSourcePattern(p)
+		if i := strings.IndexAny(p, "*?"); i >= 0 && p[i:] == "*" && strings.HasPrefix(prefix, p[:i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// envSource returns the policy name of the source of an environment variable.
+func envSource(ev v1beta1.EnvVar) string {
+	if ev.FieldRef != nil {
+		return envSourceFieldRef + ":" + ev.FieldRef.Path
+	}
+	return envSourceValue
+}
+
+// fileSource returns the policy name of the source of a file. Files share the
+// policy names of environment variables, so that a source denied to one isn't
+// allowed to the other.
+func fileSource(f v1beta1.File) string {
+	switch f.GetType() {
+	case v1beta1.FileTypeFieldRef:
+		if f.FieldRef != nil {
+			return envSourceFieldRef + ":" + f.FieldRef.Path
+		}
+	case v1beta1.FileTypeContextKey:
+		return envSourceContextKey + ":" + f.ContextKey
+	case v1beta1.FileTypeCredential:
+		if f.CredentialRef != nil {
+			return envSourceCredentialRef + ":" + f.CredentialRef.Name
+		}
+	}
+	return envSourceValue
+}
+
+// A commandCall is a simple command found in a shell command line.
+type commandCall struct {
+	// name of the executable, or an empty string if it is not a literal.
+	name string
+	// interpreter is true if the executable is a known interpreter.
+	interpreter bool
+	// scripts run by the command. An empty string stands for a script
+	// path that is not a literal.
+	scripts []string
+}
+
+// parseCalls returns every simple command in a shell command line, including
+// those nested in command substitutions, subshells, wrappers like env and the
+// inline code of shells like sh -c.
+func parseCalls(shellCmd string) ([]commandCall, error) {
+	f, err := syntax.NewParser().Parse(strings.NewReader(shellCmd), "")
+	if err != nil {
+		return nil, err
+	}
+
+	var calls []commandCall
+	var walkErr error
+	syntax.Walk(f, func(n syntax.Node) bool {
+		ce, ok := n.(*syntax.CallExpr)
+		if !ok || len(ce.Args) == 0 {
+			return true
+		}
+		args := make([]string, len(ce.Args))
+		for i, w := range ce.Args {
+			args[i] = literal(w)
+		}
+		cs, err := callsOf(args)
+		if err != nil {
+			walkErr = err
+			return false
+		}
+		calls = append(calls, cs...)
+		return true
+	})
+	return calls, walkErr
+}
+
+// callsOf returns the commandCall for the arguments of a simple command, and
+// those of any command it runs.
+func callsOf(args []string) ([]commandCall, error) {
+	var calls []commandCall
+	c := commandCall{name: args[0]}
+	base := path.Base(c.name)
+
+	switch {
+	case c.name == "":
+	case c.name == "." || c.name == "source":
+		if len(args) > 1 {
+			c.scripts = append(c.scripts, args[1])
+		}
+	case strings.Contains(c.name, "/"):
+		c.scripts = append(c.scripts, c.name)
+	}
+
+	if w, ok := wrappers[base]; ok && c.name != "" {
+		wrapped, ok := w.command(args[1:])
+		switch {
+		case !ok:
+			// We can't tell what the wrapper runs.
+			calls = append(calls, commandCall{})
+		case len(wrapped) > 0:
+			nested, err := callsOf(wrapped)
+			if err != nil {
+				return nil, err
+			}
+			calls = append(calls, nested...)
+		}
+	}
+	if c.name != "" && (slices.Contains(launchers, base) || (base == "find" && slices.ContainsFunc(args[1:], func(a string) bool {
+		return a == "" || slices.Contains(findActions, a)
+	}))) {
+		calls = append(calls, commandCall{})
+	}
+
+	for _, i := range interpreters {
+		if c.name != "" && matchGlob(i, base) {
+			c.interpreter = true
+		}
+	}
+	if c.interpreter {
+		script, inline, ok := interpreterScript(args[1:])
+		switch {
+		case !ok:
+			// The interpreter reads its code from stdin.
+		case inline && slices.Contains(shells, base):
+			if script == "" {
+				// We can't tell what the inline code runs.
+				calls = append(calls, commandCall{})
+				break
+			}
+			nested, err := parseCalls(script)
+			if err != nil {
+				return nil, err
+			}
+			calls = append(calls, nested...)
+		case !inline:
+			c.scripts = append(c.scripts, script)
+		}
+	}
+
+	return append(calls, c), nil
+}
+
+// command returns the arguments of the command the wrapper runs, which are
+// empty if it runs none. It returns false if the command can't be
+// determined, like when an option isn't a literal.
+func (w wrapper) command(args []string) ([]string, bool) {
+	operands := w.operands
+	options := true
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case a == "":
+			return nil, false
+		case options && a == "--":
+			options = false
+		case options && strings.HasPrefix(a, "-") && a != "-":
+			for _, o := range w.unknownOptions {
+				if strings.HasPrefix(a, o) {
+					return nil, false
+				}
+			}
+			// The value of a short option may follow a cluster of
+			// short options, like the -u of env -iu NAME.
+			o := a
+			if !strings.HasPrefix(a, "--") && len(a) > 2 {
+				o = "-" + a[len(a)-1:]
+			}
+			if slices.Contains(w.valueOptions, o) {
+				i++
+			}
+		case w.assignments && strings.Contains(a, "="):
+		case operands > 0:
+			operands--
+		default:
+			return args[i:], true
+		}
+	}
+	return nil, true
+}
+
+// interpreterScript returns the script an interpreter runs, and whether it is
+// inline code passed with -c or -e rather than a path. It returns false if the
+// interpreter runs no script, in which case it reads code from stdin.
+func interpreterScript(args []string) (string, bool, bool) {
+	for i, a := range args {
+		switch {
+		case a == "-c" || a == "-e":
+			if i+1 < len(args) {
+				return args[i+1], true, true
+			}
+			return "", true, true
+		case a == "" || !strings.HasPrefix(a, "-"):
+			return a, false, true
+		}
+	}
+	return "", false, false
+}
+
+// literal returns the value of a shell word that consists only of literal and
+// quoted parts, with its escapes and quotes removed the way the shell removes
+// them, or an empty string if it contains expansions.
+func literal(w *syntax.Word) string {
+	for _, p := range w.Parts {
+		switch p := p.(type) {
+		case *syntax.Lit, *syntax.SglQuoted:
+		case *syntax.DblQuoted:
+			for _, dp := range p.Parts {
+				if _, ok := dp.(*syntax.Lit); !ok {
+					return ""
+				}
+			}
+		default:
+			return ""
+		}
+	}
+	// The word has no expansions, so expanding it only removes its escapes
+	// and quotes, like \aws or a\ws.
+	fields, err := expand.Fields(nil, w)
+	if err != nil || len(fields) != 1 {
+		return ""
+	}
+	return fields[0]
+}
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/crossplane-contrib/function-shell/input/v1beta1"
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/crossplane/function-sdk-go/resource/composite"
+)
+
+func TestPolicyValidate(t *testing.T) {
+	xr := func(apiVersion, kind string) *resource.Composite {
+		c := &resource.Composite{Resource: composite.New()}
+		c.Resource.SetAPIVersion(apiVersion)
+		c.Resource.SetKind(kind)
+		return c
+	}
+
+	type args struct {
+		policy *Policy
+		in     *v1beta1.Parameters
+		oxr    *resource.Composite
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   *field.Error
+	}{
+		"NilPolicy": {
+			reason: "A nil policy should allow any command.",
+			args: args{
+				in: &v1beta1.Parameters{Command: v1beta1.Command{Inline: "aws s3 ls"}},
+			},
+		},
+		"ExecutableAllowed": {
+			reason: "Every executable in a pipeline should be allowed if it matches an allow pattern.",
+			args: args{
+				policy: &Policy{Rules: []PolicyRule{{Executables: PolicyList{Allow: []string{"echo", "jq"}}}}},
+				in:     &v1beta1.Parameters{Command: v1beta1.Command{Inline: "echo '{}' | jq ."}},
+			},
+		},
+		"ExecutableNotAllowed": {
+			reason: "An executable that matches no allow pattern should be denied.",
+			args: args{
+				policy: &Policy{Rules: []PolicyRule{{Executables: PolicyList{Allow: []string{"echo"}}}}},
+				in:     &v1beta1.Parameters{Command: v1beta1.Command{Inline: "echo $(curl -s http://example.org)"}},
+			},
+			want: field.Forbidden(field.NewPath("parameters", "command", "inline"), `executable "curl" is not allowed by policy`),
+		},
+		"ExecutableDeniedByPath": {
+			reason: "A denied executable should be denied when it is run by its full path.",
+			args: args{
+				policy: &Policy{Rules: []PolicyRule{{Executables: PolicyList{Deny: []string{"aws"}}}}},
+				in:     &v1beta1.Parameters{Command: v1beta1.Command{Inline: "/usr/local/bin/aws s3 ls"}},
+			},
+			want: field.Forbidden(field.NewPath("parameters", "command", "inline"), `executable "/usr/local/bin/aws" is not allowed by policy`),
+		},
+		"ExecutableDeniedInWrapper": {
+			reason: "A denied executable should be denied when it is run by a wrapper like env.",
+			args: args{
+				policy: &Policy{Rules: []PolicyRule{{Executables: PolicyList{Deny: []string{"aws"}}}}},
+				in:     &v1beta1.Parameters{Command: v1beta1.Command{Inline: "env AWS_REGION=us-east-1 aws s3 ls"}},
+			},
+			want: field.Forbidden(field.NewPath("parameters", "command", "inline"), `executable "aws" is not allowed by policy`),
+		},
+		"ExecutableDeniedInInlineShell": {
+			reason: "A denied executable should be denied when it is run by the inline code of a shell.",
+			args: args{
+				policy: &Policy{Rules: []PolicyRule{{Executables: PolicyList{Deny: []string{"aws"}}}}},
+				in:     &v1beta1.Parameters{Command: v1beta1.Command{Inline: `bash -c "aws s3 ls"`}},
+			},
+			want: field.Forbidden(field.NewPath("parameters", "command", "inline"), `executable "aws" is not allowed by policy`),
+		},
+		"ExecutableDeniedWithEscapes": {
+			reason: "A denied executable should be denied when its name contains backslash escapes, which the shell removes.",
+			args: args{
+				policy: &Policy{Rules: []PolicyRule{{Executables: PolicyList{Deny: []string{"aws"}}}}},
+				in:     &v1beta1.Parameters{Command: v1beta1.Command{Inline: `echo hello; a\ws s3 ls`}},
+			},
+			want: field.Forbidden(field.NewPath("parameters", "command", "inline"), `executable "aws" is not allowed by policy`),
+		},
+		"ExecutableInEnvValueNotRun": {
+			reason: "A command substitution in the value of an environment variable should be allowed, because values are passed to the command as is and never run.",
+			args: args{
+				policy: &Policy{Rules: []PolicyRule{{Executables: PolicyList{Deny: []string{"aws"}}}}},
+				in: &v1beta1.Parameters{
+					Command: v1beta1.Command{Inline: `echo "$IDENTITY"`},
+					Env: v1beta1.Env{Vars: []v1beta1.EnvVar{
+						{Name: "IDENTITY", Value: "$(aws sts get-caller-identity)"},
+					}},
+				},
+			},
+		},
+		"DynamicExecutableDenied": {
+			reason: "An executable that cannot be determined statically should be denied by a non-empty list.",
+			args: args{
+				policy: &Policy{Rules: []PolicyRule{{Executables: PolicyList{Deny: []string{"aws"}}}}},
+				in:     &v1beta1.Parameters{Command: v1beta1.Command{Inline: "$CMD s3 ls"}},
+			},
+			want: field.Forbidden(field.NewPath("parameters", "command", "inline"), `executable "" is not allowed by policy`),
+		},
+		"InterpreterNotAllowed": {
+			reason: "An interpreter that matches no allow pattern should be denied.",
+			args: args{
+				policy: &Policy{Rules: []PolicyRule{{Interpreters: PolicyList{Allow: []string{"bash"}}}}},
+				in:     &v1beta1.Parameters{Command: v1beta1.Command{Inline: "python3 -c 'print(1)'"}},
+			},
+			want: field.Forbidden(field.NewPath("parameters", "command", "inline"), `interpreter "python3" is not allowed by policy`),
+		},
+		"ScriptRefAllowed": {
+			reason: "A script run by an interpreter should be allowed if it matches an allow pattern.",
+			args: args{
+				policy: &Policy{Rules: []PolicyRule{{ScriptRefs: PolicyList{Allow: []string{"/scripts/*"}}}}},
+				in:     &v1beta1.Parameters{Command: v1beta1.Command{Inline: "python3 -u /scripts/lookup.py --region us-east-1"}},
+			},
+		},
+		"ScriptRefNotAllowed": {
+			reason: "A script that escapes an allowed directory should be denied.",
+			args: args{
+				policy: &Policy{Rules: []PolicyRule{{ScriptRefs: PolicyList{Allow: []string{"/scripts/*"}}}}},
+				in:     &v1beta1.Parameters{Command: v1beta1.Command{Inline: "/scripts/../tmp/evil.sh"}},
+			},
+			want: field.Forbidden(field.NewPath("parameters", "command", "inline"), `script "/scripts/../tmp/evil.sh" is not allowed by policy`),
+		},
+		"EnvSourceNotAllowed": {
+			reason: "An environment variable populated from a source that is not allowed should be denied.",
+			args: args{
+				policy: &Policy{Rules: []PolicyRule{{EnvSources: PolicyList{Allow: []string{"Value", "FieldRef:spec.*"}}}}},
+				in: &v1beta1.Parameters{
+					Command: v1beta1.Command{Inline: "echo $A $B"},
+					Env: v1beta1.Env{Vars: []v1beta1.EnvVar{
+						{Name: "A", FieldRef: &v1beta1.FieldRef{Path: "spec.a"}},
+						{Name: "B", FieldRef: &v1beta1.FieldRef{Path: "context[apiextensions.crossplane.io/environment].b"}},
+					}},
+				},
+			},
+			want: field.Forbidden(field.NewPath("parameters", "env", "vars").Index(1), `env source "FieldRef:context[apiextensions.crossplane.io/environment].b" is not allowed by policy`),
+		},
+		"EnvVarRefNotAllowed": {
+			reason: "Environment variables loaded from a pod environment variable that is not allowed should be denied.",
+			args: args{
+				policy: &Policy{Rules: []PolicyRule{{EnvSources: PolicyList{Deny: []string{"EnvVarRef:AWS_*"}}}}},
+				in: &v1beta1.Parameters{
+					Command: v1beta1.Command{Inline: "echo $KEY"},
+					Env: v1beta1.Env{From: []v1beta1.EnvFromSource{
+						{EnvVarRef: &v1beta1.EnvVarRef{Name: "AWS_CREDENTIALS", Keys: []string{"KEY"}}},
+					}},
+				},
+			},
+			want: field.Forbidden(field.NewPath("parameters", "env", "from").Index(0).Child("envVarRef"), `env source "EnvVarRef:AWS_CREDENTIALS" is not allowed by policy`),
+		},
+		"EnvFromFieldRefNotAllowed": {
+			reason: "Environment variables imported from a field path that is not allowed should be denied.",
+			args: args{
+				policy: &Policy{Rules: []PolicyRule{{EnvSources: PolicyList{Deny: []string{"FieldRef:spec.secret*"}}}}},
+				in: &v1beta1.Parameters{
+					Command: v1beta1.Command{Inline: "env"},
+					Env: v1beta1.Env{From: []v1beta1.EnvFromSource{
+						{FieldRef: &v1beta1.EnvFromFieldRef{Path: "spec.secrets"}},
+					}},
+				},
+			},
+			want: field.Forbidden(field.NewPath("parameters", "env", "from").Index(0).Child("fieldRef"), `env source "FieldRef:spec.secrets" is not allowed by policy`),
+		},
+		"EnvFileRefNotAllowed": {
+			reason: "Environment variables loaded from a file that is not allowed should be denied.",
+			args: args{
+				policy: &Policy{Rules: []PolicyRule{{EnvSources: PolicyList{Allow: []string{"FileRef:/var/run/secrets/app/*"}}}}},
+				in: &v1beta1.Parameters{
+					Command: v1beta1.Command{Inline: "env"},
+					Env: v1beta1.Env{From: []v1beta1.EnvFromSource{
+						{FileRef: &v1beta1.EnvFileRef{Path: "/var/run/secrets/aws"}},
+					}},
+				},
+			},
+			want: field.Forbidden(field.NewPath("parameters", "env", "from").Index(0).Child("fileRef"), `env source "FileRef:/var/run/secrets/aws" is not allowed by policy`),
+		},
+		"LegacyEnvSourceNotAllowed": {
+			reason: "Patterns of v1alpha1 env sources should match their v1beta1 equivalent.",
+			args: args{
+				policy: &Policy{Rules: []PolicyRule{{EnvSources: PolicyList{Deny: []string{"ShellEnvVarsRef:AWS_*", "ValueRef:spec.secret*"}}}}},
+				in: &v1beta1.Parameters{
+					Command: v1beta1.Command{Inline: "echo $KEY"},
+					Env: v1beta1.Env{Vars: []v1beta1.EnvVar{
+						{Name: "KEY", FieldRef: &v1beta1.FieldRef{Path: "spec.secretKey", Policy: v1beta1.FieldRefPolicyRequired}},
+					}},
+				},
+			},
+			want: field.Forbidden(field.NewPath("parameters", "env", "vars").Index(0), `env source "FieldRef:spec.secretKey" is not allowed by policy`),
+		},
+		"FileFieldRefNotAllowed": {
+			reason: "A file populated from a field path that is not allowed as an env source should be denied too.",
+			args: args{
+				policy: &Policy{Rules: []PolicyRule{{EnvSources: PolicyList{Deny: []string{"FieldRef:spec.secret*"}}}}},
+				in: &v1beta1.Parameters{
+					Command: v1beta1.Command{Inline: "cat key"},
+					Files: []v1beta1.File{
+						{Path: "config", Value: "{}"},
+						{Path: "key", FieldRef: &v1beta1.FieldRef{Path: "spec.secretKey"}},
+					},
+				},
+			},
+			want: field.Forbidden(field.NewPath("parameters", "files").Index(1), `file source "FieldRef:spec.secretKey" is not allowed by policy`),
+		},
+		"FileContextKeyNotAllowed": {
+			reason: "A file populated from a context key that matches no allow pattern should be denied.",
+			args: args{
+				policy: &Policy{Rules: []PolicyRule{{EnvSources: PolicyList{Allow: []string{"Value", "FieldRef:spec.*"}}}}},
+				in: &v1beta1.Parameters{
+					Command: v1beta1.Command{Inline: "cat env.json"},
+					Files:   []v1beta1.File{{Path: "env.json", ContextKey: "apiextensions.crossplane.io/environment"}},
+				},
+			},
+			want: field.Forbidden(field.NewPath("parameters", "files").Index(0), `file source "ContextKey:apiextensions.crossplane.io/environment" is not allowed by policy`),
+		},
+		"FileCredentialRefNotAllowed": {
+			reason: "A file populated from a credential that is denied should be denied.",
+			args: args{
+				policy: &Policy{Rules: []PolicyRule{{EnvSources: PolicyList{Deny: []string{"CredentialRef:aws-*"}}}}},
+				in: &v1beta1.Parameters{
+					Command: v1beta1.Command{Inline: "aws s3 ls"},
+					Files: []v1beta1.File{
+						{Path: "credentials", CredentialRef: &v1beta1.CredentialRef{Name: "aws-creds", Key: "credentials"}},
+					},
+				},
+			},
+			want: field.Forbidden(field.NewPath("parameters", "files").Index(0), `file source "CredentialRef:aws-creds" is not allowed by policy`),
+		},
+		"FileSourcesAllowed": {
+			reason: "Files populated from allowed sources should be allowed.",
+			args: args{
+				policy: &Policy{Rules: []PolicyRule{{EnvSources: PolicyList{Allow: []string{"Value", "FieldRef:spec.*", "CredentialRef:aws-creds"}}}}},
+				in: &v1beta1.Parameters{
+					Command: v1beta1.Command{Inline: "aws s3 ls"},
+					Files: []v1beta1.File{
+						{Path: "config", Value: "[default]"},
+						{Path: "bucket", FieldRef: &v1beta1.FieldRef{Path: "spec.bucket"}},
+						{Path: "credentials", CredentialRef: &v1beta1.CredentialRef{Name: "aws-creds", Key: "credentials"}},
+					},
+				},
+			},
+		},
+		"RuleDoesNotMatchKind": {
+			reason: "A rule scoped to another kind should not apply.",
+			args: args{
+				policy: &Policy{Rules: []PolicyRule{{
+					Match:       PolicyMatch{APIVersion: "example.org/*", Kind: "XBucket"},
+					Executables: PolicyList{Deny: []string{"aws"}},
+				}}},
+				in:  &v1beta1.Parameters{Command: v1beta1.Command{Inline: "aws s3 ls"}},
+				oxr: xr("example.org/v1", "XDatabase"),
+			},
+		},
+		"RuleMatchesKind": {
+			reason: "A rule scoped to the kind of the composite resource should apply.",
+			args: args{
+				policy: &Policy{Rules: []PolicyRule{{
+					Match:       PolicyMatch{APIVersion: "example.org/*", Kind: "XBucket"},
+					Executables: PolicyList{Deny: []string{"aws"}},
+				}}},
+				in:  &v1beta1.Parameters{Command: v1beta1.Command{Inline: "aws s3 ls"}},
+				oxr: xr("example.org/v1", "XBucket"),
+			},
+			want: field.Forbidden(field.NewPath("parameters", "command", "inline"), `executable "aws" is not allowed by policy`),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := tc.args.policy.Validate(tc.args.in, tc.args.oxr)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("%s\nValidate(...): -want err, +got err:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestPolicyValidateCommand(t *testing.T) {
+	type args struct {
+		policy   *Policy
+		shellCmd string
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   *field.Error
+	}{
+		"NilPolicy": {
+			reason: "A nil policy should allow everything.",
+			args:   args{shellCmd: "aws s3 ls"},
+		},
+		"Allowed": {
+			reason: "A command read from a field that the policy allows should be valid.",
+			args: args{
+				policy:   &Policy{Rules: []PolicyRule{{Executables: PolicyList{Deny: []string{"aws"}}}}},
+				shellCmd: "echo hello",
+			},
+		},
+		"Denied": {
+			reason: "A command read from a field that the policy denies should be forbidden.",
+			args: args{
+				policy:   &Policy{Rules: []PolicyRule{{Executables: PolicyList{Deny: []string{"aws"}}}}},
+				shellCmd: "echo hello | aws s3 cp - s3://bucket/hello",
+			},
+			want: field.Forbidden(field.NewPath("parameters", "command", "fieldRef"), `executable "aws" is not allowed by policy`),
+		},
+		"DeniedInWrapperWithOptionValues": {
+			reason: "A denied executable should be denied when it is run by a wrapper with options that take a value.",
+			args: args{
+				policy:   &Policy{Rules: []PolicyRule{{Executables: PolicyList{Deny: []string{"aws"}}}}},
+				shellCmd: `nice -n 5 aws --version; env -iu FOO aws s3 ls; exec -a x aws`,
+			},
+			want: field.Forbidden(field.NewPath("parameters", "command", "fieldRef"), `executable "aws" is not allowed by policy`),
+		},
+		"DeniedInTimeout": {
+			reason: "A denied executable should be denied when it is run by timeout, after its duration.",
+			args: args{
+				policy:   &Policy{Rules: []PolicyRule{{Executables: PolicyList{Deny: []string{"aws"}}}}},
+				shellCmd: `timeout -s KILL 5s aws s3 ls`,
+			},
+			want: field.Forbidden(field.NewPath("parameters", "command", "fieldRef"), `executable "aws" is not allowed by policy`),
+		},
+		"DeniedInStdbuf": {
+			reason: "A denied executable should be denied when it is run by stdbuf.",
+			args: args{
+				policy:   &Policy{Rules: []PolicyRule{{Executables: PolicyList{Deny: []string{"aws"}}}}},
+				shellCmd: `stdbuf -o L /usr/local/bin/aws s3 ls`,
+			},
+			want: field.Forbidden(field.NewPath("parameters", "command", "fieldRef"), `executable "/usr/local/bin/aws" is not allowed by policy`),
+		},
+		"UnknownInEval": {
+			reason: "A command run by eval can't be determined, and should be denied by a non-empty list.",
+			args: args{
+				policy:   &Policy{Rules: []PolicyRule{{Executables: PolicyList{Deny: []string{"aws"}}}}},
+				shellCmd: `eval "a""ws s3 ls"`,
+			},
+			want: field.Forbidden(field.NewPath("parameters", "command", "fieldRef"), `executable "" is not allowed by policy`),
+		},
+		"UnknownInXargs": {
+			reason: "A command run by xargs can't be determined, and should be denied by a non-empty list.",
+			args: args{
+				policy:   &Policy{Rules: []PolicyRule{{Executables: PolicyList{Deny: []string{"aws"}}}}},
+				shellCmd: `echo s3 | xargs aws`,
+			},
+			want: field.Forbidden(field.NewPath("parameters", "command", "fieldRef"), `executable "" is not allowed by policy`),
+		},
+		"UnknownInFindExec": {
+			reason: "A command run by find -exec can't be determined, and should be denied by a non-empty list.",
+			args: args{
+				policy:   &Policy{Rules: []PolicyRule{{Executables: PolicyList{Deny: []string{"aws"}}}}},
+				shellCmd: `find . -name '*.json' -exec aws s3 cp {} s3://bucket \;`,
+			},
+			want: field.Forbidden(field.NewPath("parameters", "command", "fieldRef"), `executable "" is not allowed by policy`),
+		},
+		"UnknownInEnvSplitString": {
+			reason: "A command run by env -S can't be determined, and should be denied by a non-empty list.",
+			args: args{
+				policy:   &Policy{Rules: []PolicyRule{{Executables: PolicyList{Deny: []string{"aws"}}}}},
+				shellCmd: `env -S'aws s3 ls'`,
+			},
+			want: field.Forbidden(field.NewPath("parameters", "command", "fieldRef"), `executable "" is not allowed by policy`),
+		},
+		"AllowedInWrapper": {
+			reason: "A wrapper or launcher that runs no denied executable should be allowed.",
+			args: args{
+				policy:   &Policy{Rules: []PolicyRule{{Executables: PolicyList{Deny: []string{"aws"}}}}},
+				shellCmd: "nice -n 5 echo hello; find . -name '*.json'",
+			},
+		},
+		"DeniedWithEscapes": {
+			reason: "A command read from a field that runs a denied executable with a backslash escape should be forbidden.",
+			args: args{
+				policy:   &Policy{Rules: []PolicyRule{{Executables: PolicyList{Deny: []string{"aws"}}}}},
+				shellCmd: `\aws s3 ls`,
+			},
+			want: field.Forbidden(field.NewPath("parameters", "command", "fieldRef"), `executable "aws" is not allowed by policy`),
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := tc.args.policy.ValidateCommand(tc.args.shellCmd, nil)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("%s\nValidateCommand(...): -want err, +got err:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
+
+func TestPolicyIsolatesEnv(t *testing.T) {
+	cases := map[string]struct {
+		reason string
+		policy *Policy
+		want   bool
+	}{
+		"NilPolicy": {
+			reason: "A nil policy should not isolate the environment.",
+		},
+		"NoEnvSources": {
+			reason: "A policy that doesn't restrict env sources should not isolate the environment.",
+			policy: &Policy{Rules: []PolicyRule{{Executables: PolicyList{Deny: []string{"aws"}}}}},
+		},
+		"DeniesFieldRefs": {
+			reason: "A policy that only restricts other env sources should not isolate the environment.",
+			policy: &Policy{Rules: []PolicyRule{{EnvSources: PolicyList{Deny: []string{"FieldRef:spec.secret*"}}}}},
+		},
+		"AllowsAllEnvVarRefs": {
+			reason: "A policy that allows every EnvVarRef should not isolate the environment.",
+			policy: &Policy{Rules: []PolicyRule{{EnvSources: PolicyList{Allow: []string{"Value", "EnvVarRef:*"}}}}},
+		},
+		"DeniesEnvVarRef": {
+			reason: "A policy that denies an EnvVarRef should isolate the environment.",
+			policy: &Policy{Rules: []PolicyRule{{EnvSources: PolicyList{Deny: []string{"EnvVarRef:AWS_*"}}}}},
+			want:   true,
+		},
+		"DeniesLegacyEnvVarRef": {
+			reason: "A policy that denies a v1alpha1 ShellEnvVarsRef should isolate the environment.",
+			policy: &Policy{Rules: []PolicyRule{{EnvSources: PolicyList{Deny: []string{"ShellEnvVarsRef:AWS_SECRET_ACCESS_KEY"}}}}},
+			want:   true,
+		},
+		"DeniesEverything": {
+			reason: "A policy that denies every env source should isolate the environment.",
+			policy: &Policy{Rules: []PolicyRule{{EnvSources: PolicyList{Deny: []string{"*"}}}}},
+			want:   true,
+		},
+		"AllowsSomeEnvVarRefs": {
+			reason: "A policy that allows only some EnvVarRefs should isolate the environment.",
+			policy: &Policy{Rules: []PolicyRule{{EnvSources: PolicyList{Allow: []string{"Value", "EnvVarRef:DATADOG_*"}}}}},
+			want:   true,
+		},
+		"RuleDoesNotMatch": {
+			reason: "A rule scoped to another kind of composite resource should not isolate the environment.",
+			policy: &Policy{Rules: []PolicyRule{{
+				Match:      PolicyMatch{Kind: "XDatabase"},
+				EnvSources: PolicyList{Deny: []string{"EnvVarRef:AWS_*"}},
+			}}},
+		},
+	}
+
+	oxr := &resource.Composite{Resource: composite.New()}
+	oxr.Resource.SetKind("XBucket")
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			got := tc.policy.IsolatesEnv(oxr)
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("%s\nIsolatesEnv(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
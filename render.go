@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	"github.com/crossplane/crossplane-runtime/v2/pkg/meta"
+	"google.golang.org/protobuf/types/known/structpb"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	utilyaml "k8s.io/apimachinery/pkg/util/yaml"
+	"sigs.k8s.io/yaml"
+
+	"github.com/crossplane/function-sdk-go"
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+)
+
+// annotationCompositionResourceName is the annotation of an observed composed
+// resource that names it, like crossplane render expects.
+const annotationCompositionResourceName = "crossplane.io/composition-resource-name"
+
+// RenderCmd runs the function locally against files.
+type RenderCmd struct {
+	Input     string `arg:"" help:"YAML file of the Parameters input of the function." type:"existingfile"`
+	Composite string `arg:"" help:"YAML file of the observed composite resource."      type:"existingfile"`
+
+	Context           string        `help:"YAML or JSON file of the pipeline context, an object whose keys are context keys."                                 type:"existingfile"`
+	ObservedResources string        `help:"YAML file of observed composed resources. Every resource must have a crossplane.io/composition-resource-name annotation."                       type:"existingfile"`
+	DesiredResources  string        `help:"YAML file of composed resources desired by earlier functions. Every resource must have a crossplane.io/composition-resource-name annotation." type:"existingfile"`
+	Timeout           time.Duration `default:"1m"                                                                                                                                  help:"How long the function may run."`
+
+	FunctionFlags `embed:""`
+}
+
+// Run the function once, and print the desired composite resource, its
+// connection details, the desired composed resources, the results and the
+// context as a stream of YAML documents. It returns an error if the function returned a fatal result.
+func (c *RenderCmd) Run(cli *CLI) error {
+	log, err := function.NewLogger(cli.Debug)
+	if err != nil {
+		return err
+	}
+
+	f, err := c.function(log)
+	if err != nil {
+		return err
+	}
+
+	req, err := c.request()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	rsp, err := f.RunFunction(ctx, req)
+	if err != nil {
+		return errors.Wrap(err, "cannot run function")
+	}
+
+	if err := render(os.Stdout, rsp); err != nil {
+		return err
+	}
+
+	for _, r := range rsp.GetResults() {
+		if r.GetSeverity() == fnv1.Severity_SEVERITY_FATAL {
+			return errors.Errorf("function returned a fatal result: %s", r.GetMessage())
+		}
+	}
+	return nil
+}
+
+// request returns the RunFunctionRequest of the files of c.
+func (c *RenderCmd) request() (*fnv1.RunFunctionRequest, error) {
+	in, err := readObject(c.Input)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read input")
+	}
+	xr, err := readObject(c.Composite)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot read composite resource")
+	}
+
+	req := &fnv1.RunFunctionRequest{
+		Meta:     &fnv1.RequestMeta{Tag: "render"},
+		Input:    in,
+		Observed: &fnv1.State{Composite: &fnv1.Resource{Resource: xr}},
+	}
+
+	if c.Context != "" {
+		if req.Context, err = readObject(c.Context); err != nil {
+			return nil, errors.Wrap(err, "cannot read context")
+		}
+	}
+
+	if c.ObservedResources != "" {
+		ocds, err := readComposedResources(c.ObservedResources)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot read observed resources")
+		}
+		req.Observed.Resources = ocds
+	}
+
+	if c.DesiredResources != "" {
+		dcds, err := readComposedResources(c.DesiredResources)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot read desired resources")
+		}
+		req.Desired = &fnv1.State{Resources: dcds}
+	}
+
+	return req, nil
+}
+
+// readObject reads a YAML or JSON object from a file.
+func readObject(filename string) (*structpb.Struct, error) {
+	b, err := os.ReadFile(filename) //nolint:gosec // The file is supplied by the user.
+	if err != nil {
+		return nil, err
+	}
+	j, err := yaml.YAMLToJSON(b)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot parse %s", filename)
+	}
+	s := &structpb.Struct{}
+	return s, errors.Wrapf(s.UnmarshalJSON(j), "cannot parse %s as an object", filename)
+}
+
+// readComposedResources reads a stream of YAML documents of composed
+// resources, named by their composition resource name annotation.
+func readComposedResources(filename string) (map[string]*fnv1.Resource, error) {
+	b, err := os.ReadFile(filename) //nolint:gosec // The file is supplied by the user.
+	if err != nil {
+		return nil, err
+	}
+
+	ocds := map[string]*fnv1.Resource{}
+	r := utilyaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(b)))
+	for {
+		doc, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			return ocds, nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot read %s", filename)
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+
+		u := &unstructured.Unstructured{}
+		if err := yaml.Unmarshal(doc, &u.Object); err != nil {
+			return nil, errors.Wrapf(err, "cannot parse %s", filename)
+		}
+		if u.Object == nil {
+			continue
+		}
+		name := u.GetAnnotations()[annotationCompositionResourceName]
+		if name == "" {
+			return nil, errors.Errorf("%s %s has no %s annotation", u.GetKind(), u.GetName(), annotationCompositionResourceName)
+		}
+		s, err := structpb.NewStruct(u.Object)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot convert %s", name)
+		}
+		ocds[name] = &fnv1.Resource{Resource: s}
+	}
+}
+
+// render writes the desired composite resource, its connection details, the
+// desired composed resources, the results and the context of rsp to w as a
+// stream of YAML documents. Desired composed resources are annotated with
+// their name, like crossplane render does.
+func render(w io.Writer, rsp *fnv1.RunFunctionResponse) error {
+	var docs []any
+
+	if xr := rsp.GetDesired().GetComposite(); xr != nil {
+		docs = append(docs, xr.GetResource().AsMap())
+
+		if cd := xr.GetConnectionDetails(); len(cd) > 0 {
+			docs = append(docs, map[string]any{
+				"apiVersion": "v1",
+				"kind":       "Secret",
+				"metadata":   map[string]any{"name": "connection-details"},
+				"data":       cd,
+			})
+		}
+	}
+
+	dcds := rsp.GetDesired().GetResources()
+	for _, name := range slices.Sorted(maps.Keys(dcds)) {
+		u := &unstructured.Unstructured{Object: dcds[name].GetResource().AsMap()}
+		meta.AddAnnotations(u, map[string]string{annotationCompositionResourceName: name})
+		docs = append(docs, u.Object)
+	}
+
+	for _, r := range rsp.GetResults() {
+		docs = append(docs, map[string]any{
+			"apiVersion": "render.crossplane.io/v1beta1",
+			"kind":       "Result",
+			"severity":   r.GetSeverity().String(),
+			"message":    r.GetMessage(),
+		})
+	}
+
+	if ctx := rsp.GetContext(); len(ctx.GetFields()) > 0 {
+		docs = append(docs, map[string]any{
+			"apiVersion": "render.crossplane.io/v1beta1",
+			"kind":       "Context",
+			"fields":     ctx.AsMap(),
+		})
+	}
+
+	for _, d := range docs {
+		b, err := yaml.Marshal(d)
+		if err != nil {
+			return errors.Wrap(err, "cannot serialize output")
+		}
+		if _, err := fmt.Fprintf(w, "---\n%s", b); err != nil {
+			return errors.Wrap(err, "cannot write output")
+		}
+	}
+	return nil
+}
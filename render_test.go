@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/testing/protocmp"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+)
+
+func TestRenderRequest(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	c := &RenderCmd{
+		Input: write("input.yaml", `
+apiVersion: template.fn.crossplane.io/v1alpha1
+kind: Parameters
+shellCommand: echo hello
+`),
+		Composite: write("xr.yaml", `
+apiVersion: example.org/v1
+kind: XR
+metadata:
+  name: example
+`),
+		Context: write("context.json", `{"example.org/region": "us-east-1"}`),
+		ObservedResources: write("observed.yaml", `
+apiVersion: example.org/v1
+kind: Bucket
+metadata:
+  name: bucket
+  annotations:
+    crossplane.io/composition-resource-name: bucket
+---
+apiVersion: example.org/v1
+kind: Queue
+metadata:
+  name: queue
+  annotations:
+    crossplane.io/composition-resource-name: queue
+`),
+		DesiredResources: write("desired.yaml", `
+apiVersion: ec2.aws.upbound.io/v1beta1
+kind: Instance
+metadata:
+  annotations:
+    crossplane.io/composition-resource-name: instance
+`),
+	}
+
+	want := &fnv1.RunFunctionRequest{
+		Meta: &fnv1.RequestMeta{Tag: "render"},
+		Input: resource.MustStructJSON(`{
+			"apiVersion": "template.fn.crossplane.io/v1alpha1",
+			"kind": "Parameters",
+			"shellCommand": "echo hello"
+		}`),
+		Observed: &fnv1.State{
+			Composite: &fnv1.Resource{
+				Resource: resource.MustStructJSON(`{"apiVersion": "example.org/v1", "kind": "XR", "metadata": {"name": "example"}}`),
+			},
+			Resources: map[string]*fnv1.Resource{
+				"bucket": {Resource: resource.MustStructJSON(`{
+					"apiVersion": "example.org/v1",
+					"kind": "Bucket",
+					"metadata": {"name": "bucket", "annotations": {"crossplane.io/composition-resource-name": "bucket"}}
+				}`)},
+				"queue": {Resource: resource.MustStructJSON(`{
+					"apiVersion": "example.org/v1",
+					"kind": "Queue",
+					"metadata": {"name": "queue", "annotations": {"crossplane.io/composition-resource-name": "queue"}}
+				}`)},
+			},
+		},
+		Desired: &fnv1.State{
+			Resources: map[string]*fnv1.Resource{
+				"instance": {Resource: resource.MustStructJSON(`{
+					"apiVersion": "ec2.aws.upbound.io/v1beta1",
+					"kind": "Instance",
+					"metadata": {"annotations": {"crossplane.io/composition-resource-name": "instance"}}
+				}`)},
+			},
+		},
+		Context: resource.MustStructJSON(`{"example.org/region": "us-east-1"}`),
+	}
+
+	got, err := c.request()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, got, protocmp.Transform()); diff != "" {
+		t.Errorf("request(): -want, +got:\n%s", diff)
+	}
+}
+
+func TestRender(t *testing.T) {
+	rsp := &fnv1.RunFunctionResponse{
+		Desired: &fnv1.State{
+			Composite: &fnv1.Resource{
+				Resource:          resource.MustStructJSON(`{"apiVersion": "example.org/v1", "kind": "XR", "status": {"ready": true}}`),
+				ConnectionDetails: map[string][]byte{"token": []byte("s3cr3t")},
+			},
+			Resources: map[string]*fnv1.Resource{
+				"instance": {Resource: resource.MustStructJSON(`{"apiVersion": "ec2.aws.upbound.io/v1beta1", "kind": "Instance", "spec": {"forProvider": {"ami": "ami-1"}}}`)},
+			},
+		},
+		Results: []*fnv1.Result{
+			{Severity: fnv1.Severity_SEVERITY_NORMAL, Message: "shellCmd succeeded after 2 attempts"},
+		},
+		Context: resource.MustStructJSON(`{"example.org/region": "us-east-1"}`),
+	}
+
+	want := `---
+apiVersion: example.org/v1
+kind: XR
+status:
+  ready: true
+---
+apiVersion: v1
+data:
+  token: czNjcjN0
+kind: Secret
+metadata:
+  name: connection-details
+---
+apiVersion: ec2.aws.upbound.io/v1beta1
+kind: Instance
+metadata:
+  annotations:
+    crossplane.io/composition-resource-name: instance
+spec:
+  forProvider:
+    ami: ami-1
+---
+apiVersion: render.crossplane.io/v1beta1
+kind: Result
+message: shellCmd succeeded after 2 attempts
+severity: SEVERITY_NORMAL
+---
+apiVersion: render.crossplane.io/v1beta1
+fields:
+  example.org/region: us-east-1
+kind: Context
+`
+
+	buf := &bytes.Buffer{}
+	if err := render(buf, rsp); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("render(...): -want, +got:\n%s", diff)
+	}
+}
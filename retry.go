@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"regexp"
+	"slices"
+	"time"
+
+	"github.com/crossplane-contrib/function-shell/input/v1beta1"
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	"k8s.io/apimachinery/pkg/util/wait"
+)
+
+const (
+	defaultRetryAttempts       = 3
+	defaultRetryInitialBackoff = 1 * time.Second
+	defaultRetryMaxBackoff     = 30 * time.Second
+)
+
+// retrier re-runs a shell command according to a v1beta1.Retry.
+type retrier struct {
+	backoff        wait.Backoff
+	exitCodes      []int
+	stderrPatterns []*regexp.Regexp
+}
+
+// newRetrier returns a retrier for the supplied Retry. A nil Retry runs the
+// shell command exactly once.
+func newRetrier(r *v1beta1.Retry) (*retrier, error) {
+	if r == nil {
+		return &retrier{backoff: wait.Backoff{Steps: 1}}, nil
+	}
+
+	rt := &retrier{
+		backoff: wait.Backoff{
+			Steps:    defaultRetryAttempts,
+			Duration: defaultRetryInitialBackoff,
+			Cap:      defaultRetryMaxBackoff,
+			Factor:   2,
+			Jitter:   0.1,
+		},
+		exitCodes: r.RetryableExitCodes,
+	}
+	if r.Attempts > 0 {
+		rt.backoff.Steps = r.Attempts
+	}
+	if r.InitialBackoff != "" {
+		d, err := time.ParseDuration(r.InitialBackoff)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot parse initialBackoff")
+		}
+		rt.backoff.Duration = d
+	}
+	if r.MaxBackoff != "" {
+		d, err := time.ParseDuration(r.MaxBackoff)
+		if err != nil {
+			return nil, errors.Wrap(err, "cannot parse maxBackoff")
+		}
+		rt.backoff.Cap = d
+	}
+	for _, p := range r.RetryableStderrPatterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, errors.Wrapf(err, "cannot compile retryableStderrPattern %q", p)
+		}
+		rt.stderrPatterns = append(rt.stderrPatterns, re)
+	}
+	return rt, nil
+}
+
+// retryable reports whether a failed shell command should be retried.
+func (rt *retrier) retryable(r commandResult) bool {
+	code := r.exitCode()
+	if code <= 0 {
+		// The command succeeded, or was killed or could not be started.
+		return false
+	}
+	if len(rt.exitCodes) == 0 && len(rt.stderrPatterns) == 0 {
+		// A sandbox that could not be set up won't be on the next attempt.
+		return code != sandboxExitCode
+	}
+	if slices.Contains(rt.exitCodes, code) {
+		return true
+	}
+	for _, re := range rt.stderrPatterns {
+		if re.MatchString(r.stderr) {
+			return true
+		}
+	}
+	return false
+}
+
+// run calls fn until it succeeds, fails with an error that is not retryable,
+// the attempts are exhausted or ctx is done. It returns the result of the
+// last attempt and the number of attempts made. A retry is skipped if its
+// backoff would end after the deadline of ctx.
+func (rt *retrier) run(ctx context.Context, fn func(context.Context) commandResult) (commandResult, int) {
+	b := rt.backoff
+	attempts := 0
+	for {
+		r := fn(ctx)
+		attempts++
+		if attempts >= rt.backoff.Steps || !rt.retryable(r) {
+			return r, attempts
+		}
+
+		d := b.Step()
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(d).After(deadline) {
+			return r, attempts
+		}
+
+		t := time.NewTimer(d)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return r, attempts
+		case <-t.C:
+		}
+	}
+}
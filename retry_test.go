@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"os/exec"
+	"strconv"
+	"testing"
+
+	"github.com/crossplane-contrib/function-shell/input/v1beta1"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestRetrierRun(t *testing.T) {
+	// exitWith returns the result of a shell command that exited with code.
+	exitWith := func(code int, stderr string) commandResult {
+		return command{script: "echo '" + stderr + "' >&2; exit " + strconv.Itoa(code)}.run(context.Background())
+	}
+
+	type args struct {
+		retry   *v1beta1.Retry
+		results []commandResult
+	}
+
+	type want struct {
+		attempts int
+		code     int
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   want
+	}{
+		"NoRetry": {
+			reason: "Without a retry the command should run exactly once.",
+			args: args{
+				results: []commandResult{exitWith(1, "error"), {}},
+			},
+			want: want{attempts: 1, code: 1},
+		},
+		"SucceedsAfterRetry": {
+			reason: "A command failing with any exit code should be retried if no retryable codes or patterns are set.",
+			args: args{
+				retry:   &v1beta1.Retry{Attempts: 3, InitialBackoff: "1ms"},
+				results: []commandResult{exitWith(1, "error"), {}},
+			},
+			want: want{attempts: 2, code: 0},
+		},
+		"AttemptsExhausted": {
+			reason: "A command should not run more often than the configured attempts.",
+			args: args{
+				retry:   &v1beta1.Retry{Attempts: 2, InitialBackoff: "1ms"},
+				results: []commandResult{exitWith(1, "error"), exitWith(2, "error"), {}},
+			},
+			want: want{attempts: 2, code: 2},
+		},
+		"ExitCodeNotRetryable": {
+			reason: "A command failing with an exit code that is not retryable should not be retried.",
+			args: args{
+				retry:   &v1beta1.Retry{Attempts: 3, InitialBackoff: "1ms", RetryableExitCodes: []int{3}},
+				results: []commandResult{exitWith(1, "error"), {}},
+			},
+			want: want{attempts: 1, code: 1},
+		},
+		"StderrPatternRetryable": {
+			reason: "A command whose stderr matches a retryable pattern should be retried.",
+			args: args{
+				retry:   &v1beta1.Retry{Attempts: 3, InitialBackoff: "1ms", RetryableStderrPatterns: []string{"(?i)throttl"}},
+				results: []commandResult{exitWith(1, "Rate exceeded: Throttling"), exitWith(1, "access denied"), {}},
+			},
+			want: want{attempts: 2, code: 1},
+		},
+		"SandboxNotRetried": {
+			reason: "A command whose sandbox could not be set up should not be retried if no retryable codes or patterns are set.",
+			args: args{
+				retry:   &v1beta1.Retry{Attempts: 3, InitialBackoff: "1ms"},
+				results: []commandResult{exitWith(sandboxExitCode, "sandbox: cannot mount /proc"), {}},
+			},
+			want: want{attempts: 1, code: sandboxExitCode},
+		},
+		"NotStarted": {
+			reason: "A command that could not be started should not be retried.",
+			args: args{
+				retry:   &v1beta1.Retry{Attempts: 3, InitialBackoff: "1ms"},
+				results: []commandResult{{err: exec.ErrNotFound}, {}},
+			},
+			want: want{attempts: 1, code: -1},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			rt, err := newRetrier(tc.args.retry)
+			if err != nil {
+				t.Fatalf("newRetrier(...): %v", err)
+			}
+
+			i := 0
+			r, attempts := rt.run(context.Background(), func(_ context.Context) commandResult {
+				r := tc.args.results[i]
+				i++
+				return r
+			})
+
+			if diff := cmp.Diff(tc.want.attempts, attempts); diff != "" {
+				t.Errorf("%s\nrt.run(...): -want attempts, +got attempts:\n%s", tc.reason, diff)
+			}
+			if diff := cmp.Diff(tc.want.code, r.exitCode()); diff != "" {
+				t.Errorf("%s\nrt.run(...): -want exit code, +got exit code:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,18 @@
+package main
+
+// sandboxInitArg is the argv[0] the function binary is re-executed with to
+// set up a sandbox before running a shell command in it.
+const sandboxInitArg = "function-shell-sandbox-init"
+
+// sandboxExitCode is the exit code of a sandbox that could not be set up.
+const sandboxExitCode = 125
+
+// sandboxOptions configure the sandbox a shell command runs in. The sandbox
+// always uses a new user namespace.
+type sandboxOptions struct {
+	// isolateProcess runs the shell command in new mount, PID, IPC and UTS
+	// namespaces, with a read-only root filesystem and a private /tmp.
+	isolateProcess bool
+	// isolateNetwork runs the shell command in a new network namespace.
+	isolateNetwork bool
+}
@@ -0,0 +1,203 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"syscall"
+
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// sandboxIsolateProcessArg tells the sandbox init process that it runs
+	// in new mount and PID namespaces, which it must set up.
+	sandboxIsolateProcessArg = "--isolate-process"
+	// sandboxIsolateNetworkArg tells the sandbox init process that it runs
+	// in a new network namespace, whose loopback interface it must bring up.
+	sandboxIsolateNetworkArg = "--isolate-network"
+)
+
+// sandboxArgs returns the arguments with which the function binary is
+// re-executed to run argv in a sandbox, and the attributes of its process.
+func sandboxArgs(argv []string, o sandboxOptions) ([]string, *syscall.SysProcAttr, error) {
+	flags := unix.CLONE_NEWUSER
+	args := []string{sandboxInitArg}
+	if o.isolateProcess {
+		flags |= unix.CLONE_NEWNS | unix.CLONE_NEWPID | unix.CLONE_NEWIPC | unix.CLONE_NEWUTS
+		args = append(args, sandboxIsolateProcessArg)
+	}
+	if o.isolateNetwork {
+		flags |= unix.CLONE_NEWNET
+		args = append(args, sandboxIsolateNetworkArg)
+	}
+	args = append(args, "--")
+	args = append(args, argv...)
+
+	return args, &syscall.SysProcAttr{
+		Cloneflags: uintptr(flags),
+		// The shell command runs as root in the user namespace, which is
+		// the unprivileged user of the function outside of it.
+		UidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}},
+		GidMappings:                []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}},
+		GidMappingsEnableSetgroups: false,
+		Setpgid:                    true,
+	}, nil
+}
+
+// sandboxInit sets up the sandbox from within the namespaces created for it,
+// then replaces itself with the command in args. It never returns.
+func sandboxInit(args []string) {
+	o := sandboxOptions{}
+	for len(args) > 0 && args[0] != "--" {
+		switch args[0] {
+		case sandboxIsolateProcessArg:
+			o.isolateProcess = true
+		case sandboxIsolateNetworkArg:
+			o.isolateNetwork = true
+		}
+		args = args[1:]
+	}
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "sandbox: no command to run")
+		os.Exit(sandboxExitCode)
+	}
+	argv := args[1:]
+
+	env := os.Environ()
+	if o.isolateProcess {
+		if err := setupProcessIsolation(); err != nil {
+			fmt.Fprintf(os.Stderr, "sandbox: %v\n", err)
+			os.Exit(sandboxExitCode)
+		}
+
+		// The home directory is read-only, so point tools that cache
+		// state in it to the tmpfs.
+		env = []string{"HOME=/tmp"}
+		for _, e := range os.Environ() {
+			if !strings.HasPrefix(e, "HOME=") {
+				env = append(env, e)
+			}
+		}
+	}
+	if o.isolateNetwork {
+		if err := loopbackUp(); err != nil {
+			fmt.Fprintf(os.Stderr, "sandbox: cannot bring up loopback interface: %v\n", err)
+			os.Exit(sandboxExitCode)
+		}
+	}
+
+	err := unix.Exec(argv[0], argv, env)
+	fmt.Fprintf(os.Stderr, "sandbox: cannot run %s: %v\n", argv[0], err)
+	os.Exit(sandboxExitCode)
+}
+
+// setupProcessIsolation sets up the mount namespace of the sandbox. The
+// working directory of the sandbox init process is the working directory of
+// the shell command, and stays writable. If it is the root directory, the
+// shell command runs in /tmp instead.
+func setupProcessIsolation() error {
+	// Don't propagate our mounts back to the function container.
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return errors.Wrap(err, "cannot make mounts private")
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return errors.Wrap(err, "cannot get working directory")
+	}
+	wdfd := -1
+	if wd != "/" {
+		// Bind mount the working directory onto itself, so it stays
+		// writable when the root filesystem is remounted read-only. Keep
+		// a handle to it in case the tmpfs at /tmp hides it.
+		if err := unix.Mount(wd, wd, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+			return errors.Wrapf(err, "cannot bind mount working directory %s", wd)
+		}
+		if wdfd, err = unix.Open(wd, unix.O_PATH|unix.O_DIRECTORY|unix.O_CLOEXEC, 0); err != nil {
+			return errors.Wrapf(err, "cannot open working directory %s", wd)
+		}
+		defer unix.Close(wdfd) //nolint:errcheck // Nothing to do if closing fails.
+	}
+
+	// Remounting must keep the flags the kernel locked when it created the
+	// user namespace, or it fails with EPERM.
+	var st unix.Statfs_t
+	if err := unix.Statfs("/", &st); err != nil {
+		return errors.Wrap(err, "cannot stat root filesystem")
+	}
+	if err := unix.Mount("", "/", "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY|lockedMountFlags(st.Flags), ""); err != nil {
+		return errors.Wrap(err, "cannot remount root filesystem read-only")
+	}
+
+	if err := unix.Mount("tmpfs", "/tmp", "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=1777"); err != nil {
+		return errors.Wrap(err, "cannot mount tmpfs at /tmp")
+	}
+
+	if wdfd < 0 {
+		wd = "/tmp"
+	} else if _, err := os.Stat(wd); errors.Is(err, fs.ErrNotExist) {
+		if err := os.MkdirAll(wd, 0o700); err != nil {
+			return errors.Wrapf(err, "cannot create working directory %s", wd)
+		}
+		if err := unix.Mount(fmt.Sprintf("/proc/self/fd/%d", wdfd), wd, "", unix.MS_BIND, ""); err != nil {
+			return errors.Wrapf(err, "cannot bind mount working directory %s", wd)
+		}
+	}
+	if err := unix.Chdir(wd); err != nil {
+		return errors.Wrapf(err, "cannot change to working directory %s", wd)
+	}
+
+	// Only show the processes of the sandbox in /proc. The kernel refuses to
+	// mount proc if the container runtime masked parts of the container's
+	// /proc, as most do by default. Hide /proc entirely in that case.
+	if err := unix.Mount("proc", "/proc", "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+		if err := unix.Mount("tmpfs", "/proc", "tmpfs", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC|unix.MS_RDONLY, ""); err != nil {
+			return errors.Wrap(err, "cannot mount /proc")
+		}
+	}
+
+	return nil
+}
+
+// lockedMountFlags returns the mount flags for statfs flags that a user
+// namespace can't clear when remounting.
+func lockedMountFlags(f int64) uintptr {
+	var flags uintptr
+	for st, ms := range map[int64]uintptr{
+		unix.ST_NOSUID:      unix.MS_NOSUID,
+		unix.ST_NODEV:       unix.MS_NODEV,
+		unix.ST_NOEXEC:      unix.MS_NOEXEC,
+		unix.ST_NOATIME:     unix.MS_NOATIME,
+		unix.ST_NODIRATIME:  unix.MS_NODIRATIME,
+		unix.ST_RELATIME:    unix.MS_RELATIME,
+		unix.ST_SYNCHRONOUS: unix.MS_SYNCHRONOUS,
+	} {
+		if f&st != 0 {
+			flags |= ms
+		}
+	}
+	return flags
+}
+
+func loopbackUp() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd) //nolint:errcheck // Nothing to do if closing fails.
+
+	ifr, err := unix.NewIfreq("lo")
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifr); err != nil {
+		return err
+	}
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP | unix.IFF_RUNNING)
+	return unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr)
+}
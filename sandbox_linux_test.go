@@ -0,0 +1,118 @@
+//go:build linux
+
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestMain(m *testing.M) {
+	// The sandbox re-executes the test binary to set itself up.
+	if os.Args[0] == sandboxInitArg {
+		sandboxInit(os.Args[1:])
+	}
+	os.Exit(m.Run())
+}
+
+func TestSandbox(t *testing.T) {
+	if r := (command{script: "true", sandbox: &sandboxOptions{isolateProcess: true}}).run(context.Background()); r.err != nil {
+		t.Skipf("Linux namespaces are not available: %v: %s", r.err, r.stderr)
+	}
+
+	// A working directory in the /tmp of the function, which the sandbox
+	// hides.
+	dir, err := os.MkdirTemp("/tmp", "function-shell-sandbox-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	if err := os.WriteFile(filepath.Join(dir, "input.json"), []byte(`{"a":1}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	type want struct {
+		stdout string
+		code   int
+	}
+
+	cases := map[string]struct {
+		reason  string
+		script  string
+		dir     string
+		sandbox sandboxOptions
+		want    want
+	}{
+		"PIDNamespace": {
+			reason:  "The shell should be the first process of a new PID namespace.",
+			script:  "echo $$",
+			sandbox: sandboxOptions{isolateProcess: true},
+			want:    want{stdout: "1"},
+		},
+		"ReadOnlyRoot": {
+			reason:  "The root filesystem should be read-only.",
+			script:  "touch /function-shell-sandbox-test 2>/dev/null || echo read-only",
+			sandbox: sandboxOptions{isolateProcess: true},
+			want:    want{stdout: "read-only"},
+		},
+		"PrivateTmp": {
+			reason:  "The shell should run in an empty, writable tmpfs at /tmp.",
+			script:  "pwd; ls -A | wc -l; touch file && echo writable",
+			sandbox: sandboxOptions{isolateProcess: true},
+			want:    want{stdout: "/tmp\n0\nwritable"},
+		},
+		"WorkingDirectory": {
+			reason:  "The shell should run in its working directory, which stays writable.",
+			script:  "[ \"$PWD\" = " + dir + " ] && cat input.json && touch output.json && ls /tmp",
+			dir:     dir,
+			sandbox: sandboxOptions{isolateProcess: true},
+			want:    want{stdout: `{"a":1}` + filepath.Base(dir)},
+		},
+		"HomeInTmp": {
+			reason:  "HOME should point to the writable tmpfs.",
+			script:  "echo $HOME",
+			sandbox: sandboxOptions{isolateProcess: true},
+			want:    want{stdout: "/tmp"},
+		},
+		"IsolatedNetwork": {
+			reason: "Only the loopback interface should exist in an isolated network namespace.",
+			script: "tail -n +3 /proc/net/dev | cut -d: -f1 | tr -d ' '",
+			sandbox: sandboxOptions{
+				isolateProcess: true,
+				isolateNetwork: true,
+			},
+			want: want{stdout: "lo"},
+		},
+		"IsolatedNetworkOnly": {
+			reason: "A network namespace should not need the other namespaces of the sandbox.",
+			script: "tail -n +3 /proc/self/net/dev | cut -d: -f1 | tr -d ' '; [ $$ -ne 1 ] && echo shared-pid",
+			sandbox: sandboxOptions{
+				isolateNetwork: true,
+			},
+			want: want{stdout: "lo\nshared-pid"},
+		},
+		"ExitCode": {
+			reason:  "The exit code of the shell should be returned.",
+			script:  "exit 3",
+			sandbox: sandboxOptions{isolateProcess: true},
+			want:    want{code: 3},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			r := command{script: tc.script, dir: tc.dir, sandbox: &tc.sandbox}.run(context.Background())
+
+			if diff := cmp.Diff(tc.want.stdout, r.stdout); diff != "" {
+				t.Errorf("%s\nrun(...): -want stdout, +got stdout:\n%s\nstderr: %s", tc.reason, diff, r.stderr)
+			}
+			if diff := cmp.Diff(tc.want.code, r.exitCode()); diff != "" {
+				t.Errorf("%s\nrun(...): -want exit code, +got exit code:\n%s\nstderr: %s", tc.reason, diff, r.stderr)
+			}
+		})
+	}
+}
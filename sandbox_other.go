@@ -0,0 +1,22 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+)
+
+// sandboxArgs returns an error, because sandboxes rely on Linux namespaces.
+func sandboxArgs(_ []string, _ sandboxOptions) ([]string, *syscall.SysProcAttr, error) {
+	return nil, nil, errors.New("sandbox is only supported on Linux")
+}
+
+// sandboxInit exits, because sandboxes rely on Linux namespaces.
+func sandboxInit(_ []string) {
+	fmt.Fprintln(os.Stderr, "sandbox: only supported on Linux")
+	os.Exit(sandboxExitCode)
+}
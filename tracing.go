@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.39.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
+)
+
+// Tracing exporters.
+const (
+	tracingExporterNone     = "none"
+	tracingExporterOTLPGRPC = "otlp-grpc"
+	tracingExporterOTLPHTTP = "otlp-http"
+)
+
+const tracerName = "github.com/crossplane-contrib/function-shell"
+
+// tracer returns the tracer of the function. It doesn't record anything
+// unless a tracer provider was set up with newTracerProvider.
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// newTracerProvider returns a tracer provider that exports spans to an OTLP
+// endpoint using the supplied exporter. The endpoint is a URL like
+// http://otel-collector:4317. The OTEL_EXPORTER_OTLP_* environment variables
+// configure the exporter if the endpoint is empty.
+func newTracerProvider(ctx context.Context, exporter, endpoint string) (*sdktrace.TracerProvider, error) {
+	var exp sdktrace.SpanExporter
+	var err error
+	switch exporter {
+	case tracingExporterOTLPGRPC:
+		var opts []otlptracegrpc.Option
+		if endpoint != "" {
+			opts = append(opts, otlptracegrpc.WithEndpointURL(endpoint))
+		}
+		exp, err = otlptracegrpc.New(ctx, opts...)
+	case tracingExporterOTLPHTTP:
+		var opts []otlptracehttp.Option
+		if endpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(endpoint))
+		}
+		exp, err = otlptracehttp.New(ctx, opts...)
+	default:
+		return nil, errors.Errorf("unknown tracing exporter %s", exporter)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot create %s tracing exporter", exporter)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName("function-shell")))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create tracing resource")
+	}
+
+	return sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res)), nil
+}
+
+// A metadataCarrier carries trace context in the gRPC metadata of a function
+// call.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if v := metadata.MD(c).Get(key); len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// extractTraceContext returns ctx with the trace context of the gRPC
+// metadata of the function call, if any.
+func extractTraceContext(ctx context.Context) context.Context {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+}
+
+// traceEnv returns the environment variables that propagate the trace
+// context of ctx to a shell command, like TRACEPARENT.
+func traceEnv(ctx context.Context) []string {
+	c := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, c)
+	env := make([]string, 0, len(c))
+	for _, k := range c.Keys() {
+		env = append(env, strings.ToUpper(k)+"="+c.Get(k))
+	}
+	return env
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"testing"
+
+	"github.com/crossplane/crossplane-runtime/v2/pkg/logging"
+	"github.com/google/go-cmp/cmp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+	"google.golang.org/grpc/metadata"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/crossplane/function-sdk-go/resource/composite"
+)
+
+func TestTracing(t *testing.T) {
+	t.Cleanup(func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+
+	sr := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(sr)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	// The trace context Crossplane sends with the function call.
+	parent := "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("traceparent", parent))
+
+	req := &fnv1.RunFunctionRequest{
+		Meta: &fnv1.RequestMeta{Tag: "hello"},
+		Input: resource.MustStructJSON(`{
+			"apiVersion": "template.fn.crossplane.io/v1alpha1",
+			"kind": "Parameters",
+			"shellEnvVars": [{"key": "REGION", "fieldRef": {"path": "spec.region"}}],
+			"shellCommand": "echo $TRACEPARENT"
+		}`),
+		Observed: &fnv1.State{
+			Composite: &fnv1.Resource{
+				Resource: resource.MustStructJSON(`{"apiVersion": "example.org/v1", "kind": "XBucket", "spec": {"region": "us-east-1"}}`),
+			},
+		},
+	}
+
+	f := &Function{log: logging.NewNopLogger()}
+	rsp, err := f.RunFunction(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	names := []string{}
+	for _, s := range sr.Ended() {
+		spans[s.Name()] = s
+		names = append(names, s.Name())
+	}
+	sort.Strings(names)
+	if diff := cmp.Diff([]string{"AssembleResponse", "DecodeInput", "ResolveFieldRef", "RunCommand", "RunFunction"}, names); diff != "" {
+		t.Errorf("RunFunction(...): -want spans, +got spans:\n%s", diff)
+	}
+
+	root := spans["RunFunction"]
+	if got := root.Parent().SpanID().String(); got != "b7ad6b7169203331" {
+		t.Errorf("RunFunction(...): want parent span b7ad6b7169203331, got %s", got)
+	}
+	for name, s := range spans {
+		if s.SpanContext().TraceID() != root.SpanContext().TraceID() {
+			t.Errorf("RunFunction(...): span %s isn't part of the trace", name)
+		}
+	}
+
+	cmd := spans["RunCommand"].SpanContext()
+	want := "00-" + cmd.TraceID().String() + "-" + cmd.SpanID().String() + "-01"
+	xr := composite.New()
+	if err := resource.AsObject(rsp.GetDesired().GetComposite().GetResource(), xr); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := xr.GetString("status.atFunction.shell.stdout")
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("RunFunction(...): -want TRACEPARENT, +got TRACEPARENT:\n%s", diff)
+	}
+}
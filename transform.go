@@ -0,0 +1,85 @@
+package main
+
+import (
+	"maps"
+
+	"github.com/crossplane-contrib/function-shell/input/v1beta1"
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+)
+
+// Variables of the CEL expressions of an OutputTransform, besides those of
+// the request.
+const (
+	celVarStdout   = "stdout"
+	celVarStderr   = "stderr"
+	celVarExitCode = "exitCode"
+	celVarFile     = "file"
+)
+
+// celOutputVars are the variables of the CEL expressions of an
+// OutputTransform.
+var celOutputVars = append([]string{celVarStdout, celVarStderr, celVarExitCode}, celRequestVars...)
+
+// celFileVars are the variables of the CEL expressions of output files.
+var celFileVars = append([]string{celVarFile}, celOutputVars...)
+
+// An outputTransformer replaces the parsed outputs of a shell command with
+// the values of the CEL expressions of an OutputTransform. A nil
+// outputTransformer returns the outputs as is.
+type outputTransformer struct {
+	t    *v1beta1.OutputTransform
+	vars map[string]any
+}
+
+// newOutputTransformer returns an outputTransformer for the supplied parsed
+// stdout, stderr and exit code of a shell command, or nil if t is nil.
+func newOutputTransformer(req *fnv1.RunFunctionRequest, t *v1beta1.OutputTransform, stdout any, stderr string, exitCode int) *outputTransformer {
+	if t == nil {
+		return nil
+	}
+	vars := celVars(req)
+	vars[celVarStdout] = stdout
+	vars[celVarStderr] = stderr
+	vars[celVarExitCode] = exitCode
+	return &outputTransformer{t: t, vars: vars}
+}
+
+// stdout returns the value of the stdout expression, or the parsed stdout if
+// there isn't one.
+func (o *outputTransformer) stdout(v any) (any, error) {
+	if o == nil || o.t.Stdout == "" {
+		return v, nil
+	}
+	v, err := evalCEL(o.t.Stdout, o.vars, celOutputVars...)
+	return v, errors.Wrap(err, "cannot transform stdout")
+}
+
+// stderr returns the value of the stderr expression, or stderr if there isn't
+// one.
+func (o *outputTransformer) stderr(s string) (any, error) {
+	if o == nil || o.t.Stderr == "" {
+		return s, nil
+	}
+	v, err := evalCEL(o.t.Stderr, o.vars, celOutputVars...)
+	return v, errors.Wrap(err, "cannot transform stderr")
+}
+
+// file returns the value of the expression of the output file at the supplied
+// path, or the parsed output file if there isn't one.
+func (o *outputTransformer) file(path string, v any) (any, error) {
+	if o == nil {
+		return v, nil
+	}
+	for _, ft := range o.t.Files {
+		if ft.Path != path {
+			continue
+		}
+		vars := maps.Clone(o.vars)
+		vars[celVarFile] = v
+		v, err := evalCEL(ft.Expression, vars, celFileVars...)
+		return v, errors.Wrapf(err, "cannot transform output file %s", path)
+	}
+	return v, nil
+}
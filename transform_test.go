@@ -0,0 +1,131 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/crossplane-contrib/function-shell/input/v1beta1"
+	"github.com/google/go-cmp/cmp"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+)
+
+func TestOutputTransformer(t *testing.T) {
+	req := &fnv1.RunFunctionRequest{
+		Observed: &fnv1.State{
+			Composite: &fnv1.Resource{
+				Resource: resource.MustStructJSON(`{
+					"apiVersion": "",
+					"kind": "",
+					"spec": {"region": "eu-west-1"}
+				}`),
+			},
+		},
+	}
+	stdout := map[string]any{
+		"Images": []any{
+			map[string]any{"ImageId": "ami-1", "Name": "a"},
+			map[string]any{"ImageId": "ami-2", "Name": "b"},
+		},
+	}
+
+	type args struct {
+		t      *v1beta1.OutputTransform
+		output string
+		value  any
+	}
+	type want struct {
+		value any
+		err   bool
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   want
+	}{
+		"NoTransform": {
+			reason: "Outputs should be returned as is without an OutputTransform.",
+			args:   args{output: "stdout", value: stdout},
+			want:   want{value: stdout},
+		},
+		"NoExpression": {
+			reason: "Outputs without an expression should be returned as is.",
+			args:   args{t: &v1beta1.OutputTransform{Stderr: "stderr"}, output: "stdout", value: stdout},
+			want:   want{value: stdout},
+		},
+		"Stdout": {
+			reason: "The stdout expression should reshape the parsed stdout, and may use the observed composite resource.",
+			args: args{
+				t:      &v1beta1.OutputTransform{Stdout: `{"region": xr.spec.region, "images": stdout.Images.map(i, i.ImageId)}`},
+				output: "stdout",
+				value:  stdout,
+			},
+			want: want{value: map[string]any{"region": "eu-west-1", "images": []any{"ami-1", "ami-2"}}},
+		},
+		"Stderr": {
+			reason: "The stderr expression should be able to use the exit code.",
+			args: args{
+				t:      &v1beta1.OutputTransform{Stderr: `exitCode == 0 ? "" : stderr.trim()`},
+				output: "stderr",
+				value:  "warning",
+			},
+			want: want{value: ""},
+		},
+		"File": {
+			reason: "The expression of an output file should be able to use the parsed file.",
+			args: args{
+				t: &v1beta1.OutputTransform{Files: []v1beta1.OutputFileTransform{
+					{Path: "out.json", Expression: `file.size + 1`},
+				}},
+				output: "out.json",
+				value:  map[string]any{"size": 2},
+			},
+			want: want{value: float64(3)},
+		},
+		"OtherFile": {
+			reason: "Output files without an expression should be returned as is.",
+			args: args{
+				t: &v1beta1.OutputTransform{Files: []v1beta1.OutputFileTransform{
+					{Path: "out.json", Expression: `file.size + 1`},
+				}},
+				output: "other.json",
+				value:  "other",
+			},
+			want: want{value: "other"},
+		},
+		"EvalError": {
+			reason: "An expression that fails to evaluate should be an error.",
+			args: args{
+				t:      &v1beta1.OutputTransform{Stdout: `stdout.Missing`},
+				output: "stdout",
+				value:  stdout,
+			},
+			want: want{err: true},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			tr := newOutputTransformer(req, tc.args.t, stdout, "warning", 0)
+
+			var got any
+			var err error
+			switch tc.args.output {
+			case "stdout":
+				got, err = tr.stdout(tc.args.value)
+			case "stderr":
+				got, err = tr.stderr(tc.args.value.(string))
+			default:
+				got, err = tr.file(tc.args.output, tc.args.value)
+			}
+
+			if diff := cmp.Diff(tc.want.value, got); diff != "" {
+				t.Errorf("%s\noutputTransformer: -want, +got:\n%s", tc.reason, diff)
+			}
+			if (err != nil) != tc.want.err {
+				t.Errorf("%s\noutputTransformer: want error %t, got %v", tc.reason, tc.want.err, err)
+			}
+		})
+	}
+}
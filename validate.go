@@ -1,21 +1,450 @@
 package main
 
 import (
-	"github.com/crossplane-contrib/function-shell/input/v1alpha1"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/crossplane-contrib/function-shell/input/v1beta1"
+	"github.com/crossplane/crossplane-runtime/v2/pkg/fieldpath"
 	"k8s.io/apimachinery/pkg/util/validation/field"
 
 	"github.com/crossplane/function-sdk-go/resource"
 )
 
-// ValidateParameters validates the Parameters object.
-func ValidateParameters(p *v1alpha1.Parameters, _ *resource.Composite) *field.Error {
-	if p.ShellCommand == "" && p.ShellCommandField == "" {
-		return field.Required(field.NewPath("parameters"), "one of ShellCommand or ShellCommandField is required")
+// ValidateParameters validates every field of the Parameters object, and
+// enforces the command Policy for the composite resource. A nil Policy allows
+// any command.
+func ValidateParameters(p *v1beta1.Parameters, oxr *resource.Composite, pol *Policy) field.ErrorList {
+	var errs field.ErrorList
+	root := field.NewPath("parameters")
+
+	cmd := root.Child("command")
+	switch {
+	case p.Command.Inline == "" && p.Command.FieldRef == nil:
+		errs = append(errs, field.Required(cmd.Child("inline"), "a shell command is required, set inline or fieldRef"))
+	case p.Command.Inline != "" && p.Command.FieldRef != nil:
+		errs = append(errs, field.Invalid(cmd, p.Command.Inline, "only one of inline or fieldRef may be set"))
+	}
+
+	switch p.Command.Network {
+	case "", v1beta1.NetworkNone, v1beta1.NetworkInherit:
+	default:
+		errs = append(errs, field.NotSupported(cmd.Child("network"), p.Command.Network, []v1beta1.Network{v1beta1.NetworkNone, v1beta1.NetworkInherit}))
+	}
+
+	out := root.Child("outputs")
+	formats := []v1beta1.OutputFormat{v1beta1.OutputFormatText, v1beta1.OutputFormatJSON, v1beta1.OutputFormatYAML}
+	if !validOutputFormat(p.Outputs.Stdout.Format) {
+		errs = append(errs, field.NotSupported(out.Child("stdout", "format"), p.Outputs.Stdout.Format, formats))
+	}
+
+	if p.Outputs.MaxSize < 0 {
+		errs = append(errs, field.Invalid(out.Child("maxSize"), p.Outputs.MaxSize, "must not be negative"))
+	}
+
+	switch p.Outputs.OnFailure {
+	case "", v1beta1.OnFailureOverwrite, v1beta1.OnFailureKeepPrevious:
+	default:
+		errs = append(errs, field.NotSupported(out.Child("onFailure"), p.Outputs.OnFailure, []v1beta1.OnFailure{v1beta1.OnFailureOverwrite, v1beta1.OnFailureKeepPrevious}))
+	}
+
+	// Output files that connection details are taken from.
+	referenced := map[string]bool{}
+	for _, cd := range p.Outputs.ConnectionDetails {
+		referenced[cd.OutputFile] = true
+	}
+
+	outputFiles := map[string]bool{}
+	for i, f := range p.Outputs.Files {
+		fp := out.Child("files").Index(i)
+		if f.Path == "" {
+			errs = append(errs, field.Required(fp.Child("path"), "path is required"))
+		}
+		if !validOutputFormat(f.Format) {
+			errs = append(errs, field.NotSupported(fp.Child("format"), f.Format, formats))
+		}
+		if f.Field == "" && f.ContextKey == "" && f.ConnectionDetail == "" && !referenced[f.Path] {
+			errs = append(errs, field.Required(fp, "at least one of field, contextKey or connectionDetail is required"))
+		}
+		outputFiles[f.Path] = true
 	}
 
-	if p.ShellCommand != "" && p.ShellCommandField != "" {
-		return field.Required(field.NewPath("parameters"), "exactly one of ShellCommand or ShellCommandField is required")
+	for i, cd := range p.Outputs.ConnectionDetails {
+		fp := out.Child("connectionDetails").Index(i)
+		if cd.Name == "" {
+			errs = append(errs, field.Required(fp.Child("name"), "name is required"))
+		}
+		if cd.OutputFile != "" && !outputFiles[cd.OutputFile] {
+			errs = append(errs, field.Invalid(fp.Child("outputFile"), cd.OutputFile, "must be the path of one of the output files"))
+		}
 	}
 
+	sources := []v1beta1.OutputSource{v1beta1.OutputSourceStdout, v1beta1.OutputSourceStderr, v1beta1.OutputSourceExitCode, v1beta1.OutputSourceFile}
+	for i, t := range p.Outputs.Targets {
+		fp := out.Child("targets").Index(i)
+		switch t.Source {
+		case "", v1beta1.OutputSourceStdout, v1beta1.OutputSourceStderr, v1beta1.OutputSourceExitCode:
+			if t.File != "" {
+				errs = append(errs, field.Invalid(fp.Child("file"), t.File, "file may only be set if source is File"))
+			}
+		case v1beta1.OutputSourceFile:
+			if !outputFiles[t.File] {
+				errs = append(errs, field.Invalid(fp.Child("file"), t.File, "must be the path of one of the output files"))
+			}
+		default:
+			errs = append(errs, field.NotSupported(fp.Child("source"), t.Source, sources))
+		}
+		if t.Field == "" && t.ContextKey == "" && t.ConnectionDetail == "" {
+			errs = append(errs, field.Required(fp, "at least one of field, contextKey or connectionDetail is required"))
+		}
+	}
+
+	if p.OutputTransform != nil {
+		errs = append(errs, validateOutputTransform(root.Child("outputTransform"), *p.OutputTransform, outputFiles)...)
+	}
+
+	errs = append(errs, validateFields(p)...)
+
+	if err := pol.Validate(p, oxr); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errs
+}
+
+func validOutputFormat(f v1beta1.OutputFormat) bool {
+	switch f {
+	case "", v1beta1.OutputFormatText, v1beta1.OutputFormatJSON, v1beta1.OutputFormatYAML:
+		return true
+	}
+	return false
+}
+
+// envKeyRegex matches valid names of environment variables.
+var envKeyRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateFields validates the syntax of the fields of the Parameters object,
+// like durations and field paths.
+func validateFields(p *v1beta1.Parameters) field.ErrorList {
+	var errs field.ErrorList
+	root := field.NewPath("parameters")
+
+	errs = append(errs, validateDuration(root.Child("cacheTTL"), p.CacheTTL)...)
+
+	if p.When != nil {
+		errs = append(errs, validateWhen(root.Child("when"), *p.When)...)
+	}
+
+	cmd := root.Child("command")
+	errs = append(errs, validateDuration(cmd.Child("timeout"), p.Command.Timeout)...)
+	if r := p.Command.Retry; r != nil {
+		errs = append(errs, validateDuration(cmd.Child("retry", "initialBackoff"), r.InitialBackoff)...)
+		errs = append(errs, validateDuration(cmd.Child("retry", "maxBackoff"), r.MaxBackoff)...)
+		if r.Attempts < 0 {
+			errs = append(errs, field.Invalid(cmd.Child("retry", "attempts"), r.Attempts, "must not be negative"))
+		}
+		for i, pattern := range r.RetryableStderrPatterns {
+			if _, err := regexp.Compile(pattern); err != nil {
+				errs = append(errs, field.Invalid(cmd.Child("retry", "retryableStderrPatterns").Index(i), pattern, err.Error()))
+			}
+		}
+	}
+
+	if p.Command.Inline != "" {
+		if _, err := parseCalls(p.Command.Inline); err != nil {
+			errs = append(errs, field.Invalid(cmd.Child("inline"), p.Command.Inline, fmt.Sprintf("cannot parse shell command: %s", err)))
+		}
+	}
+
+	if p.Command.FieldRef != nil {
+		errs = append(errs, validateFieldRef(cmd.Child("fieldRef"), *p.Command.FieldRef)...)
+	}
+
+	for i, ev := range p.Env.Vars {
+		fp := root.Child("env", "vars").Index(i)
+		if !envKeyRegex.MatchString(ev.Name) {
+			errs = append(errs, field.Invalid(fp.Child("name"), ev.Name, "must be a valid environment variable name, like API_KEY"))
+		}
+		if ev.FieldRef != nil {
+			if ev.Value != "" {
+				errs = append(errs, field.Invalid(fp, ev.Name, "only one of value or fieldRef may be set"))
+			}
+			errs = append(errs, validateFieldRef(fp.Child("fieldRef"), *ev.FieldRef)...)
+		}
+	}
+
+	for i, from := range p.Env.From {
+		fp := root.Child("env", "from").Index(i)
+		switch n := countSet(from.EnvVarRef != nil, from.FieldRef != nil, from.FileRef != nil); {
+		case n == 0:
+			errs = append(errs, field.Required(fp, "one of envVarRef, fieldRef or fileRef is required"))
+		case n > 1:
+			errs = append(errs, field.Invalid(fp, n, "only one of envVarRef, fieldRef or fileRef may be set"))
+		}
+		if from.EnvVarRef != nil {
+			if from.EnvVarRef.Name == "" {
+				errs = append(errs, field.Required(fp.Child("envVarRef", "name"), "name is required"))
+			}
+			errs = append(errs, validateEnvFormat(fp.Child("envVarRef", "format"), from.EnvVarRef.Format)...)
+			errs = append(errs, validateEnvKeys(fp.Child("envVarRef"), from.EnvVarRef.Keys, from.EnvVarRef.OptionalKeys)...)
+		}
+		if from.FieldRef != nil {
+			errs = append(errs, validateEnvFromFieldRef(fp.Child("fieldRef"), *from.FieldRef)...)
+		}
+		if from.FileRef != nil {
+			errs = append(errs, validateEnvFileRef(fp.Child("fileRef"), *from.FileRef)...)
+		}
+	}
+
+	for i, f := range p.Files {
+		fp := root.Child("files").Index(i)
+		if !filepath.IsLocal(f.Path) {
+			errs = append(errs, field.Invalid(fp.Child("path"), f.Path, "must be relative to, and within, the working directory"))
+		}
+		if f.Mode != "" {
+			if m, err := strconv.ParseUint(f.Mode, 8, 32); err != nil || m > 0o777 {
+				errs = append(errs, field.Invalid(fp.Child("mode"), f.Mode, "must be an octal file mode like 0600"))
+			}
+		}
+		switch t := f.GetType(); t {
+		case v1beta1.FileTypeValue, v1beta1.FileTypeContextKey:
+		case v1beta1.FileTypeFieldRef:
+			if f.FieldRef == nil {
+				errs = append(errs, field.Required(fp.Child("fieldRef"), "fieldRef is required for type FieldRef"))
+				continue
+			}
+			errs = append(errs, validateFieldRef(fp.Child("fieldRef"), *f.FieldRef)...)
+		case v1beta1.FileTypeCredential:
+			if f.CredentialRef == nil {
+				errs = append(errs, field.Required(fp.Child("credentialRef"), "credentialRef is required for type Credential"))
+			}
+		case "":
+			errs = append(errs, field.Required(fp, "one of value, fieldRef, contextKey or credentialRef is required"))
+		default:
+			errs = append(errs, field.NotSupported(fp.Child("type"), t, []v1beta1.FileType{v1beta1.FileTypeValue, v1beta1.FileTypeFieldRef, v1beta1.FileTypeContextKey, v1beta1.FileTypeCredential}))
+		}
+	}
+
+	out := root.Child("outputs")
+	errs = append(errs, validateDestPath(out.Child("stdout", "field"), p.Outputs.Stdout.Field)...)
+	errs = append(errs, validateDestPath(out.Child("stderr", "field"), p.Outputs.Stderr.Field)...)
+	errs = append(errs, validateDestPath(out.Child("refreshedField"), p.Outputs.RefreshedField)...)
+
+	for i, f := range p.Outputs.Files {
+		fp := out.Child("files").Index(i)
+		if f.Path != "" && !filepath.IsLocal(f.Path) {
+			errs = append(errs, field.Invalid(fp.Child("path"), f.Path, "must be relative to, and within, the working directory"))
+		}
+		errs = append(errs, validateDestPath(fp.Child("field"), f.Field)...)
+	}
+
+	for i, cd := range p.Outputs.ConnectionDetails {
+		errs = append(errs, validateFieldPath(out.Child("connectionDetails").Index(i).Child("fieldPath"), cd.FieldPath)...)
+	}
+
+	for i, t := range p.Outputs.Targets {
+		fp := out.Child("targets").Index(i)
+		errs = append(errs, validateFieldPath(fp.Child("fieldPath"), t.FieldPath)...)
+		errs = append(errs, validateDestPath(fp.Child("field"), t.Field)...)
+	}
+
+	return errs
+}
+
+func validateWhen(fp *field.Path, w v1beta1.When) field.ErrorList {
+	var errs field.ErrorList
+	for i, f := range w.Fields {
+		ffp := fp.Child("fields").Index(i)
+		if f.Path == "" {
+			errs = append(errs, field.Required(ffp.Child("path"), "path is required"))
+		}
+		errs = append(errs, validateRefPath(ffp.Child("path"), f.Path)...)
+		if f.Matches != "" {
+			if _, err := regexp.Compile(f.Matches); err != nil {
+				errs = append(errs, field.Invalid(ffp.Child("matches"), f.Matches, err.Error()))
+			}
+		}
+	}
+	errs = append(errs, validateCEL(fp.Child("cel"), w.CEL, celRequestVars)...)
+	return errs
+}
+
+func validateOutputTransform(fp *field.Path, t v1beta1.OutputTransform, outputFiles map[string]bool) field.ErrorList {
+	var errs field.ErrorList
+	errs = append(errs, validateCEL(fp.Child("stdout"), t.Stdout, celOutputVars)...)
+	errs = append(errs, validateCEL(fp.Child("stderr"), t.Stderr, celOutputVars)...)
+	for i, ft := range t.Files {
+		ffp := fp.Child("files").Index(i)
+		if !outputFiles[ft.Path] {
+			errs = append(errs, field.Invalid(ffp.Child("path"), ft.Path, "must be the path of one of the output files"))
+		}
+		if ft.Expression == "" {
+			errs = append(errs, field.Required(ffp.Child("expression"), "expression is required"))
+		}
+		errs = append(errs, validateCEL(ffp.Child("expression"), ft.Expression, celFileVars)...)
+	}
+	return errs
+}
+
+// validateCEL validates that an optional CEL expression compiles with the
+// supplied variables.
+func validateCEL(fp *field.Path, expr string, vars []string) field.ErrorList {
+	if expr == "" {
+		return nil
+	}
+	if _, err := compileCEL(expr, vars...); err != nil {
+		return field.ErrorList{field.Invalid(fp, expr, err.Error())}
+	}
+	return nil
+}
+
+func validateDuration(fp *field.Path, d string) field.ErrorList {
+	if d == "" {
+		return nil
+	}
+	if _, err := time.ParseDuration(d); err != nil {
+		return field.ErrorList{field.Invalid(fp, d, "must be a duration like 30s or 2m")}
+	}
+	return nil
+}
+
+// validateFieldPath validates the syntax of an optional field path.
+func validateFieldPath(fp *field.Path, path string) field.ErrorList {
+	if path == "" {
+		return nil
+	}
+	if isFieldExpr(path) {
+		if _, err := parseFieldExpr(path); err != nil {
+			return field.ErrorList{field.Invalid(fp, path, err.Error())}
+		}
+		return nil
+	}
+	if _, err := fieldpath.Parse(path); err != nil {
+		return field.ErrorList{field.Invalid(fp, path, err.Error())}
+	}
 	return nil
 }
+
+// validateDestPath validates the syntax of a field an output is written to,
+// which may refer to a desired composed resource like
+// desiredResources[name].path.
+func validateDestPath(fp *field.Path, path string) field.ErrorList {
+	if match := desiredResourcesRefRegex.FindStringSubmatch(path); match != nil {
+		return validateFieldPath(fp, match[2])
+	}
+	if strings.HasPrefix(path, "desiredResources[") {
+		return field.ErrorList{field.Invalid(fp, path, "must be like desiredResources[name].path")}
+	}
+	return validateFieldPath(fp, path)
+}
+
+// validateRefPath validates the syntax of the path of a FieldRef, which
+// may refer to the pipeline context like context[key].path, or to an
+// observed composed resource like resources[name].path.
+func validateRefPath(fp *field.Path, path string) field.ErrorList {
+	if match := contextRefRegex.FindStringSubmatch(path); match != nil {
+		path = match[2]
+	} else if match := resourcesRefRegex.FindStringSubmatch(path); match != nil {
+		path = match[2]
+	}
+	return validateFieldPath(fp, path)
+}
+
+func validateFieldRef(fp *field.Path, ref v1beta1.FieldRef) field.ErrorList {
+	var errs field.ErrorList
+	if ref.Path == "" {
+		errs = append(errs, field.Required(fp.Child("path"), "path is required"))
+	}
+	errs = append(errs, validateRefPath(fp.Child("path"), ref.Path)...)
+	errs = append(errs, validateFieldRefPolicy(fp.Child("policy"), ref.Policy)...)
+	errs = append(errs, validateFieldRefFormat(fp.Child("format"), ref.Format)...)
+	return errs
+}
+
+func validateEnvFromFieldRef(fp *field.Path, ref v1beta1.EnvFromFieldRef) field.ErrorList {
+	var errs field.ErrorList
+	if ref.Path == "" {
+		errs = append(errs, field.Required(fp.Child("path"), "path is required"))
+	}
+	errs = append(errs, validateRefPath(fp.Child("path"), ref.Path)...)
+	if ref.Prefix != "" && !envKeyRegex.MatchString(ref.Prefix) {
+		errs = append(errs, field.Invalid(fp.Child("prefix"), ref.Prefix, "must be a valid environment variable name prefix, like XR_"))
+	}
+	switch ref.NameCase {
+	case "", v1beta1.EnvNameCaseUpperSnake, v1beta1.EnvNameCaseUpper, v1beta1.EnvNameCasePreserve:
+	default:
+		errs = append(errs, field.NotSupported(fp.Child("nameCase"), ref.NameCase, []v1beta1.EnvNameCase{v1beta1.EnvNameCaseUpperSnake, v1beta1.EnvNameCaseUpper, v1beta1.EnvNameCasePreserve}))
+	}
+	errs = append(errs, validateFieldRefPolicy(fp.Child("policy"), ref.Policy)...)
+	errs = append(errs, validateFieldRefFormat(fp.Child("format"), ref.Format)...)
+	return errs
+}
+
+func validateEnvFileRef(fp *field.Path, ref v1beta1.EnvFileRef) field.ErrorList {
+	var errs field.ErrorList
+	if !filepath.IsAbs(ref.Path) {
+		errs = append(errs, field.Invalid(fp.Child("path"), ref.Path, "must be an absolute path in the function pod"))
+	}
+	errs = append(errs, validateEnvFormat(fp.Child("format"), ref.Format)...)
+	errs = append(errs, validateEnvKeys(fp, ref.Keys, ref.OptionalKeys)...)
+	return errs
+}
+
+// validateEnvKeys validates the keys and optionalKeys of an env source. Keys
+// may include the wildcard *.
+func validateEnvKeys(fp *field.Path, keys, optionalKeys []string) field.ErrorList {
+	var errs field.ErrorList
+	for i, k := range keys {
+		if k != envAllKeys && !envKeyRegex.MatchString(k) {
+			errs = append(errs, field.Invalid(fp.Child("keys").Index(i), k, "must be a valid environment variable name, like API_KEY, or *"))
+		}
+	}
+	for i, k := range optionalKeys {
+		if !envKeyRegex.MatchString(k) {
+			errs = append(errs, field.Invalid(fp.Child("optionalKeys").Index(i), k, "must be a valid environment variable name, like API_KEY"))
+		}
+	}
+	return errs
+}
+
+func validateEnvFormat(fp *field.Path, f v1beta1.EnvFormat) field.ErrorList {
+	switch f {
+	case "", v1beta1.EnvFormatJSON, v1beta1.EnvFormatYAML, v1beta1.EnvFormatDotenv:
+		return nil
+	default:
+		return field.ErrorList{field.NotSupported(fp, f, []v1beta1.EnvFormat{v1beta1.EnvFormatJSON, v1beta1.EnvFormatYAML, v1beta1.EnvFormatDotenv})}
+	}
+}
+
+// countSet returns the number of true values.
+func countSet(set ...bool) int {
+	n := 0
+	for _, s := range set {
+		if s {
+			n++
+		}
+	}
+	return n
+}
+
+func validateFieldRefPolicy(fp *field.Path, p v1beta1.FieldRefPolicy) field.ErrorList {
+	switch p {
+	case "", v1beta1.FieldRefPolicyOptional, v1beta1.FieldRefPolicyRequired:
+		return nil
+	default:
+		return field.ErrorList{field.NotSupported(fp, p, []v1beta1.FieldRefPolicy{v1beta1.FieldRefPolicyOptional, v1beta1.FieldRefPolicyRequired})}
+	}
+}
+
+func validateFieldRefFormat(fp *field.Path, f v1beta1.FieldRefFormat) field.ErrorList {
+	switch f {
+	case "", v1beta1.FieldRefFormatRaw, v1beta1.FieldRefFormatJSON, v1beta1.FieldRefFormatYAML, v1beta1.FieldRefFormatBase64, v1beta1.FieldRefFormatCSV:
+		return nil
+	default:
+		return field.ErrorList{field.NotSupported(fp, f, []v1beta1.FieldRefFormat{v1beta1.FieldRefFormatRaw, v1beta1.FieldRefFormatJSON, v1beta1.FieldRefFormatYAML, v1beta1.FieldRefFormatBase64, v1beta1.FieldRefFormatCSV})}
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/crossplane-contrib/function-shell/input/v1beta1"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	"github.com/crossplane/function-sdk-go/resource"
+	"github.com/crossplane/function-sdk-go/resource/composite"
+)
+
+func TestValidateParameters(t *testing.T) {
+	root := field.NewPath("parameters")
+
+	type args struct {
+		in     *v1beta1.Parameters
+		policy *Policy
+	}
+
+	cases := map[string]struct {
+		reason string
+		args   args
+		want   field.ErrorList
+	}{
+		"Valid": {
+			reason: "Valid Parameters should have no errors.",
+			args: args{
+				in: &v1beta1.Parameters{
+					Command:  v1beta1.Command{Inline: "echo hello"},
+					Outputs:  v1beta1.Outputs{Stdout: v1beta1.Stdout{Field: "status.atFunction.shell.stdout"}},
+					CacheTTL: "1m",
+				},
+			},
+		},
+		"NoCommand": {
+			reason: "Parameters without a shell command should be invalid.",
+			args: args{
+				in: &v1beta1.Parameters{},
+			},
+			want: field.ErrorList{
+				field.Required(root.Child("command", "inline"), ""),
+			},
+		},
+		"AllErrors": {
+			reason: "Every invalid field should be reported, not just the first.",
+			args: args{
+				in: &v1beta1.Parameters{
+					Command: v1beta1.Command{
+						Inline:  "echo hello",
+						Network: "Host",
+						Timeout: "-",
+					},
+					Env: v1beta1.Env{
+						Vars: []v1beta1.EnvVar{
+							{Name: "1FOO", Value: "bar"},
+							{Name: "BAR", Value: "bar", FieldRef: &v1beta1.FieldRef{Path: "spec.bar"}},
+						},
+					},
+					Outputs: v1beta1.Outputs{
+						Stdout: v1beta1.Stdout{Field: "status..stdout"},
+						ConnectionDetails: []v1beta1.ConnectionDetail{
+							{OutputFile: "out.json"},
+						},
+						OnFailure: "Ignore",
+					},
+					CacheTTL: "soon",
+				},
+			},
+			want: field.ErrorList{
+				field.NotSupported(root.Child("command", "network"), v1beta1.Network("Host"), []v1beta1.Network{}),
+				field.NotSupported(root.Child("outputs", "onFailure"), v1beta1.OnFailure("Ignore"), []v1beta1.OnFailure{}),
+				field.Required(root.Child("outputs", "connectionDetails").Index(0).Child("name"), ""),
+				field.Invalid(root.Child("outputs", "connectionDetails").Index(0).Child("outputFile"), "out.json", ""),
+				field.Invalid(root.Child("cacheTTL"), "soon", ""),
+				field.Invalid(root.Child("command", "timeout"), "-", ""),
+				field.Invalid(root.Child("env", "vars").Index(0).Child("name"), "1FOO", ""),
+				field.Invalid(root.Child("env", "vars").Index(1), "BAR", ""),
+				field.Invalid(root.Child("outputs", "stdout", "field"), "status..stdout", ""),
+			},
+		},
+		"EnvFromErrors": {
+			reason: "Each env source should set exactly one of envVarRef or fieldRef, and a fieldRef should be valid.",
+			args: args{
+				in: &v1beta1.Parameters{
+					Command: v1beta1.Command{Inline: "env"},
+					Env: v1beta1.Env{
+						From: []v1beta1.EnvFromSource{
+							{},
+							{EnvVarRef: &v1beta1.EnvVarRef{Name: "SECRETS"}, FieldRef: &v1beta1.EnvFromFieldRef{Path: "spec"}},
+							{FieldRef: &v1beta1.EnvFromFieldRef{Path: "resources[bucket].status[", Prefix: "1X", NameCase: "Lower"}},
+						},
+					},
+				},
+			},
+			want: field.ErrorList{
+				field.Required(root.Child("env", "from").Index(0), ""),
+				field.Invalid(root.Child("env", "from").Index(1), nil, ""),
+				field.Invalid(root.Child("env", "from").Index(2).Child("fieldRef", "path"), nil, ""),
+				field.Invalid(root.Child("env", "from").Index(2).Child("fieldRef", "prefix"), nil, ""),
+				field.NotSupported(root.Child("env", "from").Index(2).Child("fieldRef", "nameCase"), nil, []string{}),
+			},
+		},
+		"FieldExpressions": {
+			reason: "Paths with wildcards and filters should be valid, and invalid filters reported.",
+			args: args{
+				in: &v1beta1.Parameters{
+					Command: v1beta1.Command{Inline: "echo $PORT"},
+					Env: v1beta1.Env{
+						Vars: []v1beta1.EnvVar{
+							{Name: "PORT", FieldRef: &v1beta1.FieldRef{Path: `spec.ports[?(@.name=="http")].port`}},
+							{Name: "NAMES", FieldRef: &v1beta1.FieldRef{Path: `spec.ports[*].name`}},
+							{Name: "BAD", FieldRef: &v1beta1.FieldRef{Path: `spec.ports[?(@.name=="http"].port`}},
+						},
+					},
+					Outputs: v1beta1.Outputs{Stdout: v1beta1.Stdout{Field: `status.ports[?(@.name=~"^http")].url`}},
+				},
+			},
+			want: field.ErrorList{
+				field.Invalid(root.Child("env", "vars").Index(2).Child("fieldRef", "path"), nil, ""),
+			},
+		},
+		"WhenErrors": {
+			reason: "Field conditions without a path, invalid regular expressions and invalid CEL expressions should be reported.",
+			args: args{
+				in: &v1beta1.Parameters{
+					When: &v1beta1.When{
+						Fields: []v1beta1.WhenField{
+							{Path: "context[env].stage", Matches: "^prod"},
+							{Matches: "("},
+						},
+						CEL: "xr.spec.replicas >",
+					},
+					Command: v1beta1.Command{Inline: "echo hello"},
+				},
+			},
+			want: field.ErrorList{
+				field.Required(root.Child("when", "fields").Index(1).Child("path"), ""),
+				field.Invalid(root.Child("when", "fields").Index(1).Child("matches"), nil, ""),
+				field.Invalid(root.Child("when", "cel"), nil, ""),
+			},
+		},
+		"OutputTargetErrors": {
+			reason: "Output targets without a destination, and with invalid sources, files or paths, should be reported.",
+			args: args{
+				in: &v1beta1.Parameters{
+					Command: v1beta1.Command{Inline: "echo hello"},
+					Outputs: v1beta1.Outputs{
+						Files: []v1beta1.OutputFile{{Path: "out.json", Field: "status.out"}},
+						Targets: []v1beta1.OutputTarget{
+							{Source: v1beta1.OutputSourceFile, File: "out.json", FieldPath: "items[*].name", Field: "status.names"},
+							{Source: v1beta1.OutputSourceExitCode},
+							{Source: "Stdin", ContextKey: "stdin"},
+							{Source: v1beta1.OutputSourceFile, File: "other.json", ContextKey: "other"},
+							{Source: v1beta1.OutputSourceStderr, File: "out.json", ConnectionDetail: "stderr"},
+							{FieldPath: "a..b", Field: "desiredResources[bucket].spec..name"},
+						},
+					},
+				},
+			},
+			want: field.ErrorList{
+				field.Required(root.Child("outputs", "targets").Index(1), ""),
+				field.NotSupported(root.Child("outputs", "targets").Index(2).Child("source"), v1beta1.OutputSource("Stdin"), []v1beta1.OutputSource{}),
+				field.Invalid(root.Child("outputs", "targets").Index(3).Child("file"), nil, ""),
+				field.Invalid(root.Child("outputs", "targets").Index(4).Child("file"), nil, ""),
+				field.Invalid(root.Child("outputs", "targets").Index(5).Child("fieldPath"), nil, ""),
+				field.Invalid(root.Child("outputs", "targets").Index(5).Child("field"), nil, ""),
+			},
+		},
+		"DesiredResourcesFields": {
+			reason: "Fields of desired composed resources should be valid outputs, and their paths validated.",
+			args: args{
+				in: &v1beta1.Parameters{
+					Command: v1beta1.Command{Inline: "echo hello"},
+					Outputs: v1beta1.Outputs{
+						Stdout: v1beta1.Stdout{Field: "desiredResources[instance].spec.forProvider.ami"},
+						Stderr: v1beta1.Stderr{Field: "desiredResources[instance]"},
+						Files:  []v1beta1.OutputFile{{Path: "out.json", Field: "desiredResources[instance].spec..tags"}},
+					},
+				},
+			},
+			want: field.ErrorList{
+				field.Invalid(root.Child("outputs", "stderr", "field"), nil, ""),
+				field.Invalid(root.Child("outputs", "files").Index(0).Child("field"), nil, ""),
+			},
+		},
+		"OutputTransformErrors": {
+			reason: "Invalid CEL expressions, and expressions of files that aren't output files, should be reported.",
+			args: args{
+				in: &v1beta1.Parameters{
+					Command: v1beta1.Command{Inline: "echo hello"},
+					Outputs: v1beta1.Outputs{
+						Files: []v1beta1.OutputFile{{Path: "out.json", Field: "status.out"}},
+					},
+					OutputTransform: &v1beta1.OutputTransform{
+						Stdout: "stdout.split(",
+						Stderr: "stderr.trim()",
+						Files: []v1beta1.OutputFileTransform{
+							{Path: "out.json", Expression: "file.items.map(i, i.name)"},
+							{Path: "other.json"},
+						},
+					},
+				},
+			},
+			want: field.ErrorList{
+				field.Invalid(root.Child("outputTransform", "stdout"), nil, ""),
+				field.Invalid(root.Child("outputTransform", "files").Index(1).Child("path"), nil, ""),
+				field.Required(root.Child("outputTransform", "files").Index(1).Child("expression"), ""),
+			},
+		},
+		"PolicyAndFieldErrors": {
+			reason: "A command denied by policy should be reported with the other errors.",
+			args: args{
+				in: &v1beta1.Parameters{
+					Command: v1beta1.Command{Inline: "curl http://example.org"},
+					Outputs: v1beta1.Outputs{Stdout: v1beta1.Stdout{Format: "XML"}},
+				},
+				policy: &Policy{Rules: []PolicyRule{{Executables: PolicyList{Deny: []string{"curl"}}}}},
+			},
+			want: field.ErrorList{
+				field.NotSupported(root.Child("outputs", "stdout", "format"), v1beta1.OutputFormat("XML"), []v1beta1.OutputFormat{}),
+				field.Forbidden(root.Child("command", "inline"), ""),
+			},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			oxr := &resource.Composite{Resource: composite.New()}
+			got := ValidateParameters(tc.args.in, oxr, tc.args.policy)
+
+			// Only compare the type and field of each error, not its details.
+			if diff := cmp.Diff(tc.want, got, cmpopts.EquateEmpty(), cmpopts.IgnoreFields(field.Error{}, "BadValue", "Detail", "Origin", "CoveredByDeclarative")); diff != "" {
+				t.Errorf("%s\nValidateParameters(...): -want, +got:\n%s", tc.reason, diff)
+			}
+		})
+	}
+}
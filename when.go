@@ -0,0 +1,72 @@
+package main
+
+import (
+	"regexp"
+
+	"github.com/crossplane-contrib/function-shell/input/v1beta1"
+	"github.com/crossplane/crossplane-runtime/v2/pkg/errors"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+)
+
+// evaluateWhen returns true if every field condition and the CEL expression
+// of the supplied condition are true.
+func evaluateWhen(req *fnv1.RunFunctionRequest, w *v1beta1.When) (bool, error) {
+	for _, f := range w.Fields {
+		ok, err := evaluateWhenField(req, f)
+		if err != nil {
+			return false, errors.Wrapf(err, "cannot evaluate field %s", f.Path)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	if w.CEL == "" {
+		return true, nil
+	}
+	v, err := evalCEL(w.CEL, celVars(req), celRequestVars...)
+	if err != nil {
+		return false, err
+	}
+	ok, isBool := v.(bool)
+	if !isBool {
+		return false, errors.Errorf("CEL expression must evaluate to a bool, got %T", v)
+	}
+	return ok, nil
+}
+
+// evaluateWhenField returns true if the supplied field condition is true. A
+// field that doesn't exist neither equals nor matches anything.
+func evaluateWhenField(req *fnv1.RunFunctionRequest, f v1beta1.WhenField) (bool, error) {
+	src, err := getFieldSource(req, f.Path)
+	if err != nil {
+		return false, err
+	}
+	v, err := src.value()
+	exists := err == nil
+
+	if f.Exists != nil && *f.Exists != exists {
+		return false, nil
+	}
+	if f.Equals == nil && f.Matches == "" {
+		return exists || f.Exists != nil, nil
+	}
+	if !exists {
+		return false, nil
+	}
+	s, err := rawValue(v)
+	if err != nil {
+		return false, err
+	}
+	if f.Equals != nil && *f.Equals != s {
+		return false, nil
+	}
+	if f.Matches != "" {
+		re, err := regexp.Compile(f.Matches)
+		if err != nil {
+			return false, errors.Wrap(err, "cannot compile regular expression")
+		}
+		return re.MatchString(s), nil
+	}
+	return true, nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/crossplane-contrib/function-shell/input/v1beta1"
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/utils/ptr"
+
+	fnv1 "github.com/crossplane/function-sdk-go/proto/v1"
+	"github.com/crossplane/function-sdk-go/resource"
+)
+
+func TestEvaluateWhen(t *testing.T) {
+	req := &fnv1.RunFunctionRequest{
+		Observed: &fnv1.State{
+			Composite: &fnv1.Resource{
+				Resource: resource.MustStructJSON(`{
+					"apiVersion": "",
+					"kind": "",
+					"spec": {
+						"enabled": true,
+						"region": "eu-west-1",
+						"replicas": 3
+					}
+				}`),
+			},
+			Resources: map[string]*fnv1.Resource{
+				"bucket": {
+					Resource: resource.MustStructJSON(`{
+						"apiVersion": "",
+						"kind": "",
+						"status": {"ready": true}
+					}`),
+				},
+			},
+		},
+		Context: resource.MustStructJSON(`{"env": {"stage": "prod"}}`),
+	}
+
+	type want struct {
+		run bool
+		err bool
+	}
+
+	cases := map[string]struct {
+		reason string
+		when   v1beta1.When
+		want   want
+	}{
+		"Empty": {
+			reason: "A condition without fields or a CEL expression should be true.",
+			when:   v1beta1.When{},
+			want:   want{run: true},
+		},
+		"FieldExists": {
+			reason: "A field condition with no test set should be true if the field exists.",
+			when:   v1beta1.When{Fields: []v1beta1.WhenField{{Path: "spec.region"}}},
+			want:   want{run: true},
+		},
+		"FieldMissing": {
+			reason: "A field condition with no test set should be false if the field doesn't exist.",
+			when:   v1beta1.When{Fields: []v1beta1.WhenField{{Path: "spec.zone"}}},
+			want:   want{run: false},
+		},
+		"FieldNotExists": {
+			reason: "A field condition with exists false should be true if the field doesn't exist.",
+			when:   v1beta1.When{Fields: []v1beta1.WhenField{{Path: "spec.zone", Exists: ptr.To(false)}}},
+			want:   want{run: true},
+		},
+		"FieldEquals": {
+			reason: "Values that aren't strings should be compared in the Raw format.",
+			when: v1beta1.When{Fields: []v1beta1.WhenField{
+				{Path: "spec.enabled", Equals: ptr.To("true")},
+				{Path: "spec.replicas", Equals: ptr.To("3")},
+			}},
+			want: want{run: true},
+		},
+		"FieldNotEquals": {
+			reason: "Every field condition should be true.",
+			when: v1beta1.When{Fields: []v1beta1.WhenField{
+				{Path: "spec.enabled", Equals: ptr.To("true")},
+				{Path: "spec.region", Equals: ptr.To("us-east-1")},
+			}},
+			want: want{run: false},
+		},
+		"FieldMatches": {
+			reason: "A field should match a regular expression.",
+			when:   v1beta1.When{Fields: []v1beta1.WhenField{{Path: "spec.region", Matches: "^eu-"}}},
+			want:   want{run: true},
+		},
+		"MissingFieldNeverEquals": {
+			reason: "A field that doesn't exist shouldn't equal anything, not even an empty string.",
+			when:   v1beta1.When{Fields: []v1beta1.WhenField{{Path: "spec.zone", Equals: ptr.To("")}}},
+			want:   want{run: false},
+		},
+		"ContextAndComposed": {
+			reason: "Field conditions should support context keys and observed composed resources.",
+			when: v1beta1.When{Fields: []v1beta1.WhenField{
+				{Path: "context[env].stage", Equals: ptr.To("prod")},
+				{Path: "resources[bucket].status.ready", Equals: ptr.To("true")},
+			}},
+			want: want{run: true},
+		},
+		"CEL": {
+			reason: "A CEL expression should have the observed composite resource, the context and the observed composed resources as variables.",
+			when:   v1beta1.When{CEL: `xr.spec.replicas > 2 && context.env.stage == "prod" && resources.bucket.status.ready`},
+			want:   want{run: true},
+		},
+		"CELFalse": {
+			reason: "Both the field conditions and the CEL expression should be true.",
+			when: v1beta1.When{
+				Fields: []v1beta1.WhenField{{Path: "spec.region"}},
+				CEL:    `has(xr.spec.zone)`,
+			},
+			want: want{run: false},
+		},
+		"CELNotBool": {
+			reason: "A CEL expression that doesn't evaluate to a bool should be an error.",
+			when:   v1beta1.When{CEL: `xr.spec.region`},
+			want:   want{err: true},
+		},
+		"CELError": {
+			reason: "A CEL expression that fails to evaluate should be an error.",
+			when:   v1beta1.When{CEL: `xr.spec.zone == "a"`},
+			want:   want{err: true},
+		},
+	}
+
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			run, err := evaluateWhen(req, &tc.when)
+
+			if diff := cmp.Diff(tc.want.run, run); diff != "" {
+				t.Errorf("%s\nevaluateWhen(...): -want, +got:\n%s", tc.reason, diff)
+			}
+			if (err != nil) != tc.want.err {
+				t.Errorf("%s\nevaluateWhen(...): want error %t, got %v", tc.reason, tc.want.err, err)
+			}
+		})
+	}
+}